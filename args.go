@@ -0,0 +1,231 @@
+package main
+
+import "strings"
+
+// goCommand is a parsed got command line, split the same way the go
+// command splits it.
+type goCommand struct {
+	// name is the go command being executed (build, run or test).
+	name string
+
+	// flags are the build and test flags, with their values.
+	flags []string
+
+	// packages are the target packages or .go files.
+	packages []string
+
+	// args are the arguments passed to the program (run) or to the
+	// test binary (test, after -args).
+	args []string
+
+	// constraints and decoratorTimeout are the values of the got
+	// -constraints and -decorator-timeout flags, which are not passed to
+	// the go command.
+	constraints      string
+	decoratorTimeout string
+}
+
+// buildValueFlags and testValueFlags are the go command flags that take
+// the next argument as their value when it is not given with `=`.
+var buildValueFlags = map[string]bool{
+	"C": true, "asmflags": true, "buildmode": true, "compiler": true,
+	"coverpkg": true, "covermode": true, "exec": true, "gccgoflags": true,
+	"gcflags": true, "installsuffix": true, "ldflags": true, "mod": true,
+	"modfile": true, "o": true, "overlay": true, "p": true, "pgo": true,
+	"pkgdir": true, "tags": true, "toolexec": true,
+}
+
+var testValueFlags = map[string]bool{
+	"bench": true, "benchtime": true, "blockprofile": true,
+	"blockprofilerate": true, "count": true, "coverprofile": true,
+	"cpu": true, "cpuprofile": true, "fuzz": true, "fuzzminimizetime": true,
+	"fuzztime": true, "list": true, "memprofile": true,
+	"memprofilerate": true, "mutexprofile": true,
+	"mutexprofilefraction": true, "outputdir": true, "parallel": true,
+	"run": true, "shuffle": true, "skip": true, "timeout": true,
+	"trace": true, "vet": true,
+}
+
+// isValueFlag reports whether the flag takes the next argument as its
+// value. Like with the go command, the test flags can also be given with
+// their `test.` prefix, such as `-test.run`.
+func isValueFlag(name string) bool {
+	return buildValueFlags[name] || testValueFlags[strings.TrimPrefix(name, "test.")]
+}
+
+// parseGoCommand parses the arguments of a got build, run or test command.
+// The first argument is the command name.
+// Like `go run`, the run command takes every argument after the package
+// (or after the list of .go files) as a program argument.
+func parseGoCommand(args []string) goCommand {
+	cmd := goCommand{name: args[0]}
+	gotFlags := map[string]*string{
+		"constraints":       &cmd.constraints,
+		"decorator-timeout": &cmd.decoratorTimeout,
+	}
+
+	rest := args[1:]
+	for i := 0; i < len(rest); i++ {
+		arg := rest[i]
+
+		if cmd.name == "test" && (arg == "-args" || arg == "--args") {
+			cmd.args = rest[i+1:]
+			break
+		}
+
+		if len(arg) > 1 && arg[0] == '-' {
+			name := strings.TrimLeft(arg, "-")
+
+			key, value, hasValue := strings.Cut(name, "=")
+			if flag, ok := gotFlags[key]; ok {
+				if hasValue {
+					*flag = value
+					continue
+				}
+				if i+1 < len(rest) {
+					i++
+					*flag = rest[i]
+					continue
+				}
+			}
+
+			cmd.flags = append(cmd.flags, arg)
+			if !strings.Contains(name, "=") && isValueFlag(name) && i+1 < len(rest) {
+				i++
+				cmd.flags = append(cmd.flags, rest[i])
+			}
+			continue
+		}
+
+		if cmd.name == "run" {
+			end := i + 1
+			if strings.HasSuffix(arg, ".go") {
+				for end < len(rest) && strings.HasSuffix(rest[end], ".go") {
+					end++
+				}
+			}
+
+			cmd.packages = rest[i:end]
+			cmd.args = rest[end:]
+			break
+		}
+
+		cmd.packages = append(cmd.packages, arg)
+	}
+
+	return cmd
+}
+
+// commandLine returns the arguments for the go command.
+func (c goCommand) commandLine() []string {
+	args := []string{c.name}
+	args = append(args, c.flags...)
+	args = append(args, c.packages...)
+
+	if c.name == "test" && len(c.args) > 0 {
+		args = append(args, "-args")
+		args = append(args, c.args...)
+	}
+
+	return args
+}
+
+// hasFlag reports whether the flag is set, with or without a value.
+func hasFlag(flags []string, name string) bool {
+	for _, flag := range flags {
+		flag = strings.TrimLeft(flag, "-")
+		if flag == name || strings.HasPrefix(flag, name+"=") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flagValue returns the value of the last occurrence of a flag, given
+// either as the next argument or with `=`.
+func flagValue(flags []string, name string) (string, bool) {
+	value, found := "", false
+
+	for i := 0; i < len(flags); i++ {
+		if !strings.HasPrefix(flags[i], "-") {
+			continue
+		}
+
+		flag := strings.TrimLeft(flags[i], "-")
+		if flag == name && i+1 < len(flags) {
+			i++
+			value, found = flags[i], true
+		} else if strings.HasPrefix(flag, name+"=") {
+			value, found = strings.TrimPrefix(flag, name+"="), true
+		}
+	}
+
+	return value, found
+}
+
+// addGeneratedTag adds the "generated" build tag to the flags, either by
+// extending the existing -tags flag or by adding a new one.
+func addGeneratedTag(flags []string) []string {
+	result := append([]string{}, flags...)
+
+	for i := 0; i < len(result); i++ {
+		name := strings.TrimLeft(result[i], "-")
+		if !strings.HasPrefix(result[i], "-") {
+			continue
+		}
+
+		if name == "tags" && i+1 < len(result) {
+			result[i+1] = appendTag(result[i+1])
+			return result
+		}
+
+		if strings.HasPrefix(name, "tags=") {
+			result[i] = "-tags=" + appendTag(strings.TrimPrefix(name, "tags="))
+			return result
+		}
+	}
+
+	return append([]string{"-tags", "generated"}, result...)
+}
+
+// appendTag appends the "generated" tag to a comma-separated tag list.
+func appendTag(tags string) string {
+	if tags == "" {
+		return "generated"
+	}
+	return tags + ",generated"
+}
+
+// buildTags returns the build tags set by the -tags flag, except for the
+// "generated" tag.
+func buildTags(flags []string) []string {
+	tags := []string{}
+
+	for i := 0; i < len(flags); i++ {
+		name := strings.TrimLeft(flags[i], "-")
+		if !strings.HasPrefix(flags[i], "-") {
+			continue
+		}
+
+		var value string
+		if name == "tags" && i+1 < len(flags) {
+			value = flags[i+1]
+		} else if strings.HasPrefix(name, "tags=") {
+			value = strings.TrimPrefix(name, "tags=")
+		} else {
+			continue
+		}
+
+		tags = tags[:0]
+		for _, tag := range strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || r == ' '
+		}) {
+			if tag != "generated" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags
+}
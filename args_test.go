@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testParseGoCommand(t *testing.T, args []string, expected goCommand) {
+	cmd := parseGoCommand(args)
+
+	if cmd.name != expected.name {
+		t.Errorf("Expected command %s, got %s", expected.name, cmd.name)
+	}
+	if !reflect.DeepEqual(cmd.flags, expected.flags) {
+		t.Errorf("Expected flags %q, got %q", expected.flags, cmd.flags)
+	}
+	if !reflect.DeepEqual(cmd.packages, expected.packages) {
+		t.Errorf("Expected packages %q, got %q", expected.packages, cmd.packages)
+	}
+	if !reflect.DeepEqual(cmd.args, expected.args) {
+		t.Errorf("Expected args %q, got %q", expected.args, cmd.args)
+	}
+	if cmd.constraints != expected.constraints {
+		t.Errorf("Expected constraints %s, got %s", expected.constraints, cmd.constraints)
+	}
+	if cmd.decoratorTimeout != expected.decoratorTimeout {
+		t.Errorf("Expected decorator timeout %s, got %s", expected.decoratorTimeout, cmd.decoratorTimeout)
+	}
+}
+
+func TestParseGoCommand(t *testing.T) {
+	testParseGoCommand(t,
+		[]string{"run", "-v", "-tags", "foo", "main.go", "-v", "bar"},
+		goCommand{
+			name:     "run",
+			flags:    []string{"-v", "-tags", "foo"},
+			packages: []string{"main.go"},
+			args:     []string{"-v", "bar"},
+		},
+	)
+
+	testParseGoCommand(t,
+		[]string{"run", "a.go", "b.go", "c"},
+		goCommand{
+			name:     "run",
+			packages: []string{"a.go", "b.go"},
+			args:     []string{"c"},
+		},
+	)
+
+	testParseGoCommand(t,
+		[]string{"run", "-race", "./cmd/foo", "b.go"},
+		goCommand{
+			name:     "run",
+			flags:    []string{"-race"},
+			packages: []string{"./cmd/foo"},
+			args:     []string{"b.go"},
+		},
+	)
+
+	testParseGoCommand(t,
+		[]string{"test", "-tags=foo", "-run", "TestX", ".", "-count=1", "-args", "-x"},
+		goCommand{
+			name:     "test",
+			flags:    []string{"-tags=foo", "-run", "TestX", "-count=1"},
+			packages: []string{"."},
+			args:     []string{"-x"},
+		},
+	)
+
+	testParseGoCommand(t,
+		[]string{"test", "./...", "-test.run", "TestX", "-test.v"},
+		goCommand{
+			name:     "test",
+			flags:    []string{"-test.run", "TestX", "-test.v"},
+			packages: []string{"./..."},
+		},
+	)
+
+	testParseGoCommand(t,
+		[]string{"build", "-constraints", "verify", "-decorator-timeout=5s", "-v", "."},
+		goCommand{
+			name:             "build",
+			flags:            []string{"-v"},
+			packages:         []string{"."},
+			constraints:      "verify",
+			decoratorTimeout: "5s",
+		},
+	)
+}
+
+func TestAddGeneratedTag(t *testing.T) {
+	cases := map[string][2][]string{
+		"no tags":    {{"-v"}, {"-tags", "generated", "-v"}},
+		"tags value": {{"-tags", "foo"}, {"-tags", "foo,generated"}},
+		"tags equal": {{"-tags=foo,bar"}, {"-tags=foo,bar,generated"}},
+		"empty tags": {{"--tags="}, {"-tags=generated"}},
+	}
+
+	for name, c := range cases {
+		result := addGeneratedTag(c[0])
+		if !reflect.DeepEqual(result, c[1]) {
+			t.Errorf("%s: expected %q, got %q", name, c[1], result)
+		}
+	}
+}
+
+func TestBuildTags(t *testing.T) {
+	cases := map[string][2][]string{
+		"no tags":    {{"-v"}, {}},
+		"tags value": {{"-tags", "foo,generated"}, {"foo"}},
+		"tags equal": {{"-tags=foo bar"}, {"foo", "bar"}},
+	}
+
+	for name, c := range cases {
+		result := buildTags(c[0])
+		if !reflect.DeepEqual(result, c[1]) {
+			t.Errorf("%s: expected %q, got %q", name, c[1], result)
+		}
+	}
+}
+
+func TestFlagValue(t *testing.T) {
+	flags := []string{"-v", "-p", "4", "-run", "TestX", "-tags=foo"}
+
+	if value, ok := flagValue(flags, "p"); !ok || value != "4" {
+		t.Errorf("Expected 4, got %q", value)
+	}
+	if value, ok := flagValue(flags, "tags"); !ok || value != "foo" {
+		t.Errorf("Expected foo, got %q", value)
+	}
+	if _, ok := flagValue(flags, "o"); ok {
+		t.Errorf("Expected -o to be missing")
+	}
+}
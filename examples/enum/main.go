@@ -13,8 +13,7 @@ import (
 	got "github.com/pedronasser/got/transform"
 )
 
-// #[Enum]
-type PokemonType string
+// The PokemonType enum type is declared in types.go
 
 // #[Options(PokemonType)]
 const (
@@ -55,47 +54,48 @@ func main() {
 }
 
 // #[decorator]
+// #[describe(Generates the options of an enum type)]
+// #[target(ConstDecl)]
+// #[arg(type, ident)]
 func Options(c *got.TransformContext) error {
-	file := c.File
 	args := c.Args()
-	if len(args) < 1 {
-		fmt.Println("Enum attribute requires the name of the type")
-		return nil
-	}
 
 	enumName := args[0]
 	fmt.Printf("Creating options for `%s`", enumName)
-	for _, decl := range file.Decls {
-		if v, ok := decl.(*ast.GenDecl); ok {
-			if v.Tok != token.TYPE {
-				continue
-			}
-			if len(v.Specs) < 1 {
-				continue
-			}
-			if typeSpec, ok := v.Specs[0].(*ast.TypeSpec); ok {
-				if typeSpec.Name.Name == enumName {
-					if _, ok := typeSpec.Type.(*ast.Ident); ok {
-						fmt.Printf("Found enum type `%s`", enumName)
-					}
-				}
+
+	// The enum type can be declared in any file of the package
+	found := false
+	for _, attribute := range c.FindAnnotated("Enum") {
+		if v, ok := attribute.Node.(*ast.GenDecl); ok && v.Tok == token.TYPE && len(v.Specs) > 0 {
+			if typeSpec, ok := v.Specs[0].(*ast.TypeSpec); ok && typeSpec.Name.Name == enumName {
+				fmt.Printf("Found enum type `%s`", enumName)
+				found = true
 			}
 		}
 	}
+	if !found {
+		return fmt.Errorf("type `%s` has no #[Enum] attribute", enumName)
+	}
 
 	enumValues := map[string]string{}
-	if v, ok := c.Node().(*ast.GenDecl); ok {
-		if v.Tok != token.CONST {
-			return nil
-		}
-		for _, spec := range v.Specs {
-			if valueSpec, ok := spec.(*ast.ValueSpec); ok {
-				fmt.Printf("Found enum `%s` value `%s`\n", args[0], valueSpec.Names[0].Name)
-				enumValues[valueSpec.Names[0].Name] = valueSpec.Values[0].(*ast.BasicLit).Value
+	for _, spec := range c.Node().(*ast.GenDecl).Specs {
+		if valueSpec, ok := spec.(*ast.ValueSpec); ok {
+			fmt.Printf("Found enum `%s` value `%s`\n", args[0], valueSpec.Names[0].Name)
+			if len(valueSpec.Values) == 0 {
+				return fmt.Errorf("enum `%s` value `%s` has no literal value", enumName, valueSpec.Names[0].Name)
 			}
+			value, ok := valueSpec.Values[0].(*ast.BasicLit)
+			if !ok {
+				return fmt.Errorf("enum `%s` value `%s` is not a literal", enumName, valueSpec.Names[0].Name)
+			}
+			enumValues[valueSpec.Names[0].Name] = value.Value
 		}
 	}
 
+	// The names of the helper types and of the marker method are fresh, so
+	// they can't collide with the names of the package
+	marker := c.FreshName(fmt.Sprintf("__%s", enumName))
+
 	// Create interface
 	c.InsertBefore(&ast.GenDecl{
 		Tok: token.TYPE,
@@ -107,7 +107,7 @@ func Options(c *got.TransformContext) error {
 						List: []*ast.Field{
 							{
 								Names: []*ast.Ident{
-									ast.NewIdent(fmt.Sprintf("__%s", enumName)),
+									ast.NewIdent(marker.Name),
 								},
 								Type: &ast.FuncType{},
 							},
@@ -119,7 +119,7 @@ func Options(c *got.TransformContext) error {
 	})
 
 	for k := range enumValues {
-		enumType := ast.NewIdent(fmt.Sprintf("__%s", k))
+		enumType := c.FreshName(fmt.Sprintf("__%s", k))
 
 		c.InsertBefore(&ast.GenDecl{
 			Tok: token.TYPE,
@@ -133,7 +133,7 @@ func Options(c *got.TransformContext) error {
 			},
 		})
 
-		enumTypeMethod := ast.NewIdent(fmt.Sprintf("__%s", enumName))
+		enumTypeMethod := ast.NewIdent(marker.Name)
 		c.InsertBefore(&ast.FuncDecl{
 			Name: enumTypeMethod,
 			Type: &ast.FuncType{},
@@ -174,6 +174,7 @@ func Options(c *got.TransformContext) error {
 }
 
 // #[decorator]
+// #[target(TypeDecl)]
 func Enum(c *got.TransformContext) (err error) {
 	c.Delete()
 
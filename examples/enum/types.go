@@ -0,0 +1,6 @@
+//go:build !generated
+
+package main
+
+// #[Enum]
+type PokemonType string
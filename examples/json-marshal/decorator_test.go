@@ -0,0 +1,13 @@
+package json_marshal
+
+import (
+	"testing"
+
+	"github.com/pedronasser/got/transform"
+	"github.com/pedronasser/got/transform/transformtest"
+)
+
+func TestJSONDecorator(t *testing.T) {
+	transformtest.Run(t, transformtest.TestData(),
+		map[string]transform.ExtractedDecorator{"JSON": JSON}, "user")
+}
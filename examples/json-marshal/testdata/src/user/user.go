@@ -0,0 +1,18 @@
+//go:build !generated
+
+package user
+
+import (
+	"time"
+
+	. "github.com/pedronasser/got/examples/json-marshal/json"
+)
+
+var _ = JSON_START_TOKEN
+
+// #[JSON]
+type User struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -43,36 +43,27 @@ func main() {
 
 // FromSchema is a decorator that will generate a struct from a JSON schema
 // The first argument is the path (relative to the current directory) of the JSON schema file
+// The spec of the decorator is validated by got before calling it: it only applies
+// to type declarations and requires the path of the schema
 // #[decorator]
+// #[describe(Generates the fields of a struct from a JSON schema)]
+// #[target(TypeDecl)]
+// #[arg(schema, string)]
 func FromSchema(c *transform.TransformContext) (err error) {
 	args := c.Args()
-	if len(args) < 1 {
-		return fmt.Errorf("FromSchema decorator requires the path to the JSON schema file")
-	}
-
-	target := c.Node()
 
-	// We are only interested in type declarations
-	if _, ok := target.(*ast.GenDecl); !ok {
-		// By returning a nil []byte we are not replacing anything
-		return nil
+	// We are only interested in struct types, declared alone
+	gen, ok := c.Node().(*ast.GenDecl)
+	if !ok || len(gen.Specs) != 1 {
+		return fmt.Errorf("FromSchema decorator can only be used on a single type declaration")
 	}
 
-	// We are only interested in type declarations
-	if target.(*ast.GenDecl).Tok != token.TYPE || len(target.(*ast.GenDecl).Specs) < 1 {
-		return nil
+	typeSpec, ok := gen.Specs[0].(*ast.TypeSpec)
+	if !ok {
+		return fmt.Errorf("FromSchema decorator can only be used on type declarations")
 	}
-
-	// We are only interested in type declarations
-	spec := target.(*ast.GenDecl).Specs[0]
-	if _, ok := spec.(*ast.TypeSpec); !ok {
-		return nil
-	}
-
-	// We are only interested in struct types
-	typeSpec := spec.(*ast.TypeSpec)
 	if _, ok := typeSpec.Type.(*ast.StructType); !ok {
-		return nil
+		return fmt.Errorf("FromSchema decorator can only be used on structs")
 	}
 
 	// Based on https://json-schema.org/draft/2020-12/schema
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	. "github.com/pedronasser/got/transform"
+)
+
+// runExplain executes the `got explain [-json] [-tags tags] file.go:line`
+// command, printing the attributes bound to the node at the line, the
+// decorators they resolve to, and the declaration before and after its
+// transformation. No file is written: the decorators of the package must
+// have been built by `got build`.
+func runExplain(args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	flags.SetOutput(w)
+	asJSON := flags.Bool("json", false, "print the explanation as JSON")
+	tags := flags.String("tags", "", "a comma-separated list of build tags")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: got explain [-json] [-tags tags] file.go:line")
+	}
+
+	filename, line, err := parseFileLine(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	opts := []Option{}
+	if *tags != "" {
+		opts = append(opts, WithTags(strings.Split(*tags, ",")...))
+	}
+
+	explanation, err := Explain(context.Background(), filename, line, opts...)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(explanation)
+	}
+
+	_, err = io.WriteString(w, explanation.String())
+	return err
+}
+
+// parseFileLine parses a `file.go:line` position.
+func parseFileLine(pos string) (string, int, error) {
+	i := strings.LastIndex(pos, ":")
+	if i < 0 {
+		return "", 0, fmt.Errorf("invalid position `%s`, expected file.go:line", pos)
+	}
+
+	line, err := strconv.Atoi(pos[i+1:])
+	if err != nil || line < 1 || !strings.HasSuffix(pos[:i], ".go") {
+		return "", 0, fmt.Errorf("invalid position `%s`, expected file.go:line", pos)
+	}
+
+	return pos[:i], line, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseFileLine(t *testing.T) {
+	filename, line, err := parseFileLine("dir/a.go:42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filename != "dir/a.go" || line != 42 {
+		t.Errorf("Expected dir/a.go:42, got %s:%d", filename, line)
+	}
+
+	for _, pos := range []string{"a.go", "a.go:x", "a.go:0", "dir:3"} {
+		if _, _, err := parseFileLine(pos); err == nil {
+			t.Errorf("Expected an error for `%s`", pos)
+		}
+	}
+}
+
+func TestRunExplain(t *testing.T) {
+	dir := t.TempDir()
+	src := `package p
+
+func A() {
+	// #[Mark]
+	println()
+}
+`
+	path := filepath.Join(dir, "a.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	if err := runExplain([]string{path + ":5"}, out); err != nil {
+		t.Fatal(err)
+	}
+
+	result := out.String()
+	for _, expected := range []string{
+		path + ":5: ExprStmt\n",
+		"\n#[Mark] at " + path + ":4:2 (unknown)\n",
+		"\nBefore:\n\tfunc A() {\n\t\t// #[Mark]\n",
+		"\nAfter:\n\tfunc A() {\n\t\t// #[Mark]\n",
+		"\nDiagnostics:\n\t" + path + ":4:2: unknown attribute `Mark`\n",
+	} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("Expected %q, got %s", expected, result)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected no file to be written, got %d files", len(entries))
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	. "github.com/pedronasser/got/transform"
+)
+
+// runList executes the `got list [-usages] [-json] [-test] [-tags tags]
+// [packages]` command, printing the decorators, methods and finalizers
+// declared by the packages, and with -usages the attributes bound to their
+// nodes.
+func runList(args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags.SetOutput(w)
+	usages := flags.Bool("usages", false, "list the attributes bound to the nodes")
+	asJSON := flags.Bool("json", false, "print the inventory as JSON")
+	tests := flags.Bool("test", false, "include the test files")
+	tags := flags.String("tags", "", "a comma-separated list of build tags")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	targetDirs, err := getTargetDirectories(flags.Args()...)
+	if err != nil {
+		return err
+	}
+
+	opts := []Option{WithTests(*tests)}
+	if *tags != "" {
+		opts = append(opts, WithTags(strings.Split(*tags, ",")...))
+	}
+
+	inventory := &Inventory{Functions: []ExtractedFunction{}}
+	for _, targetDir := range targetDirs {
+		result, err := List(context.Background(), targetDir, opts...)
+		if err != nil {
+			return err
+		}
+
+		inventory.Functions = append(inventory.Functions, result.Functions...)
+		if *usages {
+			inventory.Usages = append(inventory.Usages, result.Usages...)
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(inventory)
+	}
+
+	printInventory(w, inventory)
+	return nil
+}
+
+// printInventory prints the functions of the inventory with their doc and
+// spec, followed by the attribute usages.
+func printInventory(w io.Writer, inventory *Inventory) {
+	for _, fn := range inventory.Functions {
+		fmt.Fprintf(w, "%s %s\t%s\n", fn.Kind, fn.Name, fn.Pos)
+		if fn.Doc != "" {
+			for _, line := range strings.Split(fn.Doc, "\n") {
+				fmt.Fprintf(w, "\t%s\n", line)
+			}
+		}
+
+		if fn.Spec == nil {
+			continue
+		}
+		if fn.Spec.Description != "" {
+			fmt.Fprintf(w, "\tdescription: %s\n", fn.Spec.Description)
+		}
+		if len(fn.Spec.Targets) > 0 {
+			fmt.Fprintf(w, "\ttargets: %s\n", strings.Join(fn.Spec.Targets, ", "))
+		}
+		if len(fn.Spec.Args) > 0 || fn.Spec.Variadic {
+			args := []string{}
+			for _, arg := range fn.Spec.Args {
+				args = append(args, arg.String())
+			}
+			if fn.Spec.Variadic {
+				args = append(args, "...")
+			}
+			fmt.Fprintf(w, "\targs: %s\n", strings.Join(args, ", "))
+		}
+	}
+
+	if len(inventory.Usages) > 0 && len(inventory.Functions) > 0 {
+		fmt.Fprintln(w)
+	}
+	for _, usage := range inventory.Usages {
+		attribute := usage.Name
+		if len(usage.Args) > 0 {
+			attribute += "(" + strings.Join(usage.Args, ",") + ")"
+		}
+
+		target := usage.Target
+		if usage.TargetName != "" {
+			target += " " + usage.TargetName
+		}
+		fmt.Fprintf(w, "%s: #[%s] on %s at %s\n", usage.Pos, attribute, target, usage.TargetPos)
+	}
+}
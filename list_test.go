@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/pedronasser/got/transform"
+)
+
+func writeListPackage(t *testing.T) string {
+	dir := t.TempDir()
+	src := `package p
+
+// #[Log]
+func A() {}
+
+// Log logs the calls of a function.
+// #[decorator]
+// #[target(FuncDecl)]
+func Log(c *TransformContext) error { return nil }
+`
+	if err := os.WriteFile(filepath.Join(dir, "a.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestRunList(t *testing.T) {
+	dir := writeListPackage(t)
+
+	out := &bytes.Buffer{}
+	if err := runList([]string{"-usages", dir}, out); err != nil {
+		t.Fatal(err)
+	}
+
+	result := out.String()
+	for _, expected := range []string{
+		"decorator Log\t",
+		"\tLog logs the calls of a function.\n",
+		"\ttargets: FuncDecl\n",
+		"a.go:3:1: #[Log] on FuncDecl A at ",
+	} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("Expected %q, got %s", expected, result)
+		}
+	}
+}
+
+func TestRunListJSON(t *testing.T) {
+	dir := writeListPackage(t)
+
+	out := &bytes.Buffer{}
+	if err := runList([]string{"-json", dir}, out); err != nil {
+		t.Fatal(err)
+	}
+
+	inventory := Inventory{}
+	if err := json.Unmarshal(out.Bytes(), &inventory); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inventory.Functions) != 1 || inventory.Functions[0].Spec.Targets[0] != "FuncDecl" {
+		t.Errorf("Expected the Log decorator, got %s", out)
+	}
+	if len(inventory.Usages) != 0 {
+		t.Errorf("Expected no usages without -usages, got %s", out)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"strings"
+
+	. "github.com/pedronasser/got/transform"
+)
+
+// runLSP executes the `got lsp [-tags tags]` command, serving the Language
+// Server Protocol over the standard input and output.
+func runLSP(args []string, in io.Reader, out io.Writer, errOut io.Writer) error {
+	flags := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	tags := flags.String("tags", "", "a comma-separated list of build tags")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	opts := []Option{}
+	if *tags != "" {
+		opts = append(opts, WithTags(strings.Split(*tags, ",")...))
+	}
+
+	// The generated source is shown by this executable, see
+	// LanguageServer.GenerateCommand.
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	server := NewLanguageServer(opts...)
+	server.GenerateCommand = []string{exe, "show-generated", "-documents"}
+	if *tags != "" {
+		server.GenerateCommand = append(server.GenerateCommand, "-tags", *tags)
+	}
+	return server.Serve(context.Background(), in, out)
+}
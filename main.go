@@ -1,17 +1,29 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"go/build"
+	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	. "github.com/pedronasser/got/transform"
 )
 
 var Version string
 
+// verbose is set by the -v flag.
+var verbose bool
+
 // main is the entry point of the got command.
 // It checks if the command is a got command and executes it.
 // If it's not a got command, it executes the go command.
@@ -21,12 +33,51 @@ func main() {
 	if len(args) > 1 && (args[1] == "build" || args[1] == "run" || args[1] == "test") {
 		err := runGotCmd(args...)
 		if err != nil {
+			// Errors from the go command and the program itself were
+			// already reported on stderr, only their status is kept.
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				fmt.Println(err)
+			}
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if len(args) > 1 && args[1] == "list" {
+		if err := runList(args[2:], os.Stdout); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 		return
 	}
 
+	if len(args) > 1 && args[1] == "explain" {
+		if err := runExplain(args[2:], os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(args) > 1 && args[1] == "show-generated" {
+		if err := runShowGenerated(args[2:], os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// The standard output is the stream of the protocol, errors are
+	// reported on the standard error.
+	if len(args) > 1 && args[1] == "lsp" {
+		if err := runLSP(args[2:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if len(args) > 1 && args[1] == "version" {
 		fmt.Printf("got version %s\n", Version)
 	}
@@ -40,13 +91,9 @@ func main() {
 }
 
 // getArgs returns the command line arguments.
-// It also sets the VerboseLog variable if the -v flag is present.
 func getArgs() []string {
 	args := os.Args[:]
 	for i, arg := range args {
-		if arg == "-v" {
-			VerboseLog = true
-		}
 		if strings.HasSuffix(arg, GO_FILE_EXTENSION) {
 			args[i] = strings.Replace(arg, GO_FILE_EXTENSION, ".go", 1)
 		}
@@ -55,80 +102,136 @@ func getArgs() []string {
 }
 
 // runGotCmd executes a got command.
-// It checks if the command is a build, run or test command.
-// It checks if the -tags flag is present and adds the "generated" tag.
-// It checks if the -o flag is present and sets the output file.
-// Then it executes the got transformer.
+// It parses the command line, adds the "generated" tag to the build flags
+// and enables the verbose output if the -v flag is present.
+// Then it executes the got transformer on the target directory.
 // Then it executes the go command with the transformed arguments.
 func runGotCmd(args ...string) error {
-	commandName := args[1]
+	cmd := parseGoCommand(args[1:])
 
-	args = args[1:]
+	mode, err := ParseConstraintMode(cmd.constraints)
+	if err != nil {
+		return err
+	}
 
-	tagsFound := false
-	outputFile := ""
+	// The overlay replaces the source files, so the generated tag is not
+	// needed to select the generated files.
+	if mode == ConstraintsOverlay {
+		if hasFlag(cmd.flags, "overlay") {
+			return fmt.Errorf("the -overlay flag can't be used with `-constraints overlay`")
+		}
+	} else {
+		cmd.flags = addGeneratedTag(cmd.flags)
+	}
 
-	for i, arg := range args {
-		if arg == "-tags" {
-			tagsFound = true
-			args[i+1] = args[i+1] + ",generated"
-		} else if arg == "-o" {
-			outputFile = args[i+1]
+	for _, flag := range cmd.flags {
+		if flag == "-v" {
+			verbose = true
 		}
 	}
 
-	if commandName == "run" {
-		tmpFile, err := os.CreateTemp("", "gobuild")
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+	targetDirs, err := getTargetDirectories(cmd.packages...)
+	if err != nil {
+		return err
+	}
+
+	ctxt := build.Default
+	ctxt.BuildTags = buildTags(cmd.flags)
+
+	opts := []Option{
+		WithTests(cmd.name == "test"),
+		WithBuildContext(ctxt),
+		WithConstraints(mode),
+	}
+	if verbose {
+		opts = append(opts, WithLogger(log.New(os.Stdout, GOT_PREFIX+" ", 0)))
+	}
+	if Version != "" {
+		opts = append(opts, WithVersion(Version))
+	}
+
+	// Like the go command, -p sets how many files are transformed in
+	// parallel.
+	if value, ok := flagValue(cmd.flags, "p"); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid -p flag value `%s`", value)
 		}
-		tmpFile.Close()
+		opts = append(opts, WithParallelism(n))
+	}
 
-		outputFile = tmpFile.Name()
-		args = append(args[:len(args)-1], "-o", outputFile, args[len(args)-1])
+	// -decorator-timeout sets how long a decorator may run, 0 disabling
+	// the timeout.
+	if cmd.decoratorTimeout != "" {
+		timeout, err := time.ParseDuration(cmd.decoratorTimeout)
+		if err != nil || timeout < 0 {
+			return fmt.Errorf("invalid -decorator-timeout flag value `%s`", cmd.decoratorTimeout)
+		}
+		opts = append(opts, WithTimeout(timeout))
 	}
 
-	if !tagsFound {
-		args = append([]string{args[1], "-tags", "generated"}, args[2:]...)
+	overlay := map[string]string{}
+	for _, targetDir := range targetDirs {
+		result, err := NewTransformer(targetDir, opts...).Run(context.Background())
+		if err != nil {
+			return err
+		}
+
+		for _, file := range result.Files {
+			for _, diagnostic := range file.Diagnostics {
+				fmt.Fprintln(os.Stderr, diagnostic)
+			}
+		}
+
+		for source, generated := range result.Overlay {
+			overlay[source] = generated
+		}
 	}
 
-	targetDir, err := getTargetDirectory(args[len(args)-1])
-	if err != nil {
-		return err
+	if mode == ConstraintsOverlay {
+		overlayFile := filepath.Join(GOT_BUILD_DIR, GOT_OVERLAY_FILE)
+		if err := WriteOverlay(overlayFile, overlay); err != nil {
+			return err
+		}
+		cmd.flags = append(cmd.flags, "-overlay", overlayFile)
 	}
+	targetDir := targetDirs[0]
 
-	transformer := GotTransform(targetDir)
-	if err := transformer.Execute(); err != nil {
-		return err
+	// Source files are replaced by their package directory, otherwise the
+	// generated files would not be part of the build.
+	if len(cmd.packages) == 0 || strings.HasSuffix(cmd.packages[0], ".go") {
+		cmd.packages = []string{targetDir}
 	}
 
-	switch commandName {
+	switch cmd.name {
 	case "build":
-		args[len(args)-1] = targetDir
-		_, err := runBuild(args)
+		_, err := runBuild(cmd.commandLine())
 		if err != nil {
 			return err
 		}
+
 	case "run":
-		args[len(args)-1] = targetDir
-		isBuildSuccess, err := runBuild(args)
+		tmpDir, err := os.MkdirTemp("", "got-run-")
 		if err != nil {
 			return err
 		}
+		defer os.RemoveAll(tmpDir)
 
-		if !isBuildSuccess {
-			fmt.Println("Build failed")
-			os.Exit(1)
+		outputFile := filepath.Join(tmpDir, programName(targetDir))
+
+		build := cmd
+		build.flags = append(build.flags, "-o", outputFile)
+		if _, err := runBuild(build.commandLine()); err != nil {
+			return err
 		}
 
-		err = runProgram(outputFile)
+		err = runProgram(outputFile, cmd.args...)
 		if err != nil {
 			return err
 		}
 
 	case "test":
-		err := runTest(args...)
+		err := runTest(cmd.commandLine()...)
 		if err != nil {
 			return err
 		}
@@ -171,21 +274,92 @@ func runGoCmd(args ...string) error {
 	return nil
 }
 
-// getTargetDirectory returns the target directory of the go command.
-func getTargetDirectory(args ...string) (string, error) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return "", err
+// getTargetDirectories returns the directories of the packages targeted
+// by the go command. Patterns ending with `/...` are expanded to all the
+// package directories they match.
+func getTargetDirectories(packages ...string) ([]string, error) {
+	if len(packages) == 0 {
+		return []string{"."}, nil
+	}
+
+	// A list of .go files is a single package
+	if strings.HasSuffix(packages[0], ".go") {
+		packages = packages[:1]
 	}
 
-	resolved, _ := filepath.Rel(cwd, args[len(args)-1])
-	return filepath.Dir(resolved), nil
+	dirs := []string{}
+	for _, pkg := range packages {
+		dir, err := getTargetDirectory(pkg)
+		if err != nil {
+			return nil, err
+		}
+
+		if pkg != "..." && !strings.HasSuffix(pkg, "/...") {
+			dirs = append(dirs, dir)
+			continue
+		}
+
+		pkgDirs, err := LookupPackageDirs(dir)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, pkgDirs...)
+	}
+
+	return dirs, nil
+}
+
+// getTargetDirectory returns the directory of the package targeted by
+// the go command.
+func getTargetDirectory(target string) (string, error) {
+	if strings.HasSuffix(target, ".go") {
+		target = filepath.Dir(target)
+	}
+	target = strings.TrimSuffix(strings.TrimSuffix(target, "..."), "/")
+	if target == "" {
+		target = "."
+	}
+
+	if filepath.IsAbs(target) {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+
+		target, err = filepath.Rel(cwd, target)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	target = filepath.Clean(target)
+	if target != "." && !strings.HasPrefix(target, ".") {
+		target = "." + string(filepath.Separator) + target
+	}
+
+	return target, nil
+}
+
+// programName returns the name of the binary built by `got run`.
+func programName(targetDir string) string {
+	name := filepath.Base(targetDir)
+	if abs, err := filepath.Abs(targetDir); err == nil {
+		name = filepath.Base(abs)
+	}
+
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	return name
 }
 
 // runBuild executes the go build command with the arguments received
 func runBuild(args []string) (bool, error) {
 	args[0] = "build"
-	fmt.Println("Building:", args)
+	if verbose {
+		fmt.Fprintln(os.Stderr, "Building:", args)
+	}
 
 	goroot, err := GetGoRoot()
 	if err != nil {
@@ -210,8 +384,9 @@ func runBuild(args []string) (bool, error) {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	// Run the command
-	if err = cmd.Run(); err != nil {
+	// Run the command, catching the signals so got exits after it, and
+	// the temporary directory of `got run` is removed.
+	if err = runCatchingSignals(cmd); err != nil {
 		return false, err
 	}
 
@@ -222,13 +397,17 @@ func runBuild(args []string) (bool, error) {
 	return true, nil
 }
 
-// runProgram executes the program in the specified path
-func runProgram(programPath string) error {
-	fmt.Println("Running:", programPath)
-
-	_ = os.Chmod(programPath, 0755)
+// runProgram executes the program in the specified path with the given
+// arguments, just like `go run` does.
+// The standard input and outputs are attached to the program, and got
+// waits for it on a signal, see runCatchingSignals.
+// A non-zero exit is returned as an *exec.ExitError.
+func runProgram(programPath string, args ...string) error {
+	if verbose {
+		fmt.Fprintln(os.Stderr, "Running:", programPath, args)
+	}
 
-	cmd := exec.Command(programPath)
+	cmd := exec.Command(programPath, args...)
 
 	// Set the environment variables
 	cmd.Env = os.Environ()
@@ -241,18 +420,65 @@ func runProgram(programPath string) error {
 	// Set the working directory
 	cmd.Dir = cwd
 
-	// Set the stdout and stderr
+	// Set the stdin, stdout and stderr
+	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	// Run the command
-	err = cmd.Run()
-	if err != nil {
+	return runCatchingSignals(cmd)
+}
+
+// runCatchingSignals starts the command and waits for it, catching the
+// signals received by got meanwhile like `go run` does, so got is not
+// killed before the command and can clean up after it.
+// The SIGINT and SIGQUIT signals sent by the terminal on Ctrl-C and
+// Ctrl-\ already reach the command, which is in the same foreground
+// process group, so they are not sent again: a second SIGINT would make
+// some programs quit without cleaning up. Only SIGTERM, usually sent to
+// got alone, is forwarded to the command.
+func runCatchingSignals(cmd *exec.Cmd) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGQUIT, syscall.SIGTERM)
+	defer func() {
+		signal.Stop(signals)
+		close(signals)
+	}()
+
+	// Start the command and forward SIGTERM until it exits
+	if err := cmd.Start(); err != nil {
 		return err
 	}
-	_ = os.Remove(programPath)
 
-	return nil
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM {
+				_ = cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	return cmd.Wait()
+}
+
+// exitCode returns the exit code got should exit with after a command
+// failed with the given error.
+// Programs killed by a signal exit with 128 plus the signal number, as
+// reported by shells.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 1
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+
+	if code := exitErr.ExitCode(); code > 0 {
+		return code
+	}
+
+	return 1
 }
 
 // runTest executes the go test command with the arguments received
@@ -265,7 +491,9 @@ func runTest(args ...string) error {
 	}
 
 	goCmd := filepath.Join(goroot, "bin", "go")
-	fmt.Println("Testing:", args)
+	if verbose {
+		fmt.Fprintln(os.Stderr, "Testing:", args)
+	}
 	cmd := exec.Command(goCmd, args...)
 
 	// Set the environment variables
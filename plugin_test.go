@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/pedronasser/got/transform"
+)
+
+// pluginTestBuildDir returns a build directory for the plugins of the
+// extracted functions. The plugins are built with the go command, so the
+// tests using them are skipped in short mode or without goimports.
+// The directory is in the module, so that the plugins import this version
+// of got, like the test binary loading them.
+func pluginTestBuildDir(t *testing.T) string {
+	if testing.Short() {
+		t.Skip("building plugins is slow")
+	}
+
+	for _, name := range []string{"GOROOT", "GOPATH"} {
+		if os.Getenv(name) != "" {
+			continue
+		}
+		out, err := exec.Command("go", "env", name).Output()
+		if err != nil {
+			t.Skipf("Failed to get %s: %v", name, err)
+		}
+		t.Setenv(name, strings.TrimSpace(string(out)))
+	}
+	if _, err := os.Stat(filepath.Join(os.Getenv("GOPATH"), "bin", "goimports")); err != nil {
+		t.Skip("goimports is not installed")
+	}
+
+	dir, err := os.MkdirTemp(".", "got-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// writePluginTestPackage writes the files of a package in a new directory.
+func writePluginTestPackage(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// pluginTestLogger collects the log messages of a transformer.
+type pluginTestLogger struct {
+	buf bytes.Buffer
+}
+
+func (l *pluginTestLogger) Println(v ...interface{}) {
+	l.buf.WriteString(fmt.Sprintln(v...))
+}
+
+func TestPluginKinds(t *testing.T) {
+	buildDir := pluginTestBuildDir(t)
+
+	decorator := writePluginTestPackage(t, map[string]string{
+		"a.go": `package a
+
+import got "github.com/pedronasser/got/transform"
+
+// #[decorator]
+func X(c *got.TransformContext) error {
+	c.Delete()
+	return nil
+}
+
+// #[X]
+func A() {}
+`,
+	})
+	finalizer := writePluginTestPackage(t, map[string]string{
+		"b.go": `package b
+
+import got "github.com/pedronasser/got/transform"
+
+// #[finalize]
+func X(c *got.FinalizeContext) error {
+	decl, err := c.Decl("const x = 1")
+	if err != nil {
+		return err
+	}
+	c.AddDecl(decl)
+	return nil
+}
+`,
+	})
+
+	// The decorator and the finalizer have the same name, but are
+	// extracted and built to the directories of their kinds.
+	for _, dir := range []string{decorator, finalizer} {
+		if _, err := NewTransformer(dir, WithBuildDir(buildDir)).Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, kind := range []string{"decorators", "finalizers"} {
+		if paths, _ := filepath.Glob(filepath.Join(buildDir, "packages", "*", "extracted", kind, "X", "extract.go")); len(paths) != 1 {
+			t.Errorf("Expected the %s X to be extracted, got %v", kind, paths)
+		}
+	}
+
+	logger := &pluginTestLogger{}
+	if _, err := NewTransformer(decorator, WithBuildDir(buildDir), WithLogger(logger)).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logger.buf.String(), "skip extracting unmodified decorator: X") {
+		t.Errorf("Expected the decorator not to be extracted again, got %s", logger.buf.String())
+	}
+
+	src, err := os.ReadFile(filepath.Join(finalizer, "got_x_generated.go"))
+	if err != nil || !strings.Contains(string(src), "const x = 1") {
+		t.Errorf("Expected the file of the finalizer, got %s, %v", src, err)
+	}
+}
+
+func TestPluginTestDecorators(t *testing.T) {
+	buildDir := pluginTestBuildDir(t)
+
+	// The decorator of the external test package has the name of the
+	// decorator of the package, and another behavior.
+	mark := `
+// #[decorator]
+func Mark(c *got.TransformContext) error {
+	decl, err := c.Decl("const %s = true")
+	if err != nil {
+		return err
+	}
+	c.InsertAfter(decl)
+	return nil
+}
+`
+	dir := writePluginTestPackage(t, map[string]string{
+		"a.go":      "package p\n\nimport got \"github.com/pedronasser/got/transform\"\n" + fmt.Sprintf(mark, "production") + "\n// #[Mark]\nfunc A() {}\n",
+		"a_test.go": "package p_test\n\nimport got \"github.com/pedronasser/got/transform\"\n" + fmt.Sprintf(mark, "test") + "\n// #[Mark]\nfunc B() {}\n",
+	})
+
+	// Whichever file is transformed first, each file is transformed by its
+	// own decorator.
+	for _, parallelism := range []int{1, 2} {
+		opts := []Option{WithBuildDir(buildDir), WithTests(true), WithParallelism(parallelism)}
+		if _, err := NewTransformer(dir, opts...).Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]string{
+			"a_generated.go":      "\nconst production = true\n",
+			"a_generated_test.go": "\nconst test = true\n",
+		}
+		for name, expected := range expected {
+			src, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(src), expected) {
+				t.Errorf("Expected %q in %s, got %s", expected, name, src)
+			}
+		}
+	}
+
+	for _, kind := range []string{"decorators", "decorators_test"} {
+		if paths, _ := filepath.Glob(filepath.Join(buildDir, "packages", "*", kind, "Mark.so")); len(paths) != 1 {
+			t.Errorf("Expected the plugin of %s, got %v", kind, paths)
+		}
+	}
+}
+
+func TestPluginDeclaredTwice(t *testing.T) {
+	buildDir := pluginTestBuildDir(t)
+
+	decorator := `package p
+
+import got "github.com/pedronasser/got/transform"
+
+// #[decorator]
+func X(c *got.TransformContext) error {
+	%s
+	return nil
+}
+`
+	dir := writePluginTestPackage(t, map[string]string{
+		"a.go": fmt.Sprintf(decorator, "c.Delete()"),
+		"b.go": fmt.Sprintf(decorator, "c.Reportf(\"X\")"),
+	})
+
+	_, err := NewTransformer(dir, WithBuildDir(buildDir), WithParallelism(1)).Run(context.Background())
+	expected := filepath.Join(dir, "b.go") + ":6:1: decorator `X` declared twice, first at " + filepath.Join(dir, "a.go") + ":6:1"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+}
+
+func TestPluginPackages(t *testing.T) {
+	buildDir := pluginTestBuildDir(t)
+
+	mark := `package %s
+
+import got "github.com/pedronasser/got/transform"
+
+// #[decorator]
+func Mark(c *got.TransformContext) error {
+	decl, err := c.Decl("const %s = true")
+	if err != nil {
+		return err
+	}
+	c.InsertAfter(decl)
+	return nil
+}
+
+// #[Mark]
+func A() {}
+`
+
+	// Like with `got build ./...`, the packages are transformed by the same
+	// process with the same build directory. The decorators of a and b have
+	// the same name, and the decorator of c the source of the decorator of
+	// a.
+	expected := map[string]string{"a": "a", "b": "b", "c": "a"}
+	for _, name := range []string{"a", "b", "c"} {
+		dir := writePluginTestPackage(t, map[string]string{
+			"a.go": fmt.Sprintf(mark, name, expected[name]),
+		})
+		if _, err := NewTransformer(dir, WithBuildDir(buildDir)).Run(context.Background()); err != nil {
+			t.Fatalf("Failed to transform package %s: %v", name, err)
+		}
+
+		src, err := os.ReadFile(filepath.Join(dir, "a_generated.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(src), "\nconst "+expected[name]+" = true\n") {
+			t.Errorf("Expected the decorator of package %s, got %s", name, src)
+		}
+	}
+}
+
+func TestPluginExplain(t *testing.T) {
+	buildDir := pluginTestBuildDir(t)
+
+	dir := writePluginTestPackage(t, map[string]string{
+		"a.go": `package p
+
+import got "github.com/pedronasser/got/transform"
+
+// #[decorator]
+func Drop(c *got.TransformContext) error {
+	c.Delete()
+	return nil
+}
+
+// #[Drop]
+func A() {}
+`,
+	})
+	path := filepath.Join(dir, "a.go")
+
+	// The plugin of the decorator is not built by Explain.
+	_, err := Explain(context.Background(), path, 12, WithBuildDir(buildDir))
+	expected := path + ":6:1: the plugin of decorator `Drop` is missing or out of date, run `got build` first"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+
+	if _, err := NewTransformer(dir, WithBuildDir(buildDir)).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	built := append(pluginTestFiles(t, buildDir), pluginTestFiles(t, dir)...)
+
+	// The run adds a build constraint to the file, moving the declaration.
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Count(string(src[:strings.Index(string(src), "func A")]), "\n") + 1
+
+	explanation, err := Explain(context.Background(), path, line, WithBuildDir(buildDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(explanation.Attributes) != 1 || explanation.Attributes[0].Kind != "decorator" || explanation.After != "" {
+		t.Errorf("Expected the declaration to be deleted by the decorator, got %+v", explanation)
+	}
+
+	if files := append(pluginTestFiles(t, buildDir), pluginTestFiles(t, dir)...); strings.Join(files, ",") != strings.Join(built, ",") {
+		t.Errorf("Expected no file to be written, got %v instead of %v", files, built)
+	}
+}
+
+// pluginTestFiles returns the paths of the files in the directory, with
+// their modification times.
+func pluginTestFiles(t *testing.T, dir string) []string {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		files = append(files, fmt.Sprintf("%s %s", path, info.ModTime()))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	. "github.com/pedronasser/got/transform"
+)
+
+// runShowGenerated executes the
+// `got show-generated [-documents] [-tags tags] file.go`
+// command, printing the generated source of the file without writing the
+// generated files of its package.
+// With -documents, the content of the files edited but not saved is read
+// from the standard input, as a JSON object of the sources by path.
+func runShowGenerated(args []string, in io.Reader, w io.Writer) error {
+	flags := flag.NewFlagSet("show-generated", flag.ContinueOnError)
+	flags.SetOutput(w)
+	documents := flags.Bool("documents", false, "read the unsaved files as JSON from the standard input")
+	tags := flags.String("tags", "", "a comma-separated list of build tags")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 || !strings.HasSuffix(flags.Arg(0), ".go") {
+		return fmt.Errorf("usage: got show-generated [-documents] [-tags tags] file.go")
+	}
+
+	opts := []Option{}
+	if *tags != "" {
+		opts = append(opts, WithTags(strings.Split(*tags, ",")...))
+	}
+
+	if *documents {
+		texts := map[string]string{}
+		if err := json.NewDecoder(in).Decode(&texts); err != nil {
+			return fmt.Errorf("Failed to read the documents: %v", err)
+		}
+		sources := make(map[string][]byte, len(texts))
+		for path, text := range texts {
+			sources[path] = []byte(text)
+		}
+		opts = append(opts, WithSources(sources))
+	}
+
+	src, err := GeneratedSource(context.Background(), flags.Arg(0), opts...)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(src)
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunShowGenerated(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.go")
+	if err := os.WriteFile(path, []byte("package p\n\nfunc A() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	if err := runShowGenerated([]string{path}, nil, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "package p\n\nfunc A() {}\n" {
+		t.Errorf("Expected the source of the file, got %s", out.String())
+	}
+
+	// The unsaved content of the file is read from the input.
+	unsaved := "package p\n\nfunc B() {}\n"
+	documents, err := json.Marshal(map[string]string{path: unsaved})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := runShowGenerated([]string{"-documents", path}, bytes.NewReader(documents), out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != unsaved {
+		t.Errorf("Expected %s, got %s", unsaved, out.String())
+	}
+
+	if err := runShowGenerated([]string{dir}, nil, out); err == nil {
+		t.Errorf("Expected a usage error")
+	}
+}
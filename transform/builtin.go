@@ -2,9 +2,12 @@ package transform
 
 import (
 	"encoding/hex"
+	"fmt"
 	"go/ast"
+	"go/token"
 	"os"
 	"path"
+	"sync"
 
 	"crypto/sha256"
 )
@@ -14,36 +17,41 @@ type BuiltinAttributeFn = func(c *TransformContext) error
 var BuiltinAttributes = map[string]BuiltinAttributeFn{
 	"method":    MethodAttribute,
 	"decorator": DecoratorAttribute,
+	"finalize":  FinalizeAttribute,
+	"describe":  DecoratorSpecAttribute,
+	"target":    DecoratorSpecAttribute,
+	"arg":       DecoratorSpecAttribute,
+	"variadic":  DecoratorSpecAttribute,
 }
 
-var exportedMethods = []string{}
-var exportedDecorators = []string{}
-
 // DecoratorAttribute is a builtin attribute that extracts the function
 // and saves it as a plugin in the decorators directory.
 func DecoratorAttribute(c *TransformContext) error {
 	target := c.Node()
+	t := c.file.transformer
+
+	// Nothing is extracted when transforming in memory, decorators and
+	// methods are registered with options instead.
+	if t.inMemory {
+		return nil
+	}
 
 	if v, ok := target.(*ast.FuncDecl); ok {
 		name := v.Name.Name
-		fnHashSum := hashExtracted(GOT_DECORATORS_DIR, string(c.FileSrc()[v.Pos()-1:v.End()-1]))
-		if !isExtractedModified(name, fnHashSum) {
-			log("skip extracting unmodified decorator:", name)
-			return nil
-		}
 
-		imports, err := getFileImportsList(c.ASTFile())
+		spec, ok, err := parseDecoratorSpec(c.file.boundAttributes(v, false))
 		if err != nil {
 			return err
 		}
-		fnSrc := string(c.FileSrc()[v.Pos()-1 : v.End()-1])
-		err = extractAsPlugin(name, fnSrc, GOT_DECORATORS_DIR, imports, fnHashSum)
-		if err != nil {
-			log(err)
+		if ok {
+			t.setDecoratorSpec(c.file.path, name, spec)
+		}
+
+		if err := c.extractFunction(GOT_DECORATORS_DIR, "decorator", v); err != nil {
 			return err
 		}
 
-		exportedDecorators = append(exportedDecorators, name)
+		c.file.exportedDecorators = append(c.file.exportedDecorators, name)
 	}
 
 	return nil
@@ -53,31 +61,124 @@ func DecoratorAttribute(c *TransformContext) error {
 // and saves it as a plugin in the methods directory.
 func MethodAttribute(c *TransformContext) error {
 	target := c.Node()
+	t := c.file.transformer
+
+	// See DecoratorAttribute.
+	if t.inMemory {
+		return nil
+	}
 
 	if v, ok := target.(*ast.FuncDecl); ok {
-		name := v.Name.Name
+		if err := c.extractFunction(GOT_METHODS_DIR, "method", v); err != nil {
+			return err
+		}
+
+		c.file.exportedMethods = append(c.file.exportedMethods, v.Name.Name)
+	}
+
+	return nil
+}
+
+// FinalizeAttribute is a builtin attribute that extracts the function
+// and saves it as a plugin in the finalizers directory. Finalizers write
+// declarations to a file of the package, so they can't be declared in
+// test files.
+func FinalizeAttribute(c *TransformContext) error {
+	target := c.Node()
+	t := c.file.transformer
+
+	// See DecoratorAttribute.
+	if t.inMemory {
+		return nil
+	}
 
-		fnHashSum := hashExtracted(GOT_DECORATORS_DIR, string(c.FileSrc()[v.Pos()-1:v.End()-1]))
-		if !isExtractedModified(name, fnHashSum) {
-			log("skipping unmodified decorator:", name)
+	if v, ok := target.(*ast.FuncDecl); ok {
+		if isTestFile(c.file.path) {
+			c.Reportf("finalizer `%s` can't be declared in a test file", v.Name.Name)
 			return nil
 		}
 
-		imports, err := getFileImportsList(c.ASTFile())
-		if err != nil {
+		if err := c.extractFunction(GOT_FINALIZERS_DIR, "finalizer", v); err != nil {
 			return err
 		}
 
-		fnSrc := string(c.FileSrc()[v.Pos()-1 : v.End()-1])
-		err = extractAsPlugin(name, fnSrc, GOT_METHODS_DIR, imports, fnHashSum)
+		c.file.exportedFinalizers = append(c.file.exportedFinalizers, v.Name.Name)
+	}
+
+	return nil
+}
+
+// extractFunction extracts the function and builds it as a plugin in the
+// directory of its kind, see kindDir, once per transformer run. The plugin
+// is not built again when the function is unmodified since the last build.
+// When the plugins are prebuilt, the plugin of the function must be up to
+// date.
+func (c *TransformContext) extractFunction(dir, kind string, fn *ast.FuncDecl) error {
+	t := c.file.transformer
+	name := fn.Name.Name
+	dir = kindDir(dir, c.file.path)
+
+	fnSrc := string(c.FileSrc()[fn.Pos()-1 : fn.End()-1])
+	fnHashSum := hashExtracted(dir, fnSrc)
+	pos := c.file.fset.Position(fn.Pos())
+
+	if t.prebuilt {
+		if isExtractedModified(t.pluginDir(), dir, name, fnHashSum) {
+			return fmt.Errorf("%s: the plugin of %s `%s` is missing or out of date, run `got build` first", pos, kind, name)
+		}
+		return nil
+	}
+
+	return t.buildPlugin(kind, dir, name, fnHashSum, pos, func() error {
+		if !isExtractedModified(t.pluginDir(), dir, name, fnHashSum) {
+			c.file.log(fmt.Sprintf("skip extracting unmodified %s: %s", kind, name))
+			return nil
+		}
+
+		imports, err := getFileImportsList(c.ASTFile())
 		if err != nil {
 			return err
 		}
+		return extractAsPlugin(t.pluginDir(), name, fnSrc, dir, imports, fnHashSum)
+	})
+}
+
+// pluginBuild is the build of the plugin of an extracted function, shared
+// by all the files extracting the same function.
+type pluginBuild struct {
+	once sync.Once
+	err  error
 
-		exportedMethods = append(exportedMethods, name)
+	// hash is the hash of the extracted source, and pos the position of
+	// the first function extracted to the plugin.
+	hash string
+	pos  token.Position
+}
+
+// buildPlugin runs the build of the plugin of an extracted function, once
+// per transformer run, even when several files extract the function at the
+// same time. The build is shared by the functions with the same source:
+// another function of the same kind and name would be built to the same
+// plugin, and is an error.
+func (t *Transformer) buildPlugin(kind, dir, name, hash string, pos token.Position, build func() error) error {
+	key := path.Join(dir, name)
+
+	t.mu.Lock()
+	b, ok := t.builds[key]
+	if !ok {
+		b = &pluginBuild{hash: hash, pos: pos}
+		t.builds[key] = b
 	}
+	t.mu.Unlock()
 
-	return nil
+	if b.hash != hash {
+		return fmt.Errorf("%s: %s `%s` declared twice, first at %s", pos, kind, name, b.pos)
+	}
+
+	b.once.Do(func() {
+		b.err = build()
+	})
+	return b.err
 }
 
 // PlaceholderAttribute is a builtin attribute that deletes the node
@@ -90,9 +191,9 @@ func PlaceholderAttribute(fileSrc []byte, f *ast.File, c *TransformContext, args
 
 // isExtractedModified checks if the extracted plugin is modified
 // by comparing the hash of the function with the hash of the
-// extracted plugin.
-func isExtractedModified(name, hash string) bool {
-	hashFilePath := path.Join(GOT_BUILD_DIR, GOT_EXTRACT_DIR, name, "extract.hash")
+// extracted plugin in the build directory.
+func isExtractedModified(buildDir, extractDir, name, hash string) bool {
+	hashFilePath := path.Join(buildDir, GOT_EXTRACT_DIR, extractDir, name, "extract.hash")
 	extractHash, err := os.ReadFile(hashFilePath)
 	if err != nil {
 		return true
@@ -106,10 +207,14 @@ func isExtractedModified(name, hash string) bool {
 }
 
 // hashExtracted hashes the extracted plugin directory and the
-// function source code to create a unique hash for the plugin.
+// function source code to create a unique hash for the plugin. The
+// plugins built with the race detector have their own hash.
 func hashExtracted(extractedDir, src string) string {
 	h := sha256.New()
 	h.Write([]byte(extractedDir))
 	h.Write([]byte(src))
+	if raceEnabled {
+		h.Write([]byte("race"))
+	}
 	return hex.EncodeToString(h.Sum(nil))
 }
@@ -1,5 +1,7 @@
 package transform
 
+import "time"
+
 const (
 	// GOT_BUILD_DIR is the directory where the generated go files are saved.
 	GOT_BUILD_DIR = "got/"
@@ -10,9 +12,27 @@ const (
 	// GOT_DECORATORS_DIR is the directory where generated decorators are saved.
 	GOT_DECORATORS_DIR = "decorators/"
 
+	// GOT_FINALIZERS_DIR is the directory where generated finalizers are
+	// saved.
+	GOT_FINALIZERS_DIR = "finalizers/"
+
+	// GOT_PACKAGES_DIR is the directory where the extracted functions of
+	// each package and their plugins are saved.
+	GOT_PACKAGES_DIR = "packages/"
+
 	// GOT_EXTRACT_DIR is the directory where extracted functions are saved.
 	GOT_EXTRACT_DIR = "extracted/"
 
+	// GOT_OVERLAY_DIR is the directory where generated files are saved when
+	// using the overlay constraints mode.
+	GOT_OVERLAY_DIR = "overlay/"
+
+	// GOT_OVERLAY_FILE is the name of the go build overlay file.
+	GOT_OVERLAY_FILE = "overlay.json"
+
+	// GENERATED_TAG is the build tag selecting the generated files.
+	GENERATED_TAG = "generated"
+
 	// GOT_BUILD_FILE is the name of the generated go file.
 	GOT_PREFIX = "#"
 
@@ -33,4 +53,26 @@ const (
 
 	// GO_BUILD_COMMENT_LEN is the length of the build constraint prefix.
 	GO_BUILD_COMMENT_LEN = len(GO_BUILD_COMMENT)
+
+	// GOT_PLACEHOLDER_PREFIX prefixes the identifiers standing for the
+	// placeholders of a template while it is parsed.
+	GOT_PLACEHOLDER_PREFIX = "__got_placeholder_"
+
+	// GOT_GENERATED_PREFIX and GOT_GENERATED_SUFFIX surround the version
+	// and the source of the generated code comment starting the generated
+	// files, like `// Code generated by got v1.0.0 from main.go. DO NOT EDIT.`
+	GOT_GENERATED_PREFIX = "// Code generated by got "
+	GOT_GENERATED_SUFFIX = ". DO NOT EDIT."
+
+	// GOT_DEFAULT_VERSION is the version of got in the generated code
+	// comment when none is set with WithVersion.
+	GOT_DEFAULT_VERSION = "devel"
+
+	// GOT_DEFAULT_TIMEOUT is the time a decorator or a finalizer call may
+	// take when none is set with WithTimeout.
+	GOT_DEFAULT_TIMEOUT = time.Minute
+
+	// GOT_SHOW_GENERATED_COMMAND is the command of the language server
+	// returning the generated source of a file.
+	GOT_SHOW_GENERATED_COMMAND = "got.showGeneratedCode"
 )
@@ -0,0 +1,260 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+)
+
+// ConstraintMode defines how got keeps the transformed source files and
+// their generated counterparts from being compiled together.
+type ConstraintMode int
+
+const (
+	// ConstraintsAuto adds the `!generated` build constraint to the source
+	// files that have a generated counterpart when it is missing.
+	ConstraintsAuto ConstraintMode = iota
+
+	// ConstraintsVerify never modifies the source files and fails when a
+	// source file with a generated counterpart doesn't exclude the
+	// `generated` tag.
+	ConstraintsVerify
+
+	// ConstraintsOverlay writes the generated files to the build directory
+	// and replaces the source files using a go build overlay, so no build
+	// constraint is needed at all.
+	ConstraintsOverlay
+)
+
+// ParseConstraintMode parses a constraint mode name: auto, verify or overlay.
+func ParseConstraintMode(name string) (ConstraintMode, error) {
+	switch name {
+	case "", "auto":
+		return ConstraintsAuto, nil
+	case "verify":
+		return ConstraintsVerify, nil
+	case "overlay":
+		return ConstraintsOverlay, nil
+	}
+
+	return 0, fmt.Errorf("unknown constraints mode `%s` (expected auto, verify or overlay)", name)
+}
+
+var hasGeneratedTag = func(tag string) bool {
+	return tag == GENERATED_TAG
+}
+
+// parseFileConstraint returns the `//go:build` constraint of a go source,
+// or nil if it has none.
+func parseFileConstraint(src []byte) (constraint.Expr, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse file: %v", err)
+	}
+
+	for _, group := range file.Comments {
+		if group.Pos() >= file.Package {
+			break
+		}
+
+		for _, comment := range group.List {
+			if constraint.IsGoBuild(comment.Text) {
+				return constraint.Parse(comment.Text)
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// checkSourceConstraint checks if the build constraint of a source file
+// excludes the generated tag, so it is not compiled with its generated
+// counterpart.
+// It returns false when the constraint doesn't mention the generated tag,
+// and an error naming the file when the constraint mentions the tag
+// without excluding it.
+func checkSourceConstraint(path string, expr constraint.Expr) (bool, error) {
+	if excludesTag(expr, hasGeneratedTag) {
+		return true, nil
+	}
+
+	if expr != nil && mentionsTag(expr, hasGeneratedTag) {
+		return false, fmt.Errorf(
+			"%s: build constraint `%s` is inconsistent with its generated file `%s`; it must be `!%s` or `!%s && ...`",
+			path, expr, filepath.Base(generatedFileName(path)), GENERATED_TAG, GENERATED_TAG)
+	}
+
+	return false, nil
+}
+
+// addSourceConstraint adds the `!generated` build constraint to the source,
+// replacing its current `//go:build` line (and the obsolete `// +build`
+// lines) or adding a new one at the top of the file.
+func addSourceConstraint(src []byte, expr constraint.Expr) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse file: %v", err)
+	}
+
+	var updated constraint.Expr = &constraint.NotExpr{
+		X: &constraint.TagExpr{Tag: GENERATED_TAG},
+	}
+	if expr != nil {
+		updated = &constraint.AndExpr{X: expr, Y: updated}
+	}
+	line := "//go:build " + updated.String()
+
+	type span struct{ start, end int }
+	spans := []span{}
+	for _, group := range file.Comments {
+		if group.Pos() >= file.Package {
+			break
+		}
+
+		for _, comment := range group.List {
+			if constraint.IsGoBuild(comment.Text) || constraint.IsPlusBuild(comment.Text) {
+				spans = append(spans, span{
+					start: fset.Position(comment.Pos()).Offset,
+					end:   fset.Position(comment.End()).Offset,
+				})
+			}
+		}
+	}
+
+	if len(spans) == 0 {
+		return append([]byte(line+"\n\n"), src...), nil
+	}
+
+	result := bytes.NewBuffer([]byte{})
+	last := 0
+	for i, s := range spans {
+		result.Write(src[last:s.start])
+		last = s.end
+
+		if i == 0 {
+			result.WriteString(line)
+		} else if last < len(src) && src[last] == '\n' {
+			// The whole line of the removed `// +build` comment goes away.
+			last++
+		}
+	}
+	result.Write(src[last:])
+
+	return result.Bytes(), nil
+}
+
+// generatedConstraint returns the build constraint of a generated file
+// based on the constraint of its source.
+// Every mention of the generated tag is removed from the source constraint,
+// then, when addTag is true, the generated tag is required.
+func generatedConstraint(expr constraint.Expr, addTag bool) constraint.Expr {
+	if expr != nil {
+		expr = removeTag(expr, hasGeneratedTag)
+	}
+
+	if !addTag {
+		return expr
+	}
+
+	tag := &constraint.TagExpr{Tag: GENERATED_TAG}
+	if expr == nil {
+		return tag
+	}
+
+	return &constraint.AndExpr{X: expr, Y: tag}
+}
+
+// excludesTag reports whether the expression is always false when the tag
+// is set, that is, when it is `!tag` or a conjunction including `!tag`.
+func excludesTag(expr constraint.Expr, isTag func(string) bool) bool {
+	switch v := expr.(type) {
+	case *constraint.AndExpr:
+		return excludesTag(v.X, isTag) || excludesTag(v.Y, isTag)
+	case *constraint.NotExpr:
+		if tag, ok := v.X.(*constraint.TagExpr); ok {
+			return isTag(tag.Tag)
+		}
+	}
+
+	return false
+}
+
+// mentionsTag reports whether the expression mentions the tag.
+func mentionsTag(expr constraint.Expr, isTag func(string) bool) bool {
+	switch v := expr.(type) {
+	case *constraint.AndExpr:
+		return mentionsTag(v.X, isTag) || mentionsTag(v.Y, isTag)
+	case *constraint.OrExpr:
+		return mentionsTag(v.X, isTag) || mentionsTag(v.Y, isTag)
+	case *constraint.NotExpr:
+		return mentionsTag(v.X, isTag)
+	case *constraint.TagExpr:
+		return isTag(v.Tag)
+	}
+
+	return false
+}
+
+// removeTag removes the tag from the expression.
+// Negations of the tag are removed as well.
+func removeTag(expr constraint.Expr, shouldRemove func(string) bool) constraint.Expr {
+	switch v := expr.(type) {
+	case *constraint.AndExpr:
+		v.X = removeTag(v.X, shouldRemove)
+		v.Y = removeTag(v.Y, shouldRemove)
+		if v.X == nil {
+			return v.Y
+		}
+		if v.Y == nil {
+			return v.X
+		}
+	case *constraint.OrExpr:
+		v.X = removeTag(v.X, shouldRemove)
+		v.Y = removeTag(v.Y, shouldRemove)
+		if v.X == nil {
+			return v.Y
+		}
+		if v.Y == nil {
+			return v.X
+		}
+	case *constraint.NotExpr:
+		v.X = removeTag(v.X, shouldRemove)
+		if v.X == nil {
+			return nil
+		}
+	case *constraint.TagExpr:
+		if shouldRemove(v.Tag) {
+			return nil
+		}
+	}
+
+	return expr
+}
+
+// WriteOverlay writes a go build overlay file replacing the source files
+// with their generated files, as used by the `-overlay` build flag.
+func WriteOverlay(path string, replace map[string]string) error {
+	overlay := struct {
+		Replace map[string]string
+	}{
+		Replace: replace,
+	}
+
+	data, err := json.MarshalIndent(overlay, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
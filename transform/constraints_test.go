@@ -0,0 +1,101 @@
+package transform
+
+import (
+	"go/build/constraint"
+	"strings"
+	"testing"
+)
+
+func testAddSourceConstraint(t *testing.T, src, expected string) {
+	expr, err := parseFileConstraint([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := addSourceConstraint([]byte(src), expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(result) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, result)
+	}
+}
+
+func TestAddSourceConstraint(t *testing.T) {
+	testAddSourceConstraint(t,
+		"package main\n",
+		"//go:build !generated\n\npackage main\n",
+	)
+
+	testAddSourceConstraint(t,
+		"// Copyright\n\n//go:build linux\n\n// Package main\npackage main\n",
+		"// Copyright\n\n//go:build linux && !generated\n\n// Package main\npackage main\n",
+	)
+
+	testAddSourceConstraint(t,
+		"//go:build linux || darwin\n// +build linux darwin\n\npackage main\n",
+		"//go:build (linux || darwin) && !generated\n\npackage main\n",
+	)
+}
+
+func TestCheckSourceConstraint(t *testing.T) {
+	cases := map[string]struct {
+		ok      bool
+		invalid bool
+	}{
+		"":                               {ok: false},
+		"//go:build linux":               {ok: false},
+		"//go:build !generated":          {ok: true},
+		"//go:build linux && !generated": {ok: true},
+		"//go:build generated":           {invalid: true},
+		"//go:build !generated || linux": {invalid: true},
+	}
+
+	for line, c := range cases {
+		var expr constraint.Expr
+		if line != "" {
+			var err error
+			if expr, err = constraint.Parse(line); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ok, err := checkSourceConstraint("main.go", expr)
+		if c.invalid {
+			if err == nil || !strings.Contains(err.Error(), "main.go") {
+				t.Errorf("%q: expected an error naming the file, got %v", line, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", line, err)
+		}
+		if ok != c.ok {
+			t.Errorf("%q: expected %t, got %t", line, c.ok, ok)
+		}
+	}
+}
+
+func TestGeneratedConstraint(t *testing.T) {
+	cases := map[string]string{
+		"//go:build !generated":          "generated",
+		"//go:build linux && !generated": "linux && generated",
+		"//go:build linux":               "linux && generated",
+	}
+
+	for line, expected := range cases {
+		expr, err := constraint.Parse(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result := generatedConstraint(expr, true).String(); result != expected {
+			t.Errorf("%q: expected %s, got %s", line, expected, result)
+		}
+	}
+
+	if result := generatedConstraint(nil, false); result != nil {
+		t.Errorf("Expected no constraint, got %s", result)
+	}
+}
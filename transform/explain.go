@@ -0,0 +1,347 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// Explanation describes the transformation of the declaration at a line of
+// a file: the attributes bound to the node at the line, the decorators they
+// resolve to, and the source of the declaration before and after its
+// attributes are applied.
+type Explanation struct {
+	Pos string `json:"pos"`
+
+	// Target is the kind of the node the attributes are bound to, such as
+	// `FuncDecl` or `TypeDecl`, and TargetName its name. Without
+	// attributes at the line, it is the declaration at the line.
+	Target     string `json:"target"`
+	TargetName string `json:"targetName,omitempty"`
+
+	Attributes []ExplainedAttribute `json:"attributes"`
+
+	// Before is the source of the declaration, and After the source of the
+	// declarations derived from it, such as the declarations inserted
+	// around it. After is empty when the declaration is deleted.
+	Before string `json:"before"`
+	After  string `json:"after"`
+
+	// Diagnostics are the diagnostics reported for the declaration.
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// ExplainedAttribute is an attribute bound to the node of an explanation.
+type ExplainedAttribute struct {
+	Name string   `json:"name"`
+	Args []string `json:"args,omitempty"`
+	Pos  string   `json:"pos"`
+
+	// Kind is `builtin` for the builtin attributes, `decorator` for the
+	// attributes of a decorator and `unknown` otherwise.
+	Kind string `json:"kind"`
+
+	// Decorator is the decorator of the attribute and Source its source,
+	// when it is declared by the package. Decorators registered with
+	// options have no source.
+	Decorator *ExtractedFunction `json:"decorator,omitempty"`
+	Source    string             `json:"source,omitempty"`
+}
+
+// Explain transforms the package of the file in memory and explains the
+// transformation of the declaration at the line of the file.
+// No file is written: the decorators of the package are loaded from the
+// plugins built in the build directory by a previous run, and an error is
+// returned when a plugin is missing or out of date.
+func Explain(ctx context.Context, filename string, line int, opts ...Option) (*Explanation, error) {
+	filename = filepath.Clean(filename)
+	if isTestFile(filename) {
+		opts = append(opts, WithTests(true))
+	}
+	t := NewTransformer(filepath.Dir(filename), opts...)
+	t.prebuilt = true
+
+	f, files, err := t.prepareFile(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	decl := f.declAt(line)
+	if decl == nil {
+		return nil, fmt.Errorf("%s:%d: no declaration at this line", filename, line)
+	}
+
+	explanation := &Explanation{
+		Pos:        fmt.Sprintf("%s:%d", filename, line),
+		Attributes: []ExplainedAttribute{},
+		Before:     nodeSource(f.fset, f.original, decl),
+	}
+
+	var node ast.Node = decl
+	if usage := f.usageAt(decl, line); usage != nil {
+		node = usage.node
+	}
+	explanation.Target = targetKinds(node)[0]
+	explanation.TargetName = nodeName(node)
+
+	decorators, err := t.packageDecorators(files, f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, usage := range f.bindings[node] {
+		for _, attribute := range usage.attributes {
+			explained := ExplainedAttribute{
+				Name: attribute.Name,
+				Args: attribute.Arguments,
+				Pos:  usage.position.String(),
+				Kind: "unknown",
+			}
+
+			if _, ok := BuiltinAttributes[attribute.Name]; ok {
+				explained.Kind = "builtin"
+			} else if _, ok := t.decorator(f.path, attribute.Name); ok {
+				explained.Kind = "decorator"
+				if decorator, ok := decorators[attribute.Name]; ok {
+					explained.Decorator = &decorator.function
+					explained.Source = decorator.source
+				}
+			}
+
+			explanation.Attributes = append(explanation.Attributes, explained)
+		}
+	}
+
+	if err := t.applyAttributes(f); err != nil {
+		return nil, err
+	}
+	t.reportUnapplied(f)
+
+	explanation.After, err = f.derivedSource(decl)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := f.declLines(decl)
+	for _, diagnostic := range f.diagnostics {
+		if diagnostic.Pos.Line >= start && diagnostic.Pos.Line <= end {
+			explanation.Diagnostics = append(explanation.Diagnostics, diagnostic)
+		}
+	}
+
+	return explanation, nil
+}
+
+// prepareFile prepares the files of the package, like Run, and returns the
+// file with the name along with all the files.
+func (t *Transformer) prepareFile(ctx context.Context, filename string) (*fileTransform, []*fileTransform, error) {
+	paths, err := t.packageFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files, err := t.prepareFiles(ctx, paths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, f := range files {
+		if f.path == filename {
+			return f, files, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("File `%s` is not part of the package in `%s`", filename, t.baseDir)
+}
+
+// packageDecorator is a decorator declared by a file of the package, with
+// its source.
+type packageDecorator struct {
+	function ExtractedFunction
+	source   string
+}
+
+// packageDecorators returns the decorators declared by the files which are
+// available to the file at the path, by name.
+func (t *Transformer) packageDecorators(files []*fileTransform, path string) (map[string]packageDecorator, error) {
+	decorators := map[string]packageDecorator{}
+	for _, f := range files {
+		if isTestFile(f.path) && !isTestFile(path) {
+			continue
+		}
+
+		for _, usage := range f.usages {
+			fn, ok := usage.node.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+
+			for _, attribute := range usage.attributes {
+				if attribute.Name != "decorator" {
+					continue
+				}
+
+				function, err := f.extractedFunction(attribute.Name, fn)
+				if err != nil {
+					return nil, err
+				}
+				decorators[fn.Name.Name] = packageDecorator{
+					function: function,
+					source:   nodeSource(f.fset, f.original, fn),
+				}
+			}
+		}
+	}
+	return decorators, nil
+}
+
+// declAt returns the top-level declaration of the file covering the line,
+// including its doc comment, or nil.
+func (f *fileTransform) declAt(line int) ast.Decl {
+	for _, decl := range f.file.Decls {
+		start, end := f.declLines(decl)
+		if line >= start && line <= end {
+			return decl
+		}
+	}
+	return nil
+}
+
+// declLines returns the first and last lines of a declaration of the file,
+// including its doc comment.
+func (f *fileTransform) declLines(decl ast.Decl) (int, int) {
+	start := decl.Pos()
+	if doc := declDoc(decl); doc != nil {
+		start = doc.Pos()
+	}
+	return f.fset.Position(start).Line, f.fset.Position(decl.End()).Line
+}
+
+// usageAt returns the innermost attribute usage of the declaration covering
+// the line, from its attribute comment to the end of its node, or nil.
+func (f *fileTransform) usageAt(decl ast.Decl, line int) *attributesUsage {
+	var found *attributesUsage
+	for _, usage := range f.usages {
+		if usage.node.Pos() < decl.Pos() || usage.node.End() > decl.End() {
+			continue
+		}
+
+		start := usage.position.Line
+		end := f.fset.Position(usage.node.End()).Line
+		if line < start || line > end {
+			continue
+		}
+
+		if found == nil || usage.position.Offset > found.position.Offset {
+			found = usage
+		}
+	}
+	return found
+}
+
+// nodeSource returns the source of a node parsed from src, including
+// the doc comment of a declaration.
+func nodeSource(fset *token.FileSet, src []byte, node ast.Node) string {
+	start := node.Pos()
+	if decl, ok := node.(ast.Decl); ok {
+		if doc := declDoc(decl); doc != nil {
+			start = doc.Pos()
+		}
+	}
+	return string(src[fset.Position(start).Offset:fset.Position(node.End()).Offset])
+}
+
+// derivedSource returns the source of the declarations derived from the
+// original declaration once the attributes of the file are applied, in the
+// order of the file.
+func (f *fileTransform) derivedSource(original ast.Decl) (string, error) {
+	if !f.modified {
+		return nodeSource(f.fset, f.original, original), nil
+	}
+
+	src, err := printGeneratedSource(f, false)
+	if err != nil {
+		return "", err
+	}
+
+	// The declarations of the printed file are the declarations of the
+	// transformed file, in the same order.
+	fset := token.NewFileSet()
+	printed, err := parser.ParseFile(fset, f.path, src, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse file: %v", err)
+	}
+	if len(printed.Decls) != len(f.file.Decls) {
+		return "", fmt.Errorf("Failed to match the declarations of the transformed file")
+	}
+
+	sources := []string{}
+	for i, decl := range f.file.Decls {
+		if f.layout.origin(decl) == original {
+			sources = append(sources, nodeSource(fset, src, printed.Decls[i]))
+		}
+	}
+
+	return strings.Join(sources, "\n\n"), nil
+}
+
+// String returns the explanation as printed by `got explain`.
+func (e *Explanation) String() string {
+	buf := bytes.NewBuffer([]byte{})
+
+	target := e.Target
+	if e.TargetName != "" {
+		target += " " + e.TargetName
+	}
+	fmt.Fprintf(buf, "%s: %s\n", e.Pos, target)
+
+	if len(e.Attributes) == 0 {
+		buf.WriteString("\nNo attributes.\n")
+	}
+	for _, attribute := range e.Attributes {
+		usage := attribute.Name
+		if len(attribute.Args) > 0 {
+			usage += "(" + strings.Join(attribute.Args, ",") + ")"
+		}
+		fmt.Fprintf(buf, "\n#[%s] at %s (%s)\n", usage, attribute.Pos, attribute.Kind)
+
+		if attribute.Decorator != nil {
+			fmt.Fprintf(buf, "decorator %s at %s\n", attribute.Decorator.Name, attribute.Decorator.Pos)
+			writeIndented(buf, attribute.Source)
+		}
+	}
+
+	buf.WriteString("\nBefore:\n")
+	writeIndented(buf, e.Before)
+
+	buf.WriteString("\nAfter:\n")
+	if e.After == "" {
+		buf.WriteString("\t(deleted)\n")
+	} else {
+		writeIndented(buf, e.After)
+	}
+
+	if len(e.Diagnostics) > 0 {
+		buf.WriteString("\nDiagnostics:\n")
+	}
+	for _, diagnostic := range e.Diagnostics {
+		fmt.Fprintf(buf, "\t%s\n", diagnostic)
+	}
+
+	return buf.String()
+}
+
+// writeIndented writes the lines of the text indented by a tab.
+func writeIndented(buf *bytes.Buffer, text string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if line == "" {
+			buf.WriteString("\n")
+			continue
+		}
+		fmt.Fprintf(buf, "\t%s\n", line)
+	}
+}
@@ -0,0 +1,158 @@
+package transform
+
+import (
+	"context"
+	"go/ast"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func explainTestOptions() []Option {
+	stringer := func(c *TransformContext) error {
+		spec := c.Node().(*ast.GenDecl).Specs[0].(*ast.TypeSpec)
+		decl, err := c.Decl(`func (v $T) String() string { return %q }`, spec.Name, spec.Name.Name)
+		if err != nil {
+			return err
+		}
+		c.InsertAfter(decl)
+		return nil
+	}
+
+	drop := func(c *TransformContext) error {
+		c.Delete()
+		return nil
+	}
+
+	return []Option{WithDecorator("Stringer", stringer), WithDecorator("Drop", drop)}
+}
+
+func TestExplain(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": `package p
+
+// Color is a color.
+// #[Stringer]
+type Color int
+
+// #[Drop]
+func Debug() {}
+
+func F() {
+	// #[Unknown(x)]
+	println()
+}
+`,
+	})
+	path := filepath.Join(dir, "a.go")
+
+	explanation, err := Explain(context.Background(), path, 5, explainTestOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if explanation.Target != "TypeDecl" || explanation.TargetName != "Color" {
+		t.Errorf("Expected TypeDecl Color, got %s %s", explanation.Target, explanation.TargetName)
+	}
+	if len(explanation.Attributes) != 1 || explanation.Attributes[0].Name != "Stringer" ||
+		explanation.Attributes[0].Kind != "decorator" {
+		t.Errorf("Expected the Stringer decorator, got %v", explanation.Attributes)
+	}
+
+	before := "// Color is a color.\n// #[Stringer]\ntype Color int"
+	if explanation.Before != before {
+		t.Errorf("Expected %s, got %s", before, explanation.Before)
+	}
+	after := "// Color is a color.\ntype Color int\n\nfunc (v Color) String() string {\n\treturn \"Color\"\n}"
+	if explanation.After != after {
+		t.Errorf("Expected %s, got %s", after, explanation.After)
+	}
+
+	explanation, err = Explain(context.Background(), path, 8, explainTestOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explanation.After != "" {
+		t.Errorf("Expected the declaration to be deleted, got %s", explanation.After)
+	}
+
+	explanation, err = Explain(context.Background(), path, 12, explainTestOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explanation.Target != "ExprStmt" {
+		t.Errorf("Expected ExprStmt, got %s", explanation.Target)
+	}
+	if len(explanation.Attributes) != 1 || explanation.Attributes[0].Kind != "unknown" {
+		t.Errorf("Expected an unknown attribute, got %v", explanation.Attributes)
+	}
+	if len(explanation.Diagnostics) != 1 || !strings.Contains(explanation.Diagnostics[0].Message, "unknown attribute `Unknown`") {
+		t.Errorf("Expected an unknown attribute diagnostic, got %v", explanation.Diagnostics)
+	}
+	// The attribute comments are removed from the generated file.
+	after = "func F() {\n\tprintln()\n}"
+	if explanation.After != after {
+		t.Errorf("Expected %s, got %s", after, explanation.After)
+	}
+}
+
+func TestExplainDecoratorSource(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": `package p
+
+// #[Stringer]
+type Color int
+`,
+		"b.go": `package p
+
+// Stringer adds a String method.
+// #[decorator]
+func Stringer(c *TransformContext) error { return nil }
+`,
+	})
+
+	// The decorator of the package is replaced by the option, so that no
+	// plugin is built.
+	transformer := NewTransformer(dir, explainTestOptions()...)
+	transformer.inMemory = true
+	files, err := transformer.prepareFiles(context.Background(), []string{filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decorators, err := transformer.packageDecorators(files, filepath.Join(dir, "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decorator, ok := decorators["Stringer"]
+	if !ok {
+		t.Fatalf("Expected the Stringer decorator, got %v", decorators)
+	}
+	if decorator.function.Doc != "Stringer adds a String method." {
+		t.Errorf("Expected %s, got %s", "Stringer adds a String method.", decorator.function.Doc)
+	}
+	source := "// Stringer adds a String method.\n// #[decorator]\nfunc Stringer(c *TransformContext) error { return nil }"
+	if decorator.source != source {
+		t.Errorf("Expected %s, got %s", source, decorator.source)
+	}
+}
+
+func TestExplainErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": "package p\n\nfunc A() {}\n",
+	})
+
+	_, err := Explain(context.Background(), filepath.Join(dir, "a.go"), 2)
+	if err == nil || !strings.Contains(err.Error(), "no declaration at this line") {
+		t.Errorf("Expected a missing declaration error, got %v", err)
+	}
+
+	_, err = Explain(context.Background(), filepath.Join(dir, "b.go"), 3)
+	if err == nil || !strings.Contains(err.Error(), "is not part of the package") {
+		t.Errorf("Expected a missing file error, got %v", err)
+	}
+}
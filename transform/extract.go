@@ -1,6 +1,8 @@
 package transform
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"go/ast"
 	"os"
@@ -10,12 +12,14 @@ import (
 )
 
 // extractAsPlugin extracts the function and saves it as a plugin in the
-// specified directory.
-// First it creates a new directory for the extracted function.
+// specified directory of the build directory.
+// First it creates a new directory for the extracted function, in the
+// directory of the extracted functions of the same kind, so that functions
+// of different kinds may have the same name.
 // Then it creates a new file in the directory with the extracted function.
 // Then it executes goimports on the file.
 // Then it builds the file as a plugin.
-func extractAsPlugin(name, src, extractDir string, imports []*ast.ImportSpec, hashSum string) error {
+func extractAsPlugin(buildDir, name, src, extractDir string, imports []*ast.ImportSpec, hashSum string) error {
 	extractedSrc := "package main\n\n"
 	if len(imports) > 0 {
 		extractedSrc += "import (\n"
@@ -29,10 +33,10 @@ func extractAsPlugin(name, src, extractDir string, imports []*ast.ImportSpec, ha
 		extractedSrc += ")\n"
 	}
 	extractedSrc += string(src)
-	extractedSrcDir := filepath.Join(GOT_BUILD_DIR, GOT_EXTRACT_DIR, name)
+	extractedSrcDir := filepath.Join(buildDir, GOT_EXTRACT_DIR, extractDir, name)
 	extractedSrcPath := filepath.Join(extractedSrcDir, "extract.go")
 
-	methodBinPath := filepath.Join(GOT_BUILD_DIR, extractDir, fmt.Sprintf("%s.so", name))
+	methodBinPath := filepath.Join(buildDir, extractDir, fmt.Sprintf("%s.so", name))
 
 	if err := os.MkdirAll(extractedSrcDir, 0755); err != nil {
 		return err
@@ -67,11 +71,39 @@ func extractAsPlugin(name, src, extractDir string, imports []*ast.ImportSpec, ha
 	return nil
 }
 
+// pluginDir returns the directory of the build directory where the
+// functions of the package are extracted and built to plugins. Since the
+// plugins are loaded by path, each package has its own directory, named
+// after its directory and the hash of its absolute path: with
+// `got build ./...`, two packages may declare decorators with the same
+// name.
+func (t *Transformer) pluginDir() string {
+	dir, err := filepath.Abs(t.baseDir)
+	if err != nil {
+		dir = t.baseDir
+	}
+
+	sum := sha256.Sum256([]byte(dir))
+	name := filepath.Base(dir) + "-" + hex.EncodeToString(sum[:4])
+	return filepath.Join(t.buildDir, GOT_PACKAGES_DIR, name)
+}
+
+// kindDir returns the directory of the extracted functions of a kind, such
+// as GOT_DECORATORS_DIR, declared in the file. The functions declared in
+// test files have their own directories, like `decorators_test/`, so that
+// they never share the plugins of the functions declared with the same
+// names in the other files.
+func kindDir(dir, path string) string {
+	if isTestFile(path) {
+		return strings.TrimSuffix(dir, "/") + "_test/"
+	}
+	return dir
+}
+
 func loadExtractedFunction[T any](path string) (T, error) {
 	method, err := plugin.Open(path)
 	if err != nil {
-		log(err)
-		os.Exit(1)
+		return *new(T), err
 	}
 
 	name := filepath.Base(path)
@@ -81,5 +113,10 @@ func loadExtractedFunction[T any](path string) (T, error) {
 		return *new(T), err
 	}
 
-	return fn.(T), nil
+	result, ok := fn.(T)
+	if !ok {
+		return *new(T), fmt.Errorf("unexpected signature of `%s`: %T", name, fn)
+	}
+
+	return result, nil
 }
@@ -0,0 +1,309 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store is a key/value store shared by the decorators and the finalizers of
+// a transformer run, so decorators can collect data from all the files of
+// the package, like the routes of an HTTP server, and finalizers generate
+// code from it.
+// Files are transformed in parallel: the store is safe for concurrent use,
+// but the order of the values appended by different files is not
+// deterministic, so finalizers should sort them.
+type Store struct {
+	mu     sync.Mutex
+	values map[string]interface{}
+}
+
+// newStore returns an empty store.
+func newStore() *Store {
+	return &Store{values: map[string]interface{}{}}
+}
+
+// Get returns the value of the key, and whether it is set.
+func (s *Store) Get(key string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// Set sets the value of the key.
+func (s *Store) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key] = value
+}
+
+// Update sets the value of the key to the value returned by fn, given the
+// current value and whether it is set, atomically.
+func (s *Store) Update(key string, fn func(value interface{}, ok bool) interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.values[key]
+	s.values[key] = fn(value, ok)
+}
+
+// Append appends the values to the []interface{} value of the key.
+// It panics if the key has a value of another type.
+func (s *Store) Append(key string, values ...interface{}) {
+	s.Update(key, func(value interface{}, ok bool) interface{} {
+		if !ok {
+			return append([]interface{}{}, values...)
+		}
+		return append(value.([]interface{}), values...)
+	})
+}
+
+// Keys returns the keys of the store, sorted.
+func (s *Store) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Store returns the store shared by the decorators and the finalizers of
+// the transformer run.
+func (t *TransformContext) Store() *Store {
+	return t.file.transformer.store
+}
+
+// FinalizeContext is the context of a finalizer, called once all the files
+// of the package are transformed.
+// The declarations added by the finalizer are written to the generated
+// file of the finalizer, `got_<name>_generated.go`, in the package
+// directory.
+type FinalizeContext struct {
+	ctx         context.Context
+	name        string
+	packageName string
+	transformer *Transformer
+	decls       []ast.Decl
+}
+
+// Context returns the context of the finalizer call, which is cancelled
+// when the call times out. Finalizers running for long should return once
+// it is done.
+func (c *FinalizeContext) Context() context.Context {
+	return c.ctx
+}
+
+// Name returns the name of the finalizer.
+func (c *FinalizeContext) Name() string {
+	return c.name
+}
+
+// Package returns the name of the package.
+func (c *FinalizeContext) Package() string {
+	return c.packageName
+}
+
+// Store returns the store shared by the decorators and the finalizers of
+// the transformer run.
+func (c *FinalizeContext) Store() *Store {
+	return c.transformer.store
+}
+
+// AddDecl adds declarations to the generated file of the finalizer. The
+// imports it requires are added when the file is written.
+func (c *FinalizeContext) AddDecl(decls ...ast.Decl) {
+	c.decls = append(c.decls, decls...)
+}
+
+// Decl builds a declaration from a Go template, like TransformContext.Decl.
+// See TransformContext.Expr for the template syntax.
+func (c *FinalizeContext) Decl(template string, args ...interface{}) (ast.Decl, error) {
+	return quoteDecl(template, args...)
+}
+
+// finalizedFileName returns the name of the file generated by a finalizer.
+func finalizedFileName(baseDir, name string) string {
+	return filepath.Join(baseDir, "got_"+strings.ToLower(name)+"_generated"+GO_FILE_EXTENSION)
+}
+
+// runFinalizers calls the finalizers once all the files are transformed,
+// in the order of their names, and writes their generated files. The files
+// of the finalizers which no longer exist are removed.
+// Nothing is done when the package has no transformed file.
+func (t *Transformer) runFinalizers(files []*fileTransform) ([]FinalizerResult, error) {
+	packageName := ""
+	for _, f := range files {
+		if f != nil && !isTestFile(f.path) {
+			packageName = f.file.Name.Name
+			break
+		}
+	}
+
+	t.mu.Lock()
+	finalizers := map[string]ExtractedFinalizer{}
+	names := make([]string, 0, len(t.finalizers))
+	for name, fn := range t.finalizers {
+		finalizers[name] = fn
+		names = append(names, name)
+	}
+	t.mu.Unlock()
+	sort.Strings(names)
+
+	if packageName == "" {
+		return nil, nil
+	}
+
+	var results []FinalizerResult
+	generated := map[string]bool{}
+	for _, name := range names {
+		c := &FinalizeContext{name: name, packageName: packageName, transformer: t}
+
+		t.log("Executing finalizer:", name)
+		err := isolate(t.callTimeout(name), func(ctx context.Context) error {
+			c.ctx = ctx
+			return finalizers[name](c)
+		})
+		if _, stack := isolationError(err); stack != nil {
+			t.log(fmt.Sprintf("Finalizer `%s` panicked:\n%s", name, stack))
+		}
+		if err != nil {
+			return results, fmt.Errorf("Failed to execute finalizer `%s`: %v", name, err)
+		}
+
+		goFile, output, err := t.writeFinalized(c)
+		if err != nil {
+			return results, fmt.Errorf("Failed to write the file of finalizer `%s`: %v", name, err)
+		}
+		results = append(results, FinalizerResult{Name: name, Generated: goFile, Output: output})
+		generated[finalizedFileName(t.baseDir, name)] = true
+	}
+
+	if t.inMemory {
+		return results, nil
+	}
+	return results, t.removeStaleFinalized(generated)
+}
+
+// removeStaleFinalized removes the files generated by the finalizers which
+// were removed or renamed since, that is the files of the package
+// directory generated by a finalizer and not generated by this run.
+// When files are filtered, the finalizers of the files left out are
+// unknown, so no file is removed.
+func (t *Transformer) removeStaleFinalized(generated map[string]bool) error {
+	if t.fileFilter != nil {
+		return nil
+	}
+
+	paths, err := filepath.Glob(finalizedFileName(t.baseDir, "*"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if generated[path] {
+			continue
+		}
+
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if from, ok := generatedFrom(src); !ok || !strings.HasPrefix(from, "finalizer ") {
+			continue
+		}
+
+		if err := t.removeStaleFile(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFinalized writes the generated file of a finalizer, and returns its
+// path. When the finalizer added no declaration, the file previously
+// generated is removed, and an empty path is returned.
+// When transforming in memory, nothing is written or removed, and the
+// generated source is returned along with the path.
+func (t *Transformer) writeFinalized(c *FinalizeContext) (string, []byte, error) {
+	goFile := finalizedFileName(t.baseDir, c.name)
+
+	if len(c.decls) == 0 {
+		if t.inMemory {
+			return "", nil, nil
+		}
+		return "", nil, t.removeStaleFile(goFile)
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	buf.WriteString(generatedHeader(t.version, "finalizer "+c.name))
+	if t.constraintMode != ConstraintsOverlay {
+		buf.WriteString("//go:build " + GENERATED_TAG + "\n\n")
+	}
+	fmt.Fprintf(buf, "package %s\n", c.packageName)
+	for _, decl := range c.decls {
+		buf.WriteString("\n")
+		if err := printer.Fprint(buf, token.NewFileSet(), decl); err != nil {
+			return "", nil, fmt.Errorf("Failed to print declaration: %v", err)
+		}
+		buf.WriteString("\n")
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to format file: %v", err)
+	}
+
+	if t.inMemory {
+		src, err = goImportsSource(goFile, src)
+		return goFile, src, err
+	}
+
+	if t.constraintMode == ConstraintsOverlay {
+		if err := t.removeStaleFile(goFile); err != nil {
+			return "", nil, err
+		}
+
+		source, err := filepath.Abs(goFile)
+		if err != nil {
+			return "", nil, err
+		}
+
+		goFile = filepath.Join(t.buildDir, GOT_OVERLAY_DIR, goFile)
+		if err := os.MkdirAll(filepath.Dir(goFile), 0755); err != nil {
+			return "", nil, err
+		}
+
+		generated, err := filepath.Abs(goFile)
+		if err != nil {
+			return "", nil, err
+		}
+
+		t.mu.Lock()
+		t.overlay[source] = generated
+		t.mu.Unlock()
+	}
+
+	t.log("Writing to file:", goFile)
+	if err := writeGeneratedFile(goFile, src); err != nil {
+		return "", nil, err
+	}
+
+	return goFile, nil, executeGoImports(goFile)
+}
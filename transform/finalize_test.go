@@ -0,0 +1,205 @@
+package transform
+
+import (
+	"context"
+	"go/ast"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	store := newStore()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store.Append("values", i)
+			store.Update("count", func(value interface{}, ok bool) interface{} {
+				if !ok {
+					return 1
+				}
+				return value.(int) + 1
+			})
+		}(i)
+	}
+	wg.Wait()
+	store.Set("name", "got")
+
+	values, _ := store.Get("values")
+	if len(values.([]interface{})) != 10 {
+		t.Errorf("Expected 10 values, got %d", len(values.([]interface{})))
+	}
+	if count, _ := store.Get("count"); count != 10 {
+		t.Errorf("Expected 10, got %v", count)
+	}
+	if _, ok := store.Get("missing"); ok {
+		t.Errorf("Expected missing key")
+	}
+	if keys := strings.Join(store.Keys(), ","); keys != "count,name,values" {
+		t.Errorf("Expected count,name,values, got %s", keys)
+	}
+}
+
+// routeDecorators returns the options registering the Route decorator and
+// the Routes finalizer, generating a table of the routes.
+func routeDecorators() []Option {
+	route := func(c *TransformContext) error {
+		c.Store().Append("routes", c.Args()[0]+" "+c.Node().(*ast.FuncDecl).Name.Name)
+		return nil
+	}
+
+	routes := func(c *FinalizeContext) error {
+		values, ok := c.Store().Get("routes")
+		if !ok {
+			return nil
+		}
+
+		routes := []string{}
+		for _, value := range values.([]interface{}) {
+			routes = append(routes, value.(string))
+		}
+		sort.Strings(routes)
+
+		elts := []ast.Expr{}
+		for _, route := range routes {
+			fields := strings.Fields(route)
+			elt, err := quoteExpr(`route{%q, $handler}`, fields[0], fields[1])
+			if err != nil {
+				return err
+			}
+			elt.(*ast.CompositeLit).Type = nil
+			elts = append(elts, elt)
+		}
+
+		decl, err := c.Decl(`var routes = []struct {
+	path    string
+	handler func() string
+}{$elts...}`, elts)
+		if err != nil {
+			return err
+		}
+		c.AddDecl(decl)
+		return nil
+	}
+
+	return []Option{WithDecorator("Route", route), WithFinalizer("Routes", routes)}
+}
+
+func TestFinalizer(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": "//go:build !generated\n\npackage p\n\n// #[Route(/a)]\nfunc A() string { return \"a\" }\n",
+		"b.go": "//go:build !generated\n\npackage p\n\n// #[Route(/b)]\nfunc B() string { return \"b\" }\n\n// #[Route(/c)]\nfunc C() string { return \"c\" }\n",
+	})
+
+	opts := append(routeDecorators(), WithBuildDir(t.TempDir()), WithParallelism(2))
+	result, err := NewTransformer(dir, opts...).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generated := filepath.Join(dir, "got_routes_generated.go")
+	if len(result.Finalizers) != 1 || result.Finalizers[0].Generated != generated {
+		t.Fatalf("Expected the Routes finalizer to write %s, got %v", generated, result.Finalizers)
+	}
+
+	src, err := os.ReadFile(generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `// Code generated by got devel from finalizer Routes. DO NOT EDIT.
+
+//go:build generated
+
+package p
+
+var routes = []struct {
+	path    string
+	handler func() string
+}{{"/a", A}, {"/b", B}, {"/c", C}}
+`
+	if string(src) != expected {
+		t.Errorf("Expected %s, got %s", expected, src)
+	}
+
+	// The file is removed once no route is left.
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": "//go:build !generated\n\npackage p\n\nfunc A() string { return \"a\" }\n",
+		"b.go": "//go:build !generated\n\npackage p\n\nfunc B() string { return \"b\" }\n",
+	})
+
+	result, err = NewTransformer(dir, opts...).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Finalizers) != 1 || result.Finalizers[0].Generated != "" {
+		t.Errorf("Expected no generated file, got %v", result.Finalizers)
+	}
+	if _, err := os.Stat(generated); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed", generated)
+	}
+}
+
+func TestFinalizerOverlay(t *testing.T) {
+	dir := t.TempDir()
+	buildDir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": "package p\n\n// #[Route(/a)]\nfunc A() string { return \"a\" }\n",
+	})
+
+	opts := append(routeDecorators(), WithBuildDir(buildDir), WithConstraints(ConstraintsOverlay))
+	result, err := NewTransformer(dir, opts...).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, _ := filepath.Abs(filepath.Join(dir, "got_routes_generated.go"))
+	generated, ok := result.Overlay[source]
+	if !ok {
+		t.Fatalf("Expected an overlay for %s, got %v", source, result.Overlay)
+	}
+
+	src, err := os.ReadFile(generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(src), "go:build") || !strings.Contains(string(src), `{"/a", A}`) {
+		t.Errorf("Expected the routes without constraint, got %s", src)
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("Expected no file in the package directory")
+	}
+}
+
+func TestFinalizerStaleFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": "//go:build !generated\n\npackage p\n\n// #[Route(/a)]\nfunc A() string { return \"a\" }\n",
+		// The file of a finalizer renamed since.
+		"got_oldroutes_generated.go": "// Code generated by got devel from finalizer OldRoutes. DO NOT EDIT.\n\n//go:build generated\n\npackage p\n",
+		// Files not generated by a finalizer are kept.
+		"got_x_generated.go":    "// Code generated by got devel from got_x.go. DO NOT EDIT.\n\n//go:build generated\n\npackage p\n",
+		"got_hand_generated.go": "//go:build generated\n\npackage p\n",
+	})
+
+	opts := append(routeDecorators(), WithBuildDir(t.TempDir()))
+	if _, err := NewTransformer(dir, opts...).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "got_oldroutes_generated.go")); !os.IsNotExist(err) {
+		t.Errorf("Expected the file of the OldRoutes finalizer to be removed")
+	}
+	for _, name := range []string{"got_routes_generated.go", "got_x_generated.go", "got_hand_generated.go"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", name, err)
+		}
+	}
+}
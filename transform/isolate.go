@@ -0,0 +1,78 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// panicError is the error of a decorator or finalizer call which panicked.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panicked: %v", e.value)
+}
+
+// timeoutError is the error of a decorator or finalizer call which did not
+// return in time.
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.timeout)
+}
+
+// isolate calls fn, returning a *panicError if it panics, and a
+// *timeoutError if it does not return before the timeout, when the timeout
+// is positive. The context given to fn is cancelled once the timeout is
+// reached, so the call can stop.
+// A call that timed out can't be stopped otherwise: it keeps running in
+// the background, and the caller must abort the transformation, since the
+// call may still modify the nodes it was given.
+func isolate(timeout time.Duration, fn func(ctx context.Context) error) error {
+	call := func(ctx context.Context) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = &panicError{value: r, stack: debug.Stack()}
+			}
+		}()
+		return fn(ctx)
+	}
+
+	if timeout <= 0 {
+		return call(context.Background())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- call(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return &timeoutError{timeout: timeout}
+	}
+}
+
+// isolationError returns whether the error was returned by isolate itself
+// rather than by the function it called, and the stack of the panic, if
+// any.
+func isolationError(err error) (bool, []byte) {
+	switch err := err.(type) {
+	case *panicError:
+		return true, err.stack
+	case *timeoutError:
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,76 @@
+package transform
+
+import (
+	"context"
+	"errors"
+	"go/ast"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsolate(t *testing.T) {
+	expected := errors.New("failed")
+	if err := isolate(0, func(ctx context.Context) error { return expected }); err != expected {
+		t.Errorf("Expected %v, got %v", expected, err)
+	}
+
+	err := isolate(0, func(ctx context.Context) error { panic("boom") })
+	if isolated, stack := isolationError(err); !isolated || stack == nil || err.Error() != "panicked: boom" {
+		t.Errorf("Expected a panic error with its stack, got %v", err)
+	}
+
+	stopped := make(chan struct{})
+	err = isolate(10*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		close(stopped)
+		return ctx.Err()
+	})
+	if isolated, _ := isolationError(err); !isolated || err.Error() != "timed out after 10ms" {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the context of the call to be cancelled")
+	}
+}
+
+func TestTransformerRunIsolation(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": "package p\n\n// #[Options]\nconst A = B\n",
+	})
+
+	// Like an unchecked type assertion in a decorator.
+	options := func(c *TransformContext) error {
+		spec := c.Node().(*ast.GenDecl).Specs[0].(*ast.ValueSpec)
+		_ = spec.Values[0].(*ast.BasicLit)
+		return nil
+	}
+
+	_, err := NewTransformer(dir, WithDecorator("Options", options), WithBuildDir(t.TempDir())).Run(context.Background())
+	expected := filepath.Join(dir, "a.go") + ":3:1: attribute `Options` panicked: interface conversion"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+
+	blocking := func(c *TransformContext) error {
+		<-c.Context().Done()
+		return c.Context().Err()
+	}
+
+	opts := []Option{
+		WithDecorator("Options", blocking),
+		WithBuildDir(t.TempDir()),
+		WithTimeout(time.Hour),
+		WithDecoratorTimeout("Options", 10*time.Millisecond),
+	}
+	_, err = NewTransformer(dir, opts...).Run(context.Background())
+	expected = filepath.Join(dir, "a.go") + ":3:1: attribute `Options` timed out after 10ms"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+}
@@ -0,0 +1,218 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"strings"
+)
+
+// Inventory lists the functions extracted by the builtin attributes of a
+// package, and the attributes bound to its nodes.
+type Inventory struct {
+	Functions []ExtractedFunction `json:"functions"`
+	Usages    []AttributeUsage    `json:"usages,omitempty"`
+}
+
+// ExtractedFunction is a function extracted by a builtin attribute: a
+// decorator, a method or a finalizer.
+type ExtractedFunction struct {
+	// Kind is the name of the builtin attribute extracting the function:
+	// `decorator`, `method` or `finalize`.
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Pos  string `json:"pos"`
+
+	// Doc is the doc comment of the function, without its attributes, and
+	// Signature its declaration without its body.
+	Doc       string `json:"doc,omitempty"`
+	Signature string `json:"signature,omitempty"`
+
+	// Spec is the spec declared by a decorator, if any.
+	Spec *DecoratorSpec `json:"spec,omitempty"`
+}
+
+// AttributeUsage is an attribute bound to a node of a package.
+type AttributeUsage struct {
+	Name string   `json:"name"`
+	Args []string `json:"args,omitempty"`
+	Pos  string   `json:"pos"`
+
+	// Target is the kind of the node, such as `FuncDecl` or `TypeDecl`,
+	// and TargetName its name, for the declarations, specs and fields.
+	Target     string `json:"target"`
+	TargetName string `json:"targetName,omitempty"`
+	TargetPos  string `json:"targetPos"`
+}
+
+// extractingAttributes are the builtin attributes extracting functions.
+var extractingAttributes = map[string]bool{
+	"decorator": true,
+	"method":    true,
+	"finalize":  true,
+}
+
+// List returns the inventory of the package in the base directory: the
+// functions extracted by its builtin attributes and the attributes bound to
+// its nodes, in the order of the files. Test files are only listed when
+// tests are included.
+// The files are only read, nothing is transformed or written.
+func List(ctx context.Context, baseDir string, opts ...Option) (*Inventory, error) {
+	t := NewTransformer(baseDir, opts...)
+
+	paths, err := t.packageFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := &Inventory{Functions: []ExtractedFunction{}, Usages: []AttributeUsage{}}
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		src, err := t.readSource(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read file: %v", err)
+		}
+
+		f, err := t.newFileTransform(path, src)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := f.inventory(inventory); err != nil {
+			return nil, err
+		}
+	}
+
+	return inventory, nil
+}
+
+// inventory adds the functions and the attributes of the file to the
+// inventory.
+func (f *fileTransform) inventory(inventory *Inventory) error {
+	for _, usage := range f.usages {
+		for _, attribute := range usage.attributes {
+			inventory.Usages = append(inventory.Usages, AttributeUsage{
+				Name:       attribute.Name,
+				Args:       attribute.Arguments,
+				Pos:        usage.position.String(),
+				Target:     targetKinds(usage.node)[0],
+				TargetName: nodeName(usage.node),
+				TargetPos:  f.fset.Position(usage.node.Pos()).String(),
+			})
+
+			fn, ok := usage.node.(*ast.FuncDecl)
+			if !ok || !extractingAttributes[attribute.Name] {
+				continue
+			}
+
+			extracted, err := f.extractedFunction(attribute.Name, fn)
+			if err != nil {
+				return err
+			}
+			inventory.Functions = append(inventory.Functions, extracted)
+		}
+	}
+
+	return nil
+}
+
+// extractedFunction describes a function of the file extracted by the
+// builtin attribute of the kind.
+func (f *fileTransform) extractedFunction(kind string, fn *ast.FuncDecl) (ExtractedFunction, error) {
+	signature := bytes.NewBuffer([]byte{})
+	err := printer.Fprint(signature, f.fset, &ast.FuncDecl{Recv: fn.Recv, Name: fn.Name, Type: fn.Type})
+	if err != nil {
+		return ExtractedFunction{}, fmt.Errorf("Failed to print function `%s`: %v", fn.Name.Name, err)
+	}
+
+	extracted := ExtractedFunction{
+		Kind:      kind,
+		Name:      fn.Name.Name,
+		Pos:       f.fset.Position(fn.Pos()).String(),
+		Doc:       docText(fn.Doc),
+		Signature: signature.String(),
+	}
+	if kind == "decorator" {
+		spec, ok, err := parseDecoratorSpec(f.boundAttributes(fn, false))
+		if err != nil {
+			return extracted, err
+		}
+		if ok {
+			extracted.Spec = &spec
+		}
+	}
+	return extracted, nil
+}
+
+// nodeName returns the name of a declaration, spec or field, such as
+// `T.Method` for a method, or an empty string.
+func nodeName(node ast.Node) string {
+	switch v := node.(type) {
+	case *ast.FuncDecl:
+		if v.Recv != nil && len(v.Recv.List) > 0 {
+			return fmt.Sprintf("%s.%s", receiverName(v.Recv.List[0].Type), v.Name.Name)
+		}
+		return v.Name.Name
+	case *ast.GenDecl:
+		if len(v.Specs) > 0 {
+			return nodeName(v.Specs[0])
+		}
+	case *ast.TypeSpec:
+		return v.Name.Name
+	case *ast.ValueSpec:
+		return identNames(v.Names)
+	case *ast.Field:
+		return identNames(v.Names)
+	case *ast.ImportSpec:
+		return v.Path.Value
+	}
+	return ""
+}
+
+// receiverName returns the name of the type of a method receiver.
+func receiverName(expr ast.Expr) string {
+	switch v := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(v.X)
+	case *ast.IndexExpr:
+		return receiverName(v.X)
+	case *ast.IndexListExpr:
+		return receiverName(v.X)
+	case *ast.Ident:
+		return v.Name
+	}
+	return ""
+}
+
+// identNames returns the names of the identifiers, separated by commas.
+func identNames(idents []*ast.Ident) string {
+	names := []string{}
+	for _, ident := range idents {
+		names = append(names, ident.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// docText returns the text of a doc comment without its attribute lines.
+func docText(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+
+	group := &ast.CommentGroup{}
+	for _, comment := range doc.List {
+		if !IsLineGotPrefixed(comment.Text) {
+			group.List = append(group.List, comment)
+		}
+	}
+	if len(group.List) == 0 {
+		return ""
+	}
+
+	return strings.TrimSpace(group.Text())
+}
@@ -0,0 +1,96 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": `package p
+
+// #[Log(info)]
+func A() {}
+
+// Log logs the calls of a function.
+// #[decorator]
+// #[target(FuncDecl)]
+// #[arg(level, string, debug)]
+func Log(c *TransformContext) error { return nil }
+`,
+		"b.go": `package p
+
+// #[method]
+func Upper(args ...interface{}) interface{} { return nil }
+
+type T struct {
+	X int // #[Skip]
+}
+
+// #[finalize]
+func (t *T) Routes(c *FinalizeContext) error { return nil }
+`,
+		"b_test.go": "package p\n\n// #[decorator]\nfunc Fixture(c *TransformContext) error { return nil }\n",
+	})
+
+	inventory, err := List(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	functions := []string{}
+	for _, fn := range inventory.Functions {
+		functions = append(functions, fmt.Sprintf("%s %s %s %q",
+			fn.Kind, fn.Name, strings.TrimPrefix(fn.Pos, dir+string(filepath.Separator)), fn.Doc))
+		if fn.Spec != nil {
+			functions = append(functions, fmt.Sprintf("%v %v", fn.Spec.Targets, fn.Spec.Args))
+		}
+	}
+	expected := []string{
+		`decorator Log a.go:10:1 "Log logs the calls of a function."`,
+		`[FuncDecl] [level string = "debug"]`,
+		`method Upper b.go:4:1 ""`,
+		`finalize Routes b.go:11:1 ""`,
+	}
+	if strings.Join(functions, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %s, got %s", strings.Join(expected, "\n"), strings.Join(functions, "\n"))
+	}
+
+	usages := []string{}
+	for _, usage := range inventory.Usages {
+		usages = append(usages, fmt.Sprintf("%s(%s) %s %s %s", usage.Name, strings.Join(usage.Args, ","),
+			usage.Target, usage.TargetName, strings.TrimPrefix(usage.TargetPos, dir+string(filepath.Separator))))
+	}
+	expected = []string{
+		"Log(info) FuncDecl A a.go:4:1",
+		"decorator() FuncDecl Log a.go:10:1",
+		"target(FuncDecl) FuncDecl Log a.go:10:1",
+		"arg(level, string, debug) FuncDecl Log a.go:10:1",
+		"method() FuncDecl Upper b.go:4:1",
+		"Skip() Field X b.go:7:2",
+		"finalize() FuncDecl T.Routes b.go:11:1",
+	}
+	if strings.Join(usages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %s, got %s", strings.Join(expected, "\n"), strings.Join(usages, "\n"))
+	}
+}
+
+func TestListTests(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a_test.go": "package p\n\n// #[decorator]\nfunc Fixture(c *TransformContext) error { return nil }\n",
+	})
+
+	inventory, err := List(context.Background(), dir, WithTests(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inventory.Functions) != 1 || inventory.Functions[0].Name != "Fixture" {
+		t.Errorf("Expected the Fixture decorator, got %v", inventory.Functions)
+	}
+}
@@ -0,0 +1,681 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LanguageServer is a Language Server Protocol server for the attributes of
+// the go files. It reports the attributes which can't be parsed or bound to
+// a node, and the usages of decorators not matching their spec, as
+// diagnostics. It completes the attribute names with the builtin attributes
+// and the decorators of the package, shows the doc and the signature of a
+// decorator on hover, and goes to the definition of a decorator from its
+// attributes.
+// The `got.showGeneratedCode` command returns the generated source of a
+// file, transforming its package in another process, see GenerateCommand.
+// The open documents are analyzed from their content in the editor, the
+// other files of their package from the disk.
+type LanguageServer struct {
+	opts []Option
+
+	// GenerateCommand is the command printing the generated source of a
+	// file for the `got.showGeneratedCode` command, like
+	// `got show-generated -documents`. It is run with the path of the file as last argument,
+	// and the content of the open documents by path as a JSON object on
+	// its standard input.
+	// The package is transformed by another process since the plugins of
+	// the decorators can't be unloaded: the server would keep running the
+	// first version of a decorator edited since.
+	GenerateCommand []string
+
+	mu        sync.Mutex
+	documents map[string]string
+
+	writeMu sync.Mutex
+	out     io.Writer
+}
+
+// NewLanguageServer creates a language server. The options are applied to
+// the transformers of the packages of the documents, so decorators
+// registered with WithDecorator are completed and validated too.
+func NewLanguageServer(opts ...Option) *LanguageServer {
+	return &LanguageServer{opts: opts, documents: map[string]string{}}
+}
+
+// Serve reads the messages of the client from in and writes the messages of
+// the server to out, until the client sends the `exit` notification or
+// closes the stream.
+func (s *LanguageServer) Serve(ctx context.Context, in io.Reader, out io.Writer) error {
+	s.out = out
+	r := bufio.NewReader(in)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		body, err := readLSPMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to read message: %v", err)
+		}
+
+		message := lspMessage{}
+		if err := json.Unmarshal(body, &message); err != nil {
+			if err := s.reply(nil, nil, &lspError{Code: lspParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Responses are ignored, the server sends no request.
+		if message.Method == "" {
+			continue
+		}
+		if message.Method == "exit" {
+			return nil
+		}
+
+		result, err := s.handle(ctx, message.Method, message.Params)
+		if message.ID == nil {
+			continue
+		}
+		if err := s.reply(message.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a request or a notification, and returns its result.
+func (s *LanguageServer) handle(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1,
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{"[", ","}},
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"executeCommandProvider": map[string]interface{}{
+					"commands": []string{GOT_SHOW_GENERATED_COMMAND},
+				},
+			},
+			"serverInfo": map[string]string{"name": "got"},
+		}, nil
+
+	case "initialized", "shutdown", "textDocument/willSave", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+
+	case "textDocument/didOpen":
+		p := lspDidOpenParams{}
+		if err := decodeLSPParams(params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.open(p.TextDocument.URI, p.TextDocument.Text)
+
+	case "textDocument/didChange":
+		p := lspDidChangeParams{}
+		if err := decodeLSPParams(params, &p); err != nil {
+			return nil, err
+		}
+		if len(p.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.open(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+
+	case "textDocument/didSave":
+		p := lspTextDocumentParams{}
+		if err := decodeLSPParams(params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.publishDiagnostics(p.TextDocument.URI)
+
+	case "textDocument/didClose":
+		p := lspTextDocumentParams{}
+		if err := decodeLSPParams(params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.close(p.TextDocument.URI)
+
+	case "textDocument/completion":
+		p := lspTextDocumentPositionParams{}
+		if err := decodeLSPParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.completion(p)
+
+	case "textDocument/hover":
+		p := lspTextDocumentPositionParams{}
+		if err := decodeLSPParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.hover(p)
+
+	case "textDocument/definition":
+		p := lspTextDocumentPositionParams{}
+		if err := decodeLSPParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.definition(p)
+
+	case "workspace/executeCommand":
+		p := lspExecuteCommandParams{}
+		if err := decodeLSPParams(params, &p); err != nil {
+			return nil, err
+		}
+		return s.executeCommand(ctx, p)
+	}
+
+	return nil, &lspError{Code: lspMethodNotFound, Message: fmt.Sprintf("Method `%s` is not supported", method)}
+}
+
+// decodeLSPParams decodes the parameters of a message.
+func decodeLSPParams(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &lspError{Code: lspInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// reply sends the response to a request.
+func (s *LanguageServer) reply(id *json.RawMessage, result interface{}, err error) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err == nil {
+		return writeLSPMessage(s.out, lspResponse{JSONRPC: "2.0", ID: id, Result: result})
+	}
+
+	var responseErr *lspError
+	if !errors.As(err, &responseErr) {
+		responseErr = &lspError{Code: lspInternalError, Message: err.Error()}
+	}
+	return writeLSPMessage(s.out, lspErrorResponse{JSONRPC: "2.0", ID: id, Error: responseErr})
+}
+
+// notify sends a notification to the client.
+func (s *LanguageServer) notify(method string, params interface{}) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	return writeLSPMessage(s.out, lspNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// open records the content of an open document and publishes its
+// diagnostics.
+func (s *LanguageServer) open(uri, text string) error {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.documents[path] = text
+	s.mu.Unlock()
+
+	return s.publishDiagnostics(uri)
+}
+
+// close forgets a document and clears its diagnostics.
+func (s *LanguageServer) close(uri string) error {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.documents, path)
+	s.mu.Unlock()
+
+	return s.notify("textDocument/publishDiagnostics", lspPublishDiagnosticsParams{URI: uri, Diagnostics: []lspDiagnostic{}})
+}
+
+// source returns the content of a document, from the editor if it is open
+// or from the disk.
+func (s *LanguageServer) source(path string) (string, error) {
+	s.mu.Lock()
+	text, ok := s.documents[path]
+	s.mu.Unlock()
+	if ok {
+		return text, nil
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read file: %v", err)
+	}
+	return string(src), nil
+}
+
+// transformer returns a transformer for the package of a document.
+func (s *LanguageServer) transformer(path string) *Transformer {
+	opts := append([]Option{}, s.opts...)
+	return NewTransformer(filepath.Dir(path), append(opts, WithTests(isTestFile(path)))...)
+}
+
+// publishDiagnostics analyzes a document and publishes its diagnostics.
+func (s *LanguageServer) publishDiagnostics(uri string) error {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return err
+	}
+
+	text, err := s.source(path)
+	if err != nil {
+		return err
+	}
+
+	// The diagnostics of the go syntax are left to gopls, the attributes of
+	// the parsed declarations are still analyzed.
+	t := s.transformer(path)
+	f, diagnostics, _ := t.parseFile(path, []byte(text))
+	if f != nil {
+		diagnostics = append(diagnostics, f.checkSpecs(s.decorators(t, path))...)
+	}
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Pos.Offset < diagnostics[j].Pos.Offset
+	})
+
+	params := lspPublishDiagnosticsParams{URI: uri, Diagnostics: []lspDiagnostic{}}
+	for _, diagnostic := range diagnostics {
+		// The diagnostics cover the rest of the line of the attribute
+		// comment.
+		start := positionOffset(text, lspPosition{Line: diagnostic.Pos.Line - 1}) + diagnostic.Pos.Column - 1
+		end := start + strings.IndexByte(text[start:]+"\n", '\n')
+		params.Diagnostics = append(params.Diagnostics, lspDiagnostic{
+			Range:    lspRange{Start: offsetPosition(text, start), End: offsetPosition(text, end)},
+			Severity: lspSeverityError,
+			Source:   "got",
+			Message:  diagnostic.Message,
+		})
+	}
+
+	return s.notify("textDocument/publishDiagnostics", params)
+}
+
+// checkSpecs returns the diagnostics of the attributes of the file not
+// matching the spec of their decorator.
+func (f *fileTransform) checkSpecs(decorators map[string]lspDecorator) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for _, usage := range f.usages {
+		for _, attribute := range usage.attributes {
+			decorator, ok := decorators[attribute.Name]
+			if !ok || decorator.function.Spec == nil {
+				continue
+			}
+
+			if _, err := decorator.function.Spec.validate(usage.node, attribute.Arguments); err != nil {
+				diagnostics = append(diagnostics, Diagnostic{
+					Pos:     usage.position,
+					Message: fmt.Sprintf("attribute `%s` %v", attribute.Name, err),
+				})
+			}
+		}
+	}
+	return diagnostics
+}
+
+// lspDecorator is a decorator available to a document, with the location
+// of its function when it is declared by the package.
+type lspDecorator struct {
+	function ExtractedFunction
+	location *lspLocation
+}
+
+// decorators returns the decorators available to a document: the
+// decorators declared by the files of its package and the decorators
+// registered with options, by name.
+func (s *LanguageServer) decorators(t *Transformer, path string) map[string]lspDecorator {
+	decorators := map[string]lspDecorator{}
+
+	paths, _ := t.packageFiles()
+	for _, p := range paths {
+		if isTestFile(p) && !isTestFile(path) {
+			continue
+		}
+
+		text, err := s.source(p)
+		if err != nil {
+			continue
+		}
+		f, _, _ := t.parseFile(p, []byte(text))
+		if f == nil {
+			continue
+		}
+
+		for _, usage := range f.usages {
+			fn, ok := usage.node.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+
+			for _, attribute := range usage.attributes {
+				if attribute.Name != "decorator" {
+					continue
+				}
+
+				function, err := f.extractedFunction(attribute.Name, fn)
+				if err != nil {
+					continue
+				}
+				decorators[fn.Name.Name] = lspDecorator{
+					function: function,
+					location: &lspLocation{
+						URI: pathToURI(p),
+						Range: lspRange{
+							Start: offsetPosition(text, f.fset.Position(fn.Name.Pos()).Offset),
+							End:   offsetPosition(text, f.fset.Position(fn.Name.End()).Offset),
+						},
+					},
+				}
+			}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name := range t.decorators {
+		if _, ok := decorators[name]; ok {
+			continue
+		}
+
+		function := ExtractedFunction{Kind: "decorator", Name: name}
+		if spec, ok := t.specs[name]; ok {
+			function.Spec = &spec
+		}
+		decorators[name] = lspDecorator{function: function}
+	}
+
+	return decorators
+}
+
+// completion completes the name of the attribute at the position with the
+// builtin attributes and the decorators.
+func (s *LanguageServer) completion(params lspTextDocumentPositionParams) ([]lspCompletionItem, error) {
+	path, text, offset, err := s.position(params)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []lspCompletionItem{}
+	lineStart := strings.LastIndex(text[:offset], "\n") + 1
+	prefix, ok := attributePrefix(text[lineStart:offset])
+	if !ok {
+		return items, nil
+	}
+
+	for name := range BuiltinAttributes {
+		if strings.HasPrefix(name, prefix) {
+			items = append(items, lspCompletionItem{Label: name, Kind: lspCompletionKeyword, Detail: "builtin attribute"})
+		}
+	}
+
+	for name, decorator := range s.decorators(s.transformer(path), path) {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		item := lspCompletionItem{Label: name, Kind: lspCompletionFunction, Detail: decorator.function.Signature}
+		if doc := decoratorDoc(decorator.function); doc != "" {
+			item.Documentation = &lspMarkupText{Kind: "markdown", Value: doc}
+		}
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return items, nil
+}
+
+// hover describes the decorator or the builtin attribute at the position.
+func (s *LanguageServer) hover(params lspTextDocumentPositionParams) (*lspHover, error) {
+	path, text, offset, err := s.position(params)
+	if err != nil {
+		return nil, err
+	}
+
+	name, start, end, ok := attributeAt(text, offset)
+	if !ok {
+		return nil, nil
+	}
+	span := &lspRange{Start: offsetPosition(text, start), End: offsetPosition(text, end)}
+
+	if _, ok := BuiltinAttributes[name]; ok {
+		return &lspHover{
+			Contents: lspMarkupText{Kind: "markdown", Value: fmt.Sprintf("`#[%s]` is a builtin attribute.", name)},
+			Range:    span,
+		}, nil
+	}
+
+	decorator, ok := s.decorators(s.transformer(path), path)[name]
+	if !ok {
+		return nil, nil
+	}
+
+	value := decoratorDoc(decorator.function)
+	if decorator.function.Signature != "" {
+		value = "```go\n" + decorator.function.Signature + "\n```\n\n" + value
+	}
+	return &lspHover{Contents: lspMarkupText{Kind: "markdown", Value: strings.TrimSpace(value)}, Range: span}, nil
+}
+
+// definition returns the location of the function of the decorator at the
+// position.
+func (s *LanguageServer) definition(params lspTextDocumentPositionParams) (*lspLocation, error) {
+	path, text, offset, err := s.position(params)
+	if err != nil {
+		return nil, err
+	}
+
+	name, _, _, ok := attributeAt(text, offset)
+	if !ok {
+		return nil, nil
+	}
+
+	return s.decorators(s.transformer(path), path)[name].location, nil
+}
+
+// executeCommand executes the `got.showGeneratedCode` command, returning
+// the generated source of the file of its URI argument, or its source when
+// no attribute modifies it.
+func (s *LanguageServer) executeCommand(ctx context.Context, params lspExecuteCommandParams) (interface{}, error) {
+	if params.Command != GOT_SHOW_GENERATED_COMMAND {
+		return nil, &lspError{Code: lspInvalidParams, Message: fmt.Sprintf("Unknown command `%s`", params.Command)}
+	}
+
+	uri := ""
+	if len(params.Arguments) != 1 || json.Unmarshal(params.Arguments[0], &uri) != nil {
+		return nil, &lspError{Code: lspInvalidParams, Message: "Expected the URI of a document"}
+	}
+	path, err := uriToPath(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.GenerateCommand) == 0 {
+		return nil, fmt.Errorf("No command to generate the source of `%s`", path)
+	}
+
+	s.mu.Lock()
+	documents, err := json.Marshal(s.documents)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	args := append(append([]string{}, s.GenerateCommand[1:]...), filepath.Clean(path))
+	cmd := exec.CommandContext(ctx, s.GenerateCommand[0], args...)
+	cmd.Stdin = bytes.NewReader(documents)
+	stderr := bytes.NewBuffer([]byte{})
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("Failed to generate the source of `%s`: %s", path, message)
+		}
+		return nil, fmt.Errorf("Failed to generate the source of `%s`: %v", path, err)
+	}
+	return string(out), nil
+}
+
+// position returns the path and the text of the document of a request,
+// along with the byte offset of its position.
+func (s *LanguageServer) position(params lspTextDocumentPositionParams) (string, string, int, error) {
+	path, err := uriToPath(params.TextDocument.URI)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	text, err := s.source(path)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	return path, text, positionOffset(text, params.Position), nil
+}
+
+// attributeList returns the offset of the attribute list of the line, just
+// after its `#[`, when the line ends with an attribute comment.
+func attributeList(line string) (int, bool) {
+	comment := strings.Index(line, SINGLE_COMMENT)
+	if comment < 0 || !IsLineGotPrefixed(line[comment:]) {
+		return 0, false
+	}
+
+	start := strings.Index(line[comment:], GOT_PREFIX+"[")
+	if start < 0 {
+		return 0, false
+	}
+	return comment + start + GOT_PREFIX_LEN + 1, true
+}
+
+// attributePrefix returns the beginning of the attribute name ending the
+// text of a line, when it is in the attribute list of a comment.
+func attributePrefix(line string) (string, bool) {
+	start, ok := attributeList(line)
+	if !ok {
+		return "", false
+	}
+
+	prefix := ""
+	inArgs := false
+	for _, ch := range line[start:] {
+		switch {
+		case inArgs:
+			inArgs = ch != AttributeParamsEnd
+		case ch == AttributeParamsStart:
+			inArgs = true
+		case ch == AttributeListEnd:
+			return "", false
+		case ch == AttributeSeparator:
+			prefix = ""
+		case ch != Space:
+			prefix += string(ch)
+		}
+	}
+
+	if inArgs {
+		return "", false
+	}
+	return prefix, true
+}
+
+// attributeAt returns the name of the attribute at the offset of the text,
+// with the offsets of its start and end, when the offset is on the name of
+// an attribute in an attribute comment.
+func attributeAt(text string, offset int) (string, int, int, bool) {
+	lineStart := strings.LastIndex(text[:offset], "\n") + 1
+	lineEnd := offset + strings.IndexByte(text[offset:]+"\n", '\n')
+	line := text[lineStart:lineEnd]
+
+	listStart, ok := attributeList(line)
+	if !ok {
+		return "", 0, 0, false
+	}
+
+	nameStart := -1
+	inArgs := false
+	for i := listStart; i <= len(line); i++ {
+		ch := byte(AttributeListEnd)
+		if i < len(line) {
+			ch = line[i]
+		}
+
+		if inArgs {
+			inArgs = ch != AttributeParamsEnd
+			continue
+		}
+
+		isName := ch != Space && ch != AttributeParamsStart && ch != AttributeSeparator && ch != AttributeListEnd && ch != AttributeParamsEnd
+		if isName && nameStart < 0 {
+			nameStart = i
+		}
+		if !isName && nameStart >= 0 {
+			start, end := lineStart+nameStart, lineStart+i
+			if offset >= start && offset <= end {
+				return line[nameStart:i], start, end, true
+			}
+			nameStart = -1
+		}
+
+		switch ch {
+		case AttributeParamsStart:
+			inArgs = true
+		case AttributeListEnd:
+			return "", 0, 0, false
+		}
+	}
+
+	return "", 0, 0, false
+}
+
+// decoratorDoc returns the markdown documentation of a decorator: its doc
+// comment and its spec.
+func decoratorDoc(function ExtractedFunction) string {
+	lines := []string{}
+	if function.Doc != "" {
+		lines = append(lines, function.Doc)
+	}
+
+	if spec := function.Spec; spec != nil {
+		if spec.Description != "" {
+			lines = append(lines, spec.Description)
+		}
+
+		args := []string{}
+		for _, arg := range spec.Args {
+			args = append(args, arg.String())
+		}
+		if spec.Variadic {
+			args = append(args, "...")
+		}
+		usage := "`#[" + function.Name
+		if len(args) > 0 {
+			usage += "(" + strings.Join(args, ", ") + ")"
+		}
+		lines = append(lines, usage+"]`")
+
+		if len(spec.Targets) > 0 {
+			lines = append(lines, "Targets: "+strings.Join(spec.Targets, ", "))
+		}
+	}
+
+	return strings.Join(lines, "\n\n")
+}
@@ -0,0 +1,350 @@
+package transform
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lspTestClient is a client talking to a language server in process.
+type lspTestClient struct {
+	t      *testing.T
+	in     *io.PipeWriter
+	nextID int
+
+	// messages are the messages received from the server, read as soon as
+	// they are written, and notifications the notifications received
+	// before the last response.
+	messages      chan lspTestMessage
+	notifications []lspTestMessage
+	done          chan error
+}
+
+type lspTestMessage struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+	Result json.RawMessage  `json:"result"`
+	Error  *lspError        `json:"error"`
+}
+
+func newLSPTestClient(t *testing.T, opts ...Option) *lspTestClient {
+	return newLSPTestServerClient(t, NewLanguageServer(opts...))
+}
+
+func newLSPTestServerClient(t *testing.T, server *LanguageServer) *lspTestClient {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	c := &lspTestClient{t: t, in: clientOut, messages: make(chan lspTestMessage, 100), done: make(chan error, 1)}
+	go func() {
+		c.done <- server.Serve(context.Background(), serverIn, serverOut)
+		serverOut.Close()
+	}()
+
+	go func() {
+		defer close(c.messages)
+
+		out := bufio.NewReader(clientIn)
+		for {
+			body, err := readLSPMessage(out)
+			if err != nil {
+				return
+			}
+
+			message := lspTestMessage{}
+			if err := json.Unmarshal(body, &message); err != nil {
+				return
+			}
+			c.messages <- message
+		}
+	}()
+
+	c.call("initialize", map[string]interface{}{}, nil)
+	c.notify("initialized", map[string]interface{}{})
+	return c
+}
+
+// call sends a request and decodes its result, collecting the
+// notifications received before the response.
+func (c *lspTestClient) call(method string, params interface{}, result interface{}) *lspError {
+	c.nextID++
+	id := json.RawMessage(strconv.Itoa(c.nextID))
+	if err := writeLSPMessage(c.in, map[string]interface{}{"jsonrpc": "2.0", "id": &id, "method": method, "params": params}); err != nil {
+		c.t.Fatal(err)
+	}
+
+	for message := range c.messages {
+		if message.ID == nil {
+			c.notifications = append(c.notifications, message)
+			continue
+		}
+		if message.Error != nil {
+			return message.Error
+		}
+		if result != nil {
+			if err := json.Unmarshal(message.Result, result); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return nil
+	}
+
+	c.t.Fatal("The server stopped before responding")
+	return nil
+}
+
+// notify sends a notification.
+func (c *lspTestClient) notify(method string, params interface{}) {
+	if err := writeLSPMessage(c.in, map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// diagnostics returns the last diagnostics published for the URI. The
+// server handles the messages in order, so the diagnostics of the previous
+// notifications are received before the response to a request.
+func (c *lspTestClient) diagnostics(uri string) []lspDiagnostic {
+	c.call("shutdown", nil, nil)
+
+	diagnostics := []lspDiagnostic{}
+	for _, notification := range c.notifications {
+		params := lspPublishDiagnosticsParams{}
+		if err := json.Unmarshal(notification.Params, &params); err != nil {
+			c.t.Fatal(err)
+		}
+		if notification.Method == "textDocument/publishDiagnostics" && params.URI == uri {
+			diagnostics = params.Diagnostics
+		}
+	}
+	return diagnostics
+}
+
+// close sends the exit notification and waits for the server to stop.
+func (c *lspTestClient) close() {
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func writeLSPPackage(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	source := `package p
+
+// #[Log(info)]
+func A() {}
+
+// #[Log]
+
+func B() {}
+
+// #[Log(debug, true)]
+func C() {}
+
+// #[Broken(
+func D() {}
+`
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": source,
+		"b.go": `package p
+
+// Log logs the calls of a function.
+// #[decorator]
+// #[target(FuncDecl)]
+// #[arg(level, string, debug)]
+func Log(c *TransformContext) error { return nil }
+`,
+	})
+	return dir, source
+}
+
+func TestLanguageServerDiagnostics(t *testing.T) {
+	dir, source := writeLSPPackage(t)
+	uri := pathToURI(filepath.Join(dir, "a.go"))
+
+	c := newLSPTestClient(t)
+	defer c.close()
+
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": lspTextDocumentItem{URI: uri, LanguageID: "go", Version: 1, Text: source},
+	})
+
+	// The diagnostics cover the attribute comment, from the error for the
+	// attributes which can't be parsed.
+	messages := []string{}
+	for _, diagnostic := range c.diagnostics(uri) {
+		messages = append(messages, fmt.Sprintf("%d:%d %s", diagnostic.Range.Start.Line, diagnostic.Range.Start.Character, diagnostic.Message))
+		if diagnostic.Range.End.Line != diagnostic.Range.Start.Line || diagnostic.Range.End.Character <= diagnostic.Range.Start.Character {
+			t.Errorf("Expected the diagnostic to cover the rest of the line, got %v", diagnostic.Range)
+		}
+	}
+	expected := []string{
+		"5:0 attribute `Log` is separated from the FuncDecl at line 8 by blank lines",
+		"9:0 attribute `Log` expects at most 1 arguments, got 2",
+		"12:11 the arguments of attribute `Broken` are not closed",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %s, got %s", strings.Join(expected, "\n"), strings.Join(messages, "\n"))
+	}
+
+	// The diagnostics are updated with the content of the editor.
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   lspTextDocumentIdentifier{URI: uri},
+		"contentChanges": []map[string]string{{"text": "package p\n\n// #[Log(info)]\nfunc A() {}\n"}},
+	})
+	if diagnostics := c.diagnostics(uri); len(diagnostics) != 0 {
+		t.Errorf("Expected no diagnostics, got %v", diagnostics)
+	}
+}
+
+func TestLanguageServerNavigation(t *testing.T) {
+	dir, _ := writeLSPPackage(t)
+	uri := pathToURI(filepath.Join(dir, "a.go"))
+	position := lspTextDocumentPositionParams{
+		TextDocument: lspTextDocumentIdentifier{URI: uri},
+		Position:     lspPosition{Line: 2, Character: 6},
+	}
+
+	c := newLSPTestClient(t, WithDecorator("Lower", func(c *TransformContext) error { return nil }))
+	defer c.close()
+
+	items := []lspCompletionItem{}
+	if err := c.call("textDocument/completion", position, &items); err != nil {
+		t.Fatal(err)
+	}
+	labels := []string{}
+	for _, item := range items {
+		labels = append(labels, item.Label)
+	}
+	if strings.Join(labels, ",") != "Log,Lower" {
+		t.Errorf("Expected Log,Lower, got %s", strings.Join(labels, ","))
+	}
+	if items[0].Detail != "func Log(c *TransformContext) error" {
+		t.Errorf("Expected the signature of Log, got %s", items[0].Detail)
+	}
+
+	hover := lspHover{}
+	if err := c.call("textDocument/hover", position, &hover); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"```go\nfunc Log(c *TransformContext) error\n```",
+		"Log logs the calls of a function.",
+		"`#[Log(level string = \"debug\")]`",
+		"Targets: FuncDecl",
+	} {
+		if !strings.Contains(hover.Contents.Value, expected) {
+			t.Errorf("Expected %q, got %s", expected, hover.Contents.Value)
+		}
+	}
+	if hover.Range == nil || hover.Range.Start.Character != 5 || hover.Range.End.Character != 8 {
+		t.Errorf("Expected the range of the attribute name, got %v", hover.Range)
+	}
+
+	location := lspLocation{}
+	if err := c.call("textDocument/definition", position, &location); err != nil {
+		t.Fatal(err)
+	}
+	if location.URI != pathToURI(filepath.Join(dir, "b.go")) || location.Range.Start != (lspPosition{Line: 6, Character: 5}) {
+		t.Errorf("Expected the Log function, got %v", location)
+	}
+
+	// Outside of the attribute names, there is nothing to show.
+	var empty *lspHover
+	position.Position.Character = 9
+	if err := c.call("textDocument/hover", position, &empty); err != nil || empty != nil {
+		t.Errorf("Expected no hover, got %v, %v", empty, err)
+	}
+}
+
+func TestLanguageServerShowGenerated(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": "package p\n\n// #[Drop]\nfunc A() {}\n\nfunc B() {}\n",
+	})
+	path := filepath.Join(dir, "a.go")
+	uri := pathToURI(path)
+
+	// The source is generated by this test binary, running
+	// TestLanguageServerGenerateProcess.
+	t.Setenv("GOT_LSP_GENERATE", "1")
+	server := NewLanguageServer(explainTestOptions()...)
+	server.GenerateCommand = []string{os.Args[0], "-test.run=^TestLanguageServerGenerateProcess$", "--"}
+
+	c := newLSPTestServerClient(t, server)
+	defer c.close()
+
+	source := ""
+	params := map[string]interface{}{"command": GOT_SHOW_GENERATED_COMMAND, "arguments": []string{uri}}
+	if err := c.call("workspace/executeCommand", params, &source); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(source, "func A()") || !strings.Contains(source, "func B() {}") {
+		t.Errorf("Expected the generated source, got %s", source)
+	}
+
+	// The unsaved content of the open documents is transformed.
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": lspTextDocumentItem{URI: uri, LanguageID: "go", Version: 1, Text: "package p\n\nfunc E() {}\n\n// #[Drop]\nfunc C() {}\n"},
+	})
+	if err := c.call("workspace/executeCommand", params, &source); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(source, "func C()") || !strings.Contains(source, "func E() {}") {
+		t.Errorf("Expected the generated source of the open document, got %s", source)
+	}
+
+	// The errors of the command are reported.
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   lspTextDocumentIdentifier{URI: uri},
+		"contentChanges": []map[string]string{{"text": "package p\n\nfunc D( {}\n"}},
+	})
+	expected := "Failed to generate the source of `" + path + "`: "
+	if err := c.call("workspace/executeCommand", params, nil); err == nil || !strings.HasPrefix(err.Message, expected) || !strings.Contains(err.Message, "a.go:3:") {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+
+	params["command"] = "unknown"
+	if err := c.call("workspace/executeCommand", params, nil); err == nil || err.Code != lspInvalidParams {
+		t.Errorf("Expected an invalid params error, got %v", err)
+	}
+	if err := c.call("textDocument/unknown", nil, nil); err == nil || err.Code != lspMethodNotFound {
+		t.Errorf("Expected a method not found error, got %v", err)
+	}
+}
+
+// TestLanguageServerGenerateProcess is the generate command of
+// TestLanguageServerShowGenerated, printing the generated source of the
+// file of its last argument.
+func TestLanguageServerGenerateProcess(t *testing.T) {
+	if os.Getenv("GOT_LSP_GENERATE") != "1" {
+		t.Skip("run by TestLanguageServerShowGenerated")
+	}
+
+	documents := map[string]string{}
+	if err := json.NewDecoder(os.Stdin).Decode(&documents); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	sources := map[string][]byte{}
+	for path, text := range documents {
+		sources[path] = []byte(text)
+	}
+
+	opts := append(explainTestOptions(), WithSources(sources))
+	src, err := GeneratedSource(context.Background(), os.Args[len(os.Args)-1], opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(src)
+	os.Exit(0)
+}
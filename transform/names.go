@@ -0,0 +1,249 @@
+package transform
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// declareNames records the names of the package scope declared by a file:
+// its declarations and the names of its imports. The packages imported
+// with a dot are recorded, so their names are loaded when a fresh name is
+// needed.
+func (t *Transformer) declareNames(file *ast.File) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, name := range declNames(file.Decls) {
+		t.names[name] = true
+	}
+
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case spec.Name == nil:
+			for _, name := range importNames(importPath) {
+				t.names[name] = true
+			}
+		case spec.Name.Name == ".":
+			if _, ok := t.dotImports[importPath]; !ok {
+				t.dotImports[importPath] = false
+			}
+		default:
+			t.names[spec.Name.Name] = true
+		}
+	}
+}
+
+// declNames returns the names declared by the declarations of a file, the
+// imports apart.
+func declNames(decls []ast.Decl) []string {
+	names := []string{}
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			names = append(names, d.Name.Name)
+
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, s.Name.Name)
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						names = append(names, name.Name)
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// reserveFreshNames records the fresh names of the file declared at the
+// package scope, so they are not returned to the files after it, and
+// marks the file as transformed.
+func (t *Transformer) reserveFreshNames(f *fileTransform) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, name := range declNames(f.file.Decls) {
+		if f.fresh[name] {
+			t.names[name] = true
+		}
+	}
+	close(f.done)
+}
+
+// declarePackageNames records the names declared by the go files of the
+// base directory which are not transformed, such as the test files when
+// tests are excluded or the files excluded by build constraints, since the
+// generated files are compiled along with them. The files which can't be
+// parsed are ignored.
+func (t *Transformer) declarePackageNames(paths []string) error {
+	entries, err := os.ReadDir(t.baseDir)
+	if err != nil {
+		return fmt.Errorf("Failed to lookup files in `%s`: %v", t.baseDir, err)
+	}
+
+	transformed := map[string]bool{}
+	for _, path := range paths {
+		transformed[path] = true
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(t.baseDir, entry.Name())
+		if entry.IsDir() || filepath.Ext(path) != GO_FILE_EXTENSION || isGeneratedFile(path) || transformed[path] {
+			continue
+		}
+
+		src, err := t.readSource(path)
+		if err != nil {
+			return fmt.Errorf("Failed to read file: %v", err)
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), path, src, parser.SkipObjectResolution)
+		if err != nil {
+			t.log("Failed to load the names of", path+":", err)
+			continue
+		}
+		t.declareNames(file)
+	}
+
+	return nil
+}
+
+// loadDotImports records the exported names of the packages imported with
+// a dot, using their type information. The packages which can't be loaded
+// are ignored. It must be called with the lock held.
+func (t *Transformer) loadDotImports() {
+	var imp types.ImporterFrom
+	for importPath, loaded := range t.dotImports {
+		if loaded {
+			continue
+		}
+		t.dotImports[importPath] = true
+
+		if imp == nil {
+			imp = importer.ForCompiler(token.NewFileSet(), "source", nil).(types.ImporterFrom)
+		}
+		pkg, err := imp.ImportFrom(importPath, t.baseDir, 0)
+		if err != nil {
+			t.log("Failed to load the names of", importPath+":", err)
+			continue
+		}
+
+		for _, name := range pkg.Scope().Names() {
+			t.names[name] = true
+		}
+	}
+}
+
+// freshName returns a name based on the hint, which is not a name of the
+// package scope, of the local names, or a name returned before to the
+// file. The files before it are transformed first, so that the names they
+// declare at the package scope are known.
+func (t *Transformer) freshName(f *fileTransform, hint string, local map[string]bool) string {
+	for _, earlier := range f.earlier {
+		<-earlier.done
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.loadDotImports()
+
+	base := identifierHint(hint)
+	name := base
+	for i := 1; t.names[name] || f.fresh[name] || local[name] || !isFreeName(name); i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	f.fresh[name] = true
+
+	return name
+}
+
+// FreshName returns a new identifier based on the hint, for the helper
+// declarations and the temporary variables introduced by decorators.
+// The identifier is unique in the package scope, including the names
+// declared by the files which are not transformed, such as the test files,
+// and the names imported with a dot, and in the declaration the attribute
+// applies to, so it can be declared in the body of a function without
+// shadowing any name it uses. Each call returns a different identifier in
+// a file.
+// The identifiers a file declares at the package scope are reserved for
+// the files after it, in the order of the package, so the first call
+// waits for the files before it to be transformed: the identifiers don't
+// depend on the order the files are transformed in.
+func (t *TransformContext) FreshName(hint string) *ast.Ident {
+	local := map[string]bool{}
+
+	nodes := t.Nodes()
+	if decl := t.file.layout.enclosingDecl(t.Cursor.Node()); decl != nil {
+		nodes = append(nodes, decl)
+	}
+	for _, node := range nodes {
+		ast.Inspect(node, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				local[ident.Name] = true
+			}
+			return true
+		})
+	}
+
+	return ast.NewIdent(t.file.transformer.freshName(t.file, hint, local))
+}
+
+// identifierHint turns a hint into a valid identifier.
+func identifierHint(hint string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, hint)
+
+	if name == "" {
+		return "tmp"
+	}
+	if unicode.IsDigit([]rune(name)[0]) {
+		name = "_" + name
+	}
+	return name
+}
+
+// isFreeName reports whether the name can be declared without hiding a
+// predeclared identifier, and is not a keyword or the blank identifier.
+func isFreeName(name string) bool {
+	return name != "_" && !token.IsKeyword(name) && types.Universe.Lookup(name) == nil
+}
+
+// importNames returns the possible names of an imported package, which is
+// usually the last element of its path without its major version, such as
+// `yaml` for `gopkg.in/yaml.v3`. Since the name is only known once the
+// package is loaded, all the identifiers of the element are returned.
+func importNames(importPath string) []string {
+	name := path.Base(importPath)
+	if strings.HasPrefix(name, "v") && name != importPath {
+		if _, err := strconv.Atoi(name[1:]); err == nil {
+			name = path.Base(path.Dir(importPath))
+		}
+	}
+
+	names := strings.FieldsFunc(name, func(r rune) bool {
+		return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return append(names, identifierHint(name))
+}
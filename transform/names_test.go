@@ -0,0 +1,167 @@
+package transform
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestIdentifierHint(t *testing.T) {
+	cases := map[string]string{
+		"":          "tmp",
+		"result":    "result",
+		"my-var":    "my_var",
+		"2fast":     "_2fast",
+		"Pokémon":   "Pokémon",
+		"a.b":       "a_b",
+		"__private": "__private",
+	}
+
+	for hint, expected := range cases {
+		if result := identifierHint(hint); result != expected {
+			t.Errorf("Expected %s, got %s", expected, result)
+		}
+	}
+}
+
+func TestImportNames(t *testing.T) {
+	cases := map[string]string{
+		"fmt":                        "fmt,fmt",
+		"go/ast":                     "ast,ast",
+		"github.com/foo/bar/v2":      "bar,bar",
+		"github.com/foo/go-yaml":     "go,yaml,go_yaml",
+		"gopkg.in/yaml.v3":           "yaml,v3,yaml_v3",
+		"github.com/foo/version/v2x": "v2x,v2x",
+	}
+
+	for importPath, expected := range cases {
+		if result := strings.Join(importNames(importPath), ","); result != expected {
+			t.Errorf("Expected %s, got %s", expected, result)
+		}
+	}
+}
+
+func TestFreshName(t *testing.T) {
+	src := `package main
+
+import (
+	"fmt"
+	str "strings"
+)
+
+var result1 = 1
+
+type tmp struct{}
+
+// #[Fresh]
+func Hello(result string) {
+	x := str.ToUpper(result)
+	fmt.Println(x)
+}
+`
+
+	var names []string
+	transformTestSource(t, map[string]ExtractedDecorator{
+		"Fresh": func(c *TransformContext) error {
+			for _, hint := range []string{"result", "x", "x", "fmt", "str", "len", "type", "", "tmp"} {
+				names = append(names, c.FreshName(hint).Name)
+			}
+			return nil
+		},
+	}, src)
+
+	expected := "result2,x1,x2,fmt1,str1,len1,type1,tmp1,tmp2"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(names, ","))
+	}
+}
+
+func TestFreshNameDotImport(t *testing.T) {
+	src := "package main\n\nimport . \"strings\"\n\n// #[Fresh]\nvar s = ToUpper(\"a\")\n"
+
+	var name string
+	transformTestSource(t, map[string]ExtractedDecorator{
+		"Fresh": func(c *TransformContext) error {
+			name = c.FreshName("Join").Name
+			return nil
+		},
+	}, src)
+
+	if name != "Join1" {
+		t.Errorf("Expected Join1, got %s", name)
+	}
+}
+
+func TestFreshNamePackage(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": "package p\n\n// #[Fresh]\nfunc A() {}\n",
+		"b.go": "package p\n\n// #[Fresh]\nfunc B() {}\n",
+		"c.go": "package p\n\nfunc helper() {}\n",
+	})
+
+	// The helper is declared at the package scope, and the temporary name
+	// is only used by the file.
+	fresh := func(c *TransformContext) error {
+		helper, tmp := c.FreshName("helper"), c.FreshName("tmp")
+		decl, err := c.Decl("var $helper = 1", helper)
+		if err != nil {
+			return err
+		}
+		c.InsertAfter(decl)
+		c.Reportf("%s %s", helper.Name, tmp.Name)
+		return nil
+	}
+
+	// The names don't depend on the order the files are transformed in.
+	for i := 0; i < 10; i++ {
+		result, err := NewTransformer(dir,
+			WithBuildDir(t.TempDir()),
+			WithDecorator("Fresh", fresh),
+			WithParallelism(2),
+		).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := []string{}
+		for _, file := range result.Files {
+			for _, d := range file.Diagnostics {
+				names = append(names, d.Message)
+			}
+		}
+
+		if strings.Join(names, ",") != "helper1 tmp,helper2 tmp" {
+			t.Fatalf("Expected helper1 tmp,helper2 tmp, got %s", strings.Join(names, ","))
+		}
+	}
+}
+
+func TestFreshNameExcludedFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go":      "package p\n\n// #[Fresh]\nfunc A() {}\n",
+		"a_test.go": "package p\n\nfunc helper() {}\n",
+		"other.go":  "//go:build other\n\npackage p\n\nvar helper1 = 1\n",
+	})
+
+	fresh := func(c *TransformContext) error {
+		c.Reportf("%s", c.FreshName("helper").Name)
+		return nil
+	}
+
+	result, err := NewTransformer(dir,
+		WithBuildDir(t.TempDir()),
+		WithDecorator("Fresh", fresh),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Files) != 1 || len(result.Files[0].Diagnostics) != 1 {
+		t.Fatalf("Expected one diagnostic, got %v", result.Files)
+	}
+	if name := result.Files[0].Diagnostics[0].Message; name != "helper2" {
+		t.Errorf("Expected helper2, got %s", name)
+	}
+}
@@ -0,0 +1,120 @@
+package transform
+
+import (
+	"go/ast"
+	"reflect"
+)
+
+// Parent returns the node enclosing the node the attribute applies to, such
+// as the *ast.BlockStmt of a statement or the *ast.File of a declaration.
+func (t *TransformContext) Parent() ast.Node {
+	if len(t.ancestors) == 0 {
+		return nil
+	}
+	return t.ancestors[len(t.ancestors)-1]
+}
+
+// Ancestors returns the nodes enclosing the node the attribute applies to,
+// from its parent to the *ast.File.
+func (t *TransformContext) Ancestors() []ast.Node {
+	ancestors := make([]ast.Node, 0, len(t.ancestors))
+	for i := len(t.ancestors) - 1; i >= 0; i-- {
+		ancestors = append(ancestors, t.ancestors[i])
+	}
+	return ancestors
+}
+
+// Path returns the node the attribute was bound to, followed by its
+// ancestors up to the *ast.File, like astutil.PathEnclosingInterval.
+func (t *TransformContext) Path() []ast.Node {
+	return append([]ast.Node{t.Cursor.Node()}, t.Ancestors()...)
+}
+
+// EnclosingFunc returns the innermost function enclosing the node the
+// attribute applies to, an *ast.FuncDecl or an *ast.FuncLit, or nil if the
+// node is not inside a function.
+func (t *TransformContext) EnclosingFunc() ast.Node {
+	for i := len(t.ancestors) - 1; i >= 0; i-- {
+		switch node := t.ancestors[i].(type) {
+		case *ast.FuncDecl, *ast.FuncLit:
+			return node
+		}
+	}
+	return nil
+}
+
+// EnclosingType returns the innermost type declaration enclosing the node
+// the attribute applies to. Inside a method, it is the declaration of the
+// receiver type, when it is declared in the same file. It returns nil
+// otherwise.
+func (t *TransformContext) EnclosingType() *ast.TypeSpec {
+	for i := len(t.ancestors) - 1; i >= 0; i-- {
+		switch node := t.ancestors[i].(type) {
+		case *ast.TypeSpec:
+			return node
+		case *ast.FuncDecl:
+			return t.receiverType(node)
+		}
+	}
+	return nil
+}
+
+// receiverType returns the declaration of the receiver type of a method,
+// if it is declared in the file.
+func (t *TransformContext) receiverType(fn *ast.FuncDecl) *ast.TypeSpec {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return nil
+	}
+
+	expr := fn.Recv.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch index := expr.(type) {
+	case *ast.IndexExpr:
+		expr = index.X
+	case *ast.IndexListExpr:
+		expr = index.X
+	}
+	name, ok := expr.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+
+	for _, decl := range t.File.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			if spec, ok := spec.(*ast.TypeSpec); ok && spec.Name.Name == name.Name {
+				return spec
+			}
+		}
+	}
+	return nil
+}
+
+// Siblings returns the nodes of the list the node the attribute applies to
+// is part of, including it at the index of the cursor, such as the
+// statements of its block. It returns nil when the node is not part of a
+// list.
+func (t *TransformContext) Siblings() []ast.Node {
+	if t.Cursor.Index() < 0 {
+		return nil
+	}
+
+	parent := reflect.ValueOf(t.Cursor.Parent()).Elem()
+	list := parent.FieldByName(t.Cursor.Name())
+	if list.Kind() != reflect.Slice {
+		return nil
+	}
+
+	siblings := make([]ast.Node, 0, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		if node, ok := list.Index(i).Interface().(ast.Node); ok {
+			siblings = append(siblings, node)
+		}
+	}
+	return siblings
+}
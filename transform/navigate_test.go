@@ -0,0 +1,91 @@
+package transform
+
+import (
+	"go/ast"
+	"strings"
+	"testing"
+)
+
+func TestNavigation(t *testing.T) {
+	src := `package main
+
+type Config struct {
+	Handler func() int
+}
+
+func Run(c *Config) {
+	c.Handler = func() int {
+		x := 1
+		// #[Nav]
+		if x > 0 {
+			x++
+		}
+		return x
+	}
+}
+`
+
+	var result []string
+	transformTestSource(t, map[string]ExtractedDecorator{"Nav": func(c *TransformContext) error {
+		kinds := func(nodes []ast.Node) string {
+			names := []string{}
+			for _, node := range nodes {
+				names = append(names, nodeKind(node))
+			}
+			return strings.Join(names, ",")
+		}
+
+		result = append(result,
+			nodeKind(c.Parent()),
+			kinds(c.Ancestors()),
+			kinds(c.Path()),
+			nodeKind(c.EnclosingFunc()),
+			kinds(c.Siblings()),
+		)
+		return nil
+	}}, src)
+
+	expected := []string{
+		"BlockStmt",
+		"BlockStmt,FuncLit,AssignStmt,BlockStmt,FuncDecl,File",
+		"IfStmt,BlockStmt,FuncLit,AssignStmt,BlockStmt,FuncDecl,File",
+		"FuncLit",
+		"AssignStmt,IfStmt,ReturnStmt",
+	}
+	if strings.Join(result, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected %s, got %s", strings.Join(expected, "|"), strings.Join(result, "|"))
+	}
+}
+
+func TestEnclosingType(t *testing.T) {
+	src := `package main
+
+type List[T any] struct{ items []T }
+
+func (l *List[T]) Len() int {
+	// #[Nav]
+	n := len(l.items)
+	return n
+}
+
+func Len() int {
+	// #[Nav]
+	n := 0
+	return n
+}
+`
+
+	names := []string{}
+	transformTestSource(t, map[string]ExtractedDecorator{"Nav": func(c *TransformContext) error {
+		name := "nil"
+		if spec := c.EnclosingType(); spec != nil {
+			name = spec.Name.Name
+		}
+		names = append(names, name)
+		return nil
+	}}, src)
+
+	if strings.Join(names, ",") != "List,nil" {
+		t.Errorf("Expected List,nil, got %s", strings.Join(names, ","))
+	}
+}
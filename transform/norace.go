@@ -0,0 +1,7 @@
+//go:build !race
+
+package transform
+
+// raceEnabled reports whether got is built with the race detector, in
+// which case the plugins must be built with it too.
+const raceEnabled = false
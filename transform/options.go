@@ -0,0 +1,174 @@
+package transform
+
+import (
+	"go/build"
+	"time"
+)
+
+// Option configures a Transformer.
+type Option func(t *Transformer)
+
+// Logger receives the log messages of a Transformer.
+// It is satisfied by *log.Logger.
+type Logger interface {
+	Println(v ...interface{})
+}
+
+// WithTests sets whether the `_test.go` files are transformed as well.
+func WithTests(include bool) Option {
+	return func(t *Transformer) {
+		t.includeTests = include
+	}
+}
+
+// WithBuildContext sets the build context used to select the files of the
+// package. The "generated" tag is ignored, since it is the tag selecting
+// the transformed files.
+func WithBuildContext(ctxt build.Context) Option {
+	return func(t *Transformer) {
+		ctxt.BuildTags = withoutGeneratedTag(ctxt.BuildTags)
+		t.buildContext = ctxt
+	}
+}
+
+// WithTags sets the build tags used to select the files of the package,
+// replacing the tags of the build context.
+func WithTags(tags ...string) Option {
+	return func(t *Transformer) {
+		t.buildContext.BuildTags = withoutGeneratedTag(tags)
+	}
+}
+
+// WithConstraints sets the output mode, that is how the source files are
+// kept from being compiled with their generated files.
+// The default mode is ConstraintsAuto.
+func WithConstraints(mode ConstraintMode) Option {
+	return func(t *Transformer) {
+		t.constraintMode = mode
+	}
+}
+
+// WithLogger sets the logger receiving the transformer log messages.
+// Files are transformed in parallel, so the logger must be safe for
+// concurrent use. Without a logger, nothing is logged.
+func WithLogger(logger Logger) Option {
+	return func(t *Transformer) {
+		t.logger = logger
+	}
+}
+
+// WithVersion sets the version of got written in the generated code comment
+// of the generated files.
+func WithVersion(version string) Option {
+	return func(t *Transformer) {
+		t.version = version
+	}
+}
+
+// WithBuildDir sets the directory where the extracted functions, their
+// plugins and the overlay files are saved. The default is GOT_BUILD_DIR,
+// relative to the working directory.
+func WithBuildDir(dir string) Option {
+	return func(t *Transformer) {
+		t.buildDir = dir
+	}
+}
+
+// WithFileFilter sets a filter selecting the files to transform, among the
+// files of the package matching the build context.
+func WithFileFilter(filter func(path string) bool) Option {
+	return func(t *Transformer) {
+		t.fileFilter = filter
+	}
+}
+
+// WithSources sets the content of files of the package, by path, read
+// instead of their content on disk, like the unsaved documents of an
+// editor.
+func WithSources(sources map[string][]byte) Option {
+	return func(t *Transformer) {
+		t.sources = sources
+	}
+}
+
+// WithInMemory sets whether the package is transformed in memory: the
+// generated sources are returned in the result of Run instead of being
+// written, and no source file is modified. Like with TransformSource, the
+// functions marked with the `decorator`, `method` and `finalize` attributes
+// are not extracted, so they must be registered with options.
+func WithInMemory(inMemory bool) Option {
+	return func(t *Transformer) {
+		t.inMemory = inMemory
+	}
+}
+
+// WithParallelism sets the maximum number of files transformed in
+// parallel. The default is GOMAXPROCS, like the go command -p flag.
+func WithParallelism(n int) Option {
+	return func(t *Transformer) {
+		if n < 1 {
+			n = 1
+		}
+		t.parallelism = n
+	}
+}
+
+// WithDecorator registers a decorator, so it is available without being
+// extracted from the sources.
+func WithDecorator(name string, fn ExtractedDecorator) Option {
+	return func(t *Transformer) {
+		t.decorators[name] = fn
+	}
+}
+
+// WithDecoratorSpec sets the spec of a decorator, validating its usages
+// like the spec declared by the attributes of an extracted decorator.
+func WithDecoratorSpec(name string, spec DecoratorSpec) Option {
+	return func(t *Transformer) {
+		t.specs[name] = spec
+	}
+}
+
+// WithMethod registers a method, so it is available without being
+// extracted from the sources.
+func WithMethod(name string, fn ExtractedMethod) Option {
+	return func(t *Transformer) {
+		t.methods[name] = fn
+	}
+}
+
+// WithFinalizer registers a finalizer, so it is available without being
+// extracted from the sources.
+func WithFinalizer(name string, fn ExtractedFinalizer) Option {
+	return func(t *Transformer) {
+		t.finalizers[name] = fn
+	}
+}
+
+// withoutGeneratedTag returns the tags except for the "generated" tag.
+func withoutGeneratedTag(tags []string) []string {
+	result := []string{}
+	for _, tag := range tags {
+		if !hasGeneratedTag(tag) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// WithTimeout sets the time a decorator or a finalizer call may take before
+// the transformation is aborted. The default is GOT_DEFAULT_TIMEOUT, and
+// zero disables the timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(t *Transformer) {
+		t.timeout = timeout
+	}
+}
+
+// WithDecoratorTimeout sets the timeout of the decorator or finalizer with
+// the given name, overriding the timeout set with WithTimeout.
+func WithDecoratorTimeout(name string, timeout time.Duration) Option {
+	return func(t *Transformer) {
+		t.timeouts[name] = timeout
+	}
+}
@@ -1,6 +1,8 @@
 package transform
 
 import (
+	"fmt"
+	"go/token"
 	"io"
 )
 
@@ -34,6 +36,21 @@ type InstructionParser struct {
 	src        io.Reader
 	parseTypes uint
 	result     []Instruction
+
+	// offset is the number of bytes read from the source.
+	offset int
+	buf    []byte
+}
+
+// ParseError is an error in the syntax of an attribute list, at an offset
+// of the parsed source.
+type ParseError struct {
+	Offset  int
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return e.Message
 }
 
 // NewInstructionParser creates a new instruction parser.
@@ -42,15 +59,19 @@ func NewInstructionParser(src io.Reader, parseTypes uint) *InstructionParser {
 		src:        src,
 		parseTypes: parseTypes,
 		result:     []Instruction{},
+		buf:        make([]byte, 1),
 	}
 }
 
 // Parse trys to parse the instructions from the source.
+// An attribute list is a list of names separated by commas, each name
+// being a Go identifier optionally followed by its arguments between
+// parentheses, like `#[Name(arg, arg), Other]`. A *ParseError is returned
+// for the first attribute list with another syntax, along with the
+// instructions parsed before it.
 func (p *InstructionParser) Parse() ([]Instruction, error) {
-	buf := make([]byte, 1)
-
 	for {
-		_, err := p.src.Read(buf)
+		ch, err := p.read()
 		if err == io.EOF {
 			break
 		}
@@ -59,12 +80,14 @@ func (p *InstructionParser) Parse() ([]Instruction, error) {
 			return nil, err
 		}
 
-		switch string(buf)[0] {
+		switch ch {
 		case AttributeListStart:
 			if p.parseTypes&AttributeInstructionType == 0 {
 				continue
 			}
-			p.parseAttributesList()
+			if err := p.parseAttributesList(); err != nil {
+				return p.result, err
+			}
 		default:
 		}
 
@@ -84,78 +107,122 @@ const (
 	AttributeParamsEnd   = '\u0029' // )
 )
 
+// read reads the next byte of the source.
+func (p *InstructionParser) read() (byte, error) {
+	for {
+		n, err := p.src.Read(p.buf)
+		if n == 1 {
+			p.offset++
+			return p.buf[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// errorf returns a *ParseError at the offset.
+func (p *InstructionParser) errorf(offset int, format string, args ...interface{}) error {
+	return &ParseError{Offset: offset, Message: fmt.Sprintf(format, args...)}
+}
+
 // parseAttributesList trys to parse the attributes list from the source.
-func (p *InstructionParser) parseAttributesList() {
-	buf := make([]byte, 1)
-	var name string
-	var args []string
+// The errors of the lists and arguments not closed are at their opening
+// bracket.
+func (p *InstructionParser) parseAttributesList() error {
+	start := p.offset - 1
+	var name []byte
+	args := []string{}
+	hasArgs := false
 
 	for {
-		_, err := p.src.Read(buf)
+		ch, err := p.read()
+		if err == io.EOF {
+			return p.errorf(start, "the attribute list is not closed")
+		}
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return
+			return err
 		}
 
-		switch buf[0] {
-		case AttributeParamsEnd, Space:
+		switch ch {
+		case Space:
 			continue
 
 		case AttributeParamsStart:
-			args = p.parseAttributesParams()
+			if len(name) == 0 || hasArgs {
+				return p.errorf(p.offset-1, "unexpected `(` in the attribute list")
+			}
+			args, err = p.parseAttributesParams(string(name))
+			if err != nil {
+				return err
+			}
+			hasArgs = true
 			continue
 
 		case AttributeSeparator, AttributeListEnd:
+			if len(name) == 0 {
+				return p.errorf(p.offset-1, "missing attribute name in the attribute list")
+			}
+			if !token.IsIdentifier(string(name)) {
+				return p.errorf(p.offset-1-len(name), "invalid attribute name `%s`", name)
+			}
+
 			var builtin bool = false
-			if _, ok := BuiltinAttributes[name]; ok {
+			if _, ok := BuiltinAttributes[string(name)]; ok {
 				builtin = true
 			}
 
 			p.result = append(p.result, AttributeInstruction{
-				Name:      name,
+				Name:      string(name),
 				Arguments: args,
 				IsBuiltin: builtin,
 			})
 
+			if ch == AttributeListEnd {
+				return nil
+			}
+
 			args = []string{}
-			name = ""
+			name = nil
+			hasArgs = false
 			continue
 		}
-		name += string(buf[0])
+
+		if hasArgs {
+			return p.errorf(p.offset-1, "unexpected `%c` after the arguments of attribute `%s`", ch, name)
+		}
+		name = append(name, ch)
 	}
 }
 
-// parseAttributesParams trys to parse the attributes params from the source.
-func (p *InstructionParser) parseAttributesParams() []string {
-	buf := make([]byte, 1)
+// parseAttributesParams trys to parse the params of the attribute with the
+// name from the source.
+func (p *InstructionParser) parseAttributesParams(name string) ([]string, error) {
+	start := p.offset - 1
 	var args []string
-	var arg string
+	var arg []byte
 
 	for {
-		_, err := p.src.Read(buf)
+		ch, err := p.read()
+		if err == io.EOF {
+			return nil, p.errorf(start, "the arguments of attribute `%s` are not closed", name)
+		}
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil
+			return nil, err
 		}
 
-		switch buf[0] {
+		switch ch {
 
 		case AttributeParamsEnd:
-			args = append(args, arg)
-			return args
+			args = append(args, string(arg))
+			return args, nil
 
 		case AttributeSeparator:
-			args = append(args, arg)
-			arg = ""
+			args = append(args, string(arg))
+			arg = nil
 			continue
 
 		}
-		arg += string(buf[0])
+		arg = append(arg, ch)
 	}
-
-	return args
 }
@@ -109,3 +109,32 @@ func TestParse(t *testing.T) {
 	)
 
 }
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]ParseError{
+		"#[A":                {1, "the attribute list is not closed"},
+		"#[A(x]":             {3, "the arguments of attribute `A` are not closed"},
+		"#[A, ]":             {5, "missing attribute name in the attribute list"},
+		"#[A-B]":             {2, "invalid attribute name `A-B`"},
+		"#[A(x)y]":           {6, "unexpected `y` after the arguments of attribute `A`"},
+		"#[(x)]":             {2, "unexpected `(` in the attribute list"},
+		"#[A] and #[B(]":     {12, "the arguments of attribute `B` are not closed"},
+		"#[A(x), B(y, z)]":   {},
+		"# not an attribute": {},
+	}
+
+	for input, expected := range tests {
+		_, err := NewInstructionParser(bytes.NewBufferString(input), AllInstructions).Parse()
+		if expected.Message == "" {
+			if err != nil {
+				t.Errorf("Expected no error for `%s`, got %v", input, err)
+			}
+			continue
+		}
+
+		parseErr, ok := err.(*ParseError)
+		if !ok || *parseErr != expected {
+			t.Errorf("Expected %v for `%s`, got %v", expected, input, err)
+		}
+	}
+}
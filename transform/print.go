@@ -0,0 +1,661 @@
+package transform
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"sort"
+	"strings"
+)
+
+// fileLayout keeps the original source of a parsed file, so the declarations
+// untouched by the transformations are printed exactly as they were
+// written, comments included, and the comments of the transformed
+// declarations can't drift into the code inserted around them.
+type fileLayout struct {
+	fset *token.FileSet
+	file *ast.File
+	src  []byte
+
+	// header is the end offset of the package clause.
+	header int
+
+	// trailer is the end offset of the last declaration.
+	trailer int
+
+	// decls are the original declarations, in the order of the source.
+	decls    []ast.Decl
+	regions  map[ast.Decl]declRegion
+	touched  map[ast.Decl]bool
+	replaced map[ast.Decl]ast.Decl
+
+	// origins are the original declarations the new declarations were
+	// derived from, by replacing them or being inserted around them.
+	origins map[ast.Decl]ast.Decl
+
+	// comments are the comment groups of the original source.
+	comments map[*ast.CommentGroup]bool
+
+	// edited is the original source with the cleanup edits applied, which
+	// is copied when printing.
+	edited *editedSource
+}
+
+// declRegion is the source of a declaration: from the end of the
+// previous declaration, including the comments before it, to its end.
+type declRegion struct {
+	lead  int
+	start int
+	end   int
+	doc   *ast.CommentGroup
+}
+
+// newFileLayout creates a layout of the original file source.
+func newFileLayout(fset *token.FileSet, file *ast.File, src []byte) *fileLayout {
+	l := &fileLayout{
+		fset:     fset,
+		file:     file,
+		src:      src,
+		header:   fset.Position(file.Name.End()).Offset,
+		regions:  map[ast.Decl]declRegion{},
+		touched:  map[ast.Decl]bool{},
+		replaced: map[ast.Decl]ast.Decl{},
+		origins:  map[ast.Decl]ast.Decl{},
+		comments: map[*ast.CommentGroup]bool{},
+	}
+
+	for _, group := range file.Comments {
+		l.comments[group] = true
+	}
+
+	l.trailer = l.header
+	l.decls = append(l.decls, file.Decls...)
+	for _, decl := range file.Decls {
+		l.regions[decl] = declRegion{
+			lead:  l.trailer,
+			start: l.offset(decl.Pos()),
+			end:   l.offset(decl.End()),
+			doc:   declDoc(decl),
+		}
+		l.trailer = l.offset(decl.End())
+	}
+
+	return l
+}
+
+// offset returns the source offset of the position.
+func (l *fileLayout) offset(pos token.Pos) int {
+	return l.fset.Position(pos).Offset
+}
+
+// enclosingDecl returns the top-level declaration of the file containing
+// the node, or nil if the node is not part of the original source.
+func (l *fileLayout) enclosingDecl(node ast.Node) ast.Decl {
+	if node == nil || !node.Pos().IsValid() {
+		return nil
+	}
+
+	offset := l.offset(node.Pos())
+	i := sort.Search(len(l.decls), func(i int) bool {
+		return l.regions[l.decls[i]].end > offset
+	})
+	if i < len(l.decls) && l.regions[l.decls[i]].start <= offset {
+		return l.decls[i]
+	}
+
+	return nil
+}
+
+// touch marks the declaration as transformed.
+func (l *fileLayout) touch(decl ast.Decl) {
+	if decl != nil {
+		l.touched[decl] = true
+	}
+}
+
+// replace records the replacement of a top-level declaration, so the new
+// declaration takes the place and the comments of the original one.
+func (l *fileLayout) replace(oldNode, newNode ast.Node) {
+	oldDecl, ok := oldNode.(ast.Decl)
+	if !ok {
+		return
+	}
+	if original, ok := l.replaced[oldDecl]; ok {
+		oldDecl = original
+	}
+
+	newDecl, ok := newNode.(ast.Decl)
+	if !ok {
+		return
+	}
+
+	if newDecl == oldDecl {
+		l.touch(oldDecl)
+		return
+	}
+
+	if _, ok := l.regions[oldDecl]; ok {
+		l.replaced[newDecl] = oldDecl
+	}
+}
+
+// derive records the declarations replacing a top-level declaration, or
+// inserted around it, as derived from its original declaration.
+func (l *fileLayout) derive(oldNode ast.Node, nodes []ast.Node) {
+	oldDecl, ok := oldNode.(ast.Decl)
+	if !ok {
+		return
+	}
+	origin := l.origin(oldDecl)
+
+	for _, node := range nodes {
+		if decl, ok := node.(ast.Decl); ok && decl != origin {
+			l.origins[decl] = origin
+		}
+	}
+}
+
+// origin returns the original declaration a declaration was derived from,
+// which is the declaration itself if it is not derived.
+func (l *fileLayout) origin(decl ast.Decl) ast.Decl {
+	if origin, ok := l.origins[decl]; ok {
+		return origin
+	}
+	return decl
+}
+
+// print prints the file, applying the edits to the original source.
+// The header and untouched declarations are copied from the original
+// source. The transformed declarations are printed with the comments in
+// their body, after the comments that preceded the original declaration.
+// New declarations are printed with their doc comment.
+// The comments removed by the edits are never printed.
+func (l *fileLayout) print(w io.Writer, edits []sourceEdit) error {
+	l.edited = newEditedSource(l.src, edits)
+
+	buf := bytes.NewBuffer([]byte{})
+	buf.Write(l.text(0, l.header))
+
+	for _, decl := range l.file.Decls {
+		if region, ok := l.regions[decl]; ok {
+			if !l.touched[decl] && declDoc(decl) == region.doc {
+				buf.Write(l.text(region.lead, region.end))
+				continue
+			}
+
+			l.printLead(buf, region, decl)
+			if err := l.printDecl(buf, decl, true); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if original, ok := l.replaced[decl]; ok {
+			l.printLead(buf, l.regions[original], decl)
+		} else {
+			buf.WriteString("\n\n")
+			printDoc(buf, declDoc(decl), "")
+		}
+
+		if err := l.printDecl(buf, decl, false); err != nil {
+			return err
+		}
+	}
+
+	buf.Write(l.text(l.trailer, len(l.src)))
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// printLead prints the source preceding the original declaration of the
+// region, followed by the doc comment of the declaration taking its place.
+// When the doc comment was replaced, only the directives of the original
+// doc comment are kept, after the new doc comment.
+func (l *fileLayout) printLead(w io.Writer, region declRegion, decl ast.Decl) {
+	doc := declDoc(decl)
+	if doc == region.doc {
+		_, _ = w.Write(l.text(region.lead, region.start))
+		return
+	}
+
+	if region.doc == nil {
+		_, _ = w.Write(l.text(region.lead, region.start))
+		printDoc(w, doc, "")
+		return
+	}
+
+	_, _ = w.Write(l.text(region.lead, l.offset(region.doc.Pos())))
+	printDoc(w, doc, "")
+	for _, comment := range region.doc.List {
+		if isDirective(comment.Text) {
+			_, _ = io.WriteString(w, comment.Text+"\n")
+		}
+	}
+}
+
+// printConfig is the configuration of gofmt, so the printed declarations
+// are formatted like the rest of the source.
+var printConfig = printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+
+// printDecl prints a declaration without its doc comment.
+// When withComments is true, the original comments inside the declaration
+// are printed as well.
+// Comments added to the declaration nodes by the transformations, such as
+// the doc comments of new fields, are placed on their nodes after printing.
+func (l *fileLayout) printDecl(w io.Writer, decl ast.Decl, withComments bool) error {
+	doc := declDoc(decl)
+	setDeclDoc(decl, nil)
+	defer setDeclDoc(decl, doc)
+
+	added, restore := detachComments(decl, l.comments)
+	defer restore()
+
+	var node interface{} = decl
+	if withComments {
+		// The comments of the file are sorted by position.
+		comments := []*ast.CommentGroup{}
+		i := sort.Search(len(l.file.Comments), func(i int) bool {
+			return l.file.Comments[i].Pos() >= decl.Pos()
+		})
+		for ; i < len(l.file.Comments) && l.file.Comments[i].End() <= decl.End(); i++ {
+			comments = append(comments, l.file.Comments[i])
+		}
+
+		restoreMarks := markRemovedComments(comments)
+		defer restoreMarks()
+		node = &printer.CommentedNode{Node: decl, Comments: comments}
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	if err := printConfig.Fprint(buf, l.fset, node); err != nil {
+		return err
+	}
+
+	_, err := w.Write(insertComments(removeMarkedComments(buf.Bytes()), added))
+	return err
+}
+
+// markRemovedComments replaces the text of the comments cleaned up from
+// the original source, such as the attribute comments, with a mark of the
+// same length, and returns a function restoring them.
+// The marked comments are removed after printing by removeMarkedComments,
+// so they don't leave blank lines in their place. The marks are made of
+// NUL bytes, which a go source can't contain.
+func markRemovedComments(groups []*ast.CommentGroup) func() {
+	marked := map[*ast.Comment]string{}
+	for _, group := range groups {
+		for _, comment := range group.List {
+			text := comment.Text
+			if _, ok := marked[comment]; ok || !isRemovedComment(comment) {
+				continue
+			}
+
+			if strings.HasPrefix(text, "/*") {
+				comment.Text = "/*" + strings.Repeat("\x00", len(text)-4) + "*/"
+			} else {
+				comment.Text = SINGLE_COMMENT + strings.Repeat("\x00", len(text)-COMMENT_PREFIX_LEN)
+			}
+			marked[comment] = text
+		}
+	}
+
+	return func() {
+		for comment, text := range marked {
+			comment.Text = text
+		}
+	}
+}
+
+// removeMarkedComments removes the comments marked by markRemovedComments
+// from a printed declaration.
+func removeMarkedComments(src []byte) []byte {
+	edits := []sourceEdit{}
+	for i := bytes.IndexByte(src, 0); i >= 0; {
+		start := i - COMMENT_PREFIX_LEN
+		end := i
+		for end < len(src) && src[end] == 0 {
+			end++
+		}
+		if src[start+1] == '*' {
+			end += 2
+		}
+		edits = append(edits, sourceEdit{start: start, end: end})
+
+		next := bytes.IndexByte(src[end:], 0)
+		if next < 0 {
+			break
+		}
+		i = end + next
+	}
+	if len(edits) == 0 {
+		return src
+	}
+
+	return applySourceEdits(src, edits)
+}
+
+// text returns the original source between two offsets, with the edits
+// applied.
+func (l *fileLayout) text(start, end int) []byte {
+	return l.edited.slice(start, end)
+}
+
+// editedSource is a source with edits applied, mapping the offsets of the
+// original source to the edited source.
+type editedSource struct {
+	src   []byte
+	edits []sourceEdit
+
+	// shifts are the differences between the edited and the original
+	// offsets before each edit.
+	shifts []int
+}
+
+// newEditedSource applies the edits, sorted by offset, to the source.
+func newEditedSource(src []byte, edits []sourceEdit) *editedSource {
+	e := &editedSource{edits: expandEdits(src, edits)}
+
+	result := bytes.NewBuffer([]byte{})
+	last, shift := 0, 0
+	for _, edit := range e.edits {
+		e.shifts = append(e.shifts, shift)
+		result.Write(src[last:edit.start])
+		result.WriteString(edit.text)
+		shift += len(edit.text) - (edit.end - edit.start)
+		last = edit.end
+	}
+	e.shifts = append(e.shifts, shift)
+	result.Write(src[last:])
+
+	e.src = result.Bytes()
+	return e
+}
+
+// offset returns the edited offset of an original offset. The offsets
+// inside an edit are moved after its text.
+func (e *editedSource) offset(offset int) int {
+	i := sort.Search(len(e.edits), func(i int) bool {
+		return e.edits[i].end > offset
+	})
+
+	if i < len(e.edits) && e.edits[i].start < offset {
+		return e.edits[i].start + e.shifts[i] + len(e.edits[i].text)
+	}
+
+	return offset + e.shifts[i]
+}
+
+// slice returns the edited source between two original offsets.
+func (e *editedSource) slice(start, end int) []byte {
+	return e.src[e.offset(start):e.offset(end)]
+}
+
+// nodeComments is the doc and line comments of a node.
+type nodeComments struct {
+	doc     *ast.CommentGroup
+	comment *ast.CommentGroup
+}
+
+// commentFields returns the doc and line comment fields of the node, if it
+// has them.
+func commentFields(node ast.Node) (doc, comment **ast.CommentGroup, ok bool) {
+	switch v := node.(type) {
+	case *ast.Field:
+		return &v.Doc, &v.Comment, true
+	case *ast.ImportSpec:
+		return &v.Doc, &v.Comment, true
+	case *ast.ValueSpec:
+		return &v.Doc, &v.Comment, true
+	case *ast.TypeSpec:
+		return &v.Doc, &v.Comment, true
+	case *ast.GenDecl:
+		return &v.Doc, nil, true
+	case *ast.FuncDecl:
+		return &v.Doc, nil, true
+	}
+
+	return nil, nil, false
+}
+
+// detachComments removes the comments that are not part of the original
+// source from the nodes inside the root node. It returns them in the
+// order of their nodes, and a function restoring them.
+func detachComments(root ast.Node, original map[*ast.CommentGroup]bool) ([]nodeComments, func()) {
+	detached := []nodeComments{}
+	restores := []func(){}
+
+	ast.Inspect(root, func(n ast.Node) bool {
+		doc, comment, ok := commentFields(n)
+		if !ok || n == root {
+			return true
+		}
+
+		var c nodeComments
+		if *doc != nil && !original[*doc] {
+			c.doc = *doc
+			*doc = nil
+			restores = append(restores, func() { *doc = c.doc })
+		}
+		if comment != nil && *comment != nil && !original[*comment] {
+			c.comment = *comment
+			*comment = nil
+			restores = append(restores, func() { *comment = c.comment })
+		}
+
+		detached = append(detached, c)
+		return true
+	})
+
+	return detached, func() {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+}
+
+// insertComments inserts the detached comments in the printed declaration.
+// The printer can't place comments without a position, so when there are
+// some, the printed source is parsed again, and the nodes having comment
+// fields are matched in order with the detached comments. Doc comments are
+// placed on the lines preceding their nodes, with the same indentation, and
+// line comments at the end of their node lines. The declaration is then
+// formatted again.
+// When the nodes don't match, the source is returned without the comments.
+func insertComments(src []byte, comments []nodeComments) []byte {
+	hasComments := false
+	for _, c := range comments {
+		if c.doc != nil || c.comment != nil {
+			hasComments = true
+			break
+		}
+	}
+	if !hasComments {
+		return src
+	}
+
+	const header = "package p\n"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", header+string(src), 0)
+	if err != nil || len(file.Decls) != 1 {
+		return src
+	}
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset - len(header)
+	}
+
+	root := file.Decls[0]
+	edits := []sourceEdit{}
+	index := 0
+	ast.Inspect(root, func(n ast.Node) bool {
+		if _, _, ok := commentFields(n); !ok || n == root {
+			return true
+		}
+
+		index++
+		if index > len(comments) {
+			return false
+		}
+		c := comments[index-1]
+
+		if c.doc != nil {
+			start := offset(n.Pos())
+			lineStart := start
+			for lineStart > 0 && src[lineStart-1] != '\n' {
+				lineStart--
+			}
+
+			indent := string(src[lineStart:start])
+			if strings.TrimSpace(indent) == "" {
+				text := bytes.NewBuffer([]byte{})
+				printDoc(text, c.doc, indent)
+				edits = append(edits, sourceEdit{start: lineStart, end: lineStart, text: text.String()})
+			}
+		}
+
+		if c.comment != nil {
+			end := offset(n.End())
+			if end == len(src) || src[end] == '\n' {
+				texts := []string{}
+				for _, comment := range c.comment.List {
+					texts = append(texts, comment.Text)
+				}
+				edits = append(edits, sourceEdit{start: end, end: end, text: " " + strings.Join(texts, " ")})
+			}
+		}
+
+		return true
+	})
+
+	if index != len(comments) {
+		return src
+	}
+
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+
+	// The inserted comments break the alignment of the lines around them.
+	inserted := applySourceEdits(src, edits)
+	formatted, err := format.Source(inserted)
+	if err != nil {
+		return inserted
+	}
+	return formatted
+}
+
+// printDoc prints the lines of a doc comment with the indentation.
+func printDoc(w io.Writer, doc *ast.CommentGroup, indent string) {
+	if doc == nil {
+		return
+	}
+
+	for _, comment := range doc.List {
+		if extractComment(comment) != nil {
+			continue
+		}
+		_, _ = io.WriteString(w, indent+comment.Text+"\n")
+	}
+}
+
+// docComment creates a doc comment with the text, each of its lines
+// becoming a `//` comment line.
+func docComment(text string) *ast.CommentGroup {
+	group := &ast.CommentGroup{}
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if line == "" {
+			group.List = append(group.List, &ast.Comment{Text: SINGLE_COMMENT})
+			continue
+		}
+		group.List = append(group.List, &ast.Comment{Text: SINGLE_COMMENT + " " + line})
+	}
+
+	return group
+}
+
+// declDoc returns the doc comment of the declaration.
+func declDoc(decl ast.Decl) *ast.CommentGroup {
+	switch v := decl.(type) {
+	case *ast.FuncDecl:
+		return v.Doc
+	case *ast.GenDecl:
+		return v.Doc
+	}
+
+	return nil
+}
+
+// setDeclDoc sets the doc comment of the declaration.
+func setDeclDoc(decl ast.Decl, doc *ast.CommentGroup) {
+	switch v := decl.(type) {
+	case *ast.FuncDecl:
+		v.Doc = doc
+	case *ast.GenDecl:
+		v.Doc = doc
+	}
+}
+
+// isDirective reports whether the comment is a directive for the compiler or
+// another tool: a `//line`, `//export` or `//extern` comment, or a
+// `//tool:name` comment such as `//go:noinline`.
+// Got attributes are not directives.
+func isDirective(text string) bool {
+	if strings.HasPrefix(text, "/*line ") {
+		return true
+	}
+	if !strings.HasPrefix(text, SINGLE_COMMENT) {
+		return false
+	}
+
+	text = text[COMMENT_PREFIX_LEN:]
+	for _, prefix := range []string{"line ", "export ", "extern "} {
+		if strings.HasPrefix(text, prefix) {
+			return true
+		}
+	}
+
+	colon := strings.Index(text, ":")
+	if colon <= 0 || colon+1 >= len(text) {
+		return false
+	}
+	for i := 0; i <= colon+1; i++ {
+		if i == colon {
+			continue
+		}
+		b := text[i]
+		if !('a' <= b && b <= 'z' || '0' <= b && b <= '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isCgoPreamble reports whether the comment group is the cgo preamble of
+// the file: the doc comment of the `import "C"` declaration.
+// The imports come before the other declarations, so only the first
+// declarations of the file are looked at.
+func isCgoPreamble(file *ast.File, group *ast.CommentGroup) bool {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			break
+		}
+
+		for _, spec := range gen.Specs {
+			imp := spec.(*ast.ImportSpec)
+			if imp.Path.Value != `"C"` {
+				continue
+			}
+			if imp.Doc == group || (gen.Doc == group && !gen.Lparen.IsValid()) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
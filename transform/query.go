@@ -0,0 +1,111 @@
+package transform
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// Attribute is an attribute bound to a node of the file.
+type Attribute struct {
+	Name string
+	Args []string
+
+	// Pos is the position of the attribute comment.
+	Pos token.Position
+
+	// Node is the node the attribute applies to: a declaration, a
+	// statement, a spec of a grouped declaration or a field. Once its
+	// attributes are applied, it is the node which replaced the original
+	// one, and it may not be part of the file anymore.
+	Node ast.Node
+}
+
+// AttributesOf returns the attributes bound to a node of the file, such as
+// the fields of a struct type, in order. The attributes of a declaration
+// statement are the attributes of its declaration.
+// The attributes returned are marked as queried: the attributes without a
+// decorator, like markers, are not reported as unknown.
+func (t *TransformContext) AttributesOf(node ast.Node) []Attribute {
+	return t.file.attributesOf(node)
+}
+
+// attributesOf returns the attributes bound to a node of the file, see
+// AttributesOf.
+func (f *fileTransform) attributesOf(node ast.Node) []Attribute {
+	return f.boundAttributes(node, true)
+}
+
+// boundAttributes returns the attributes bound to a node of the file, and
+// marks them as queried if mark is set. The builtin attributes reading the
+// other attributes of their node don't mark them, so that the unknown
+// attributes are still reported.
+func (f *fileTransform) boundAttributes(node ast.Node, mark bool) []Attribute {
+	if stmt, ok := node.(*ast.DeclStmt); ok {
+		node = stmt.Decl
+	}
+
+	attributes := []Attribute{}
+	for _, usage := range f.bindings[node] {
+		attributes = append(attributes, f.named(usage, "", usage.node, mark)...)
+	}
+	return attributes
+}
+
+// FindAnnotated returns the attributes of the files of the package with
+// the name, in the order of the files and of their attributes, along with
+// the nodes they are bound to.
+// The test files are only searched from the test files. The other files
+// of the package are transformed at the same time: their attributes are
+// bound to the nodes parsed from their source, which must not be modified.
+// The attributes returned are marked as queried, like with AttributesOf.
+func (t *TransformContext) FindAnnotated(name string) []Attribute {
+	files := t.file.pkg
+	if files == nil {
+		files = []*fileTransform{t.file}
+	}
+
+	attributes := []Attribute{}
+	for _, f := range files {
+		if isTestFile(f.path) && !isTestFile(t.file.path) {
+			continue
+		}
+
+		for _, usage := range f.usages {
+			node := usage.bound
+			if f == t.file {
+				node = usage.node
+			}
+			attributes = append(attributes, t.file.named(usage, name, node, true)...)
+		}
+	}
+	return attributes
+}
+
+// named returns the attributes of a usage with the name, or all of them if
+// the name is empty, bound to the node, and marks them as queried if mark
+// is set. The usages of a file may be queried by the other files of the
+// package, so they are marked with the lock of the transformer.
+func (f *fileTransform) named(u *attributesUsage, name string, node ast.Node, mark bool) []Attribute {
+	if mark {
+		f.transformer.mu.Lock()
+		defer f.transformer.mu.Unlock()
+	}
+
+	attributes := []Attribute{}
+	for i, attribute := range u.attributes {
+		if name != "" && attribute.Name != name {
+			continue
+		}
+
+		if mark {
+			u.queried[i] = true
+		}
+		attributes = append(attributes, Attribute{
+			Name: attribute.Name,
+			Args: attribute.Arguments,
+			Pos:  u.position,
+			Node: node,
+		})
+	}
+	return attributes
+}
@@ -0,0 +1,200 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"strings"
+	"testing"
+)
+
+func TestAttributesOf(t *testing.T) {
+	src := `package main
+
+// #[Fields]
+type User struct {
+	Name string
+	// #[Skip, Rename(pass)]
+	Password string
+	Age int // #[Skip]
+}
+
+func main() {
+	// #[Fields, Other]
+	var x = 1
+	_ = x
+}
+`
+
+	var result []string
+	transformTestSource(t, map[string]ExtractedDecorator{"Fields": func(c *TransformContext) error {
+		decl := c.Node().(*ast.GenDecl)
+		if decl.Tok.String() != "type" {
+			for _, attribute := range c.AttributesOf(&ast.DeclStmt{Decl: decl}) {
+				result = append(result, attribute.Name)
+			}
+			return nil
+		}
+
+		fields := decl.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields
+		for _, field := range fields.List {
+			for _, attribute := range c.AttributesOf(field) {
+				result = append(result, fmt.Sprintf("%s.%s(%s):%d",
+					field.Names[0].Name, attribute.Name, strings.Join(attribute.Args, ","), attribute.Pos.Line))
+			}
+		}
+		return nil
+	}}, src)
+
+	expected := "Password.Skip():6,Password.Rename(pass):6,Age.Skip():8,Fields,Other"
+	if strings.Join(result, ",") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(result, ","))
+	}
+}
+
+func TestFindAnnotated(t *testing.T) {
+	src := `package main
+
+// #[Options(Kind)]
+const (
+	A = "a"
+	B = "b"
+)
+
+// #[Enum]
+type Kind string
+
+// #[Enum]
+type Color string
+`
+
+	var result []string
+	transformTestSource(t, map[string]ExtractedDecorator{
+		"Options": func(c *TransformContext) error {
+			for _, attribute := range c.FindAnnotated("Enum") {
+				name := attribute.Node.(*ast.GenDecl).Specs[0].(*ast.TypeSpec).Name.Name
+				result = append(result, fmt.Sprintf("%s:%d", name, attribute.Pos.Line))
+			}
+			return nil
+		},
+		"Enum": func(c *TransformContext) error {
+			c.Delete()
+			return nil
+		},
+	}, src)
+
+	if strings.Join(result, ",") != "Kind:9,Color:12" {
+		t.Errorf("Expected Kind:9,Color:12, got %s", strings.Join(result, ","))
+	}
+}
+
+func TestQueriedAttributes(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": `package p
+
+// #[Fields]
+type A struct {
+	X int // #[Skip]
+}
+
+type B struct {
+	Y int // #[Skip]
+}
+`,
+	})
+
+	fields := func(c *TransformContext) error {
+		for _, field := range c.Node().(*ast.GenDecl).Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List {
+			c.AttributesOf(field)
+		}
+		return nil
+	}
+
+	result, err := NewTransformer(dir,
+		WithBuildDir(t.TempDir()),
+		WithDecorator("Fields", fields),
+	).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diagnostics := []string{}
+	for _, file := range result.Files {
+		for _, d := range file.Diagnostics {
+			diagnostics = append(diagnostics, fmt.Sprintf("%d: %s", d.Pos.Line, d.Message))
+		}
+	}
+
+	expected := "9: unknown attribute `Skip`"
+	if strings.Join(diagnostics, ",") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(diagnostics, ","))
+	}
+}
+
+func TestFindAnnotatedPackage(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": `package p
+
+// #[Options]
+const A = 1
+`,
+		"b.go": `package p
+
+// #[Enum]
+type Kind string
+
+// #[Marker]
+type Color string
+`,
+		"c.go": `package p
+
+// #[Enum]
+type Shape string
+`,
+		"c_test.go": `package p
+
+// #[Enum]
+type Test string
+`,
+	})
+
+	for i := 0; i < 5; i++ {
+		var found []string
+		options := func(c *TransformContext) error {
+			found = nil
+			for _, attribute := range append(c.FindAnnotated("Enum"), c.FindAnnotated("Marker")...) {
+				name := attribute.Node.(*ast.GenDecl).Specs[0].(*ast.TypeSpec).Name.Name
+				found = append(found, fmt.Sprintf("%s %s:%d", attribute.Name, name, attribute.Pos.Line))
+			}
+			return nil
+		}
+
+		result, err := NewTransformer(dir,
+			WithBuildDir(t.TempDir()),
+			WithInMemory(true),
+			WithTests(true),
+			WithParallelism(3),
+			WithDecorator("Options", options),
+			WithDecorator("Enum", func(c *TransformContext) error {
+				c.Delete()
+				return nil
+			}),
+		).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "Enum Kind:3,Enum Shape:3,Marker Color:6"
+		if strings.Join(found, ",") != expected {
+			t.Errorf("Expected %s, got %s", expected, strings.Join(found, ","))
+		}
+
+		for _, file := range result.Files {
+			if len(file.Diagnostics) > 0 {
+				t.Errorf("Expected no diagnostics, got %v", file.Diagnostics)
+			}
+		}
+	}
+}
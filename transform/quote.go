@@ -0,0 +1,421 @@
+package transform
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// quoted is a template expanded into Go source, with its placeholders
+// replaced by identifiers.
+type quoted struct {
+	src string
+
+	// values are the arguments of the placeholders, and names their name
+	// in the template, by identifier.
+	values map[string]interface{}
+	names  map[string]string
+}
+
+// quote expands a template with its arguments.
+func quote(template string, args []interface{}) (*quoted, error) {
+	q := &quoted{values: map[string]interface{}{}, names: map[string]string{}}
+	src := &strings.Builder{}
+	identifiers := map[string]string{}
+
+	next := 0
+	nextArg := func() (interface{}, error) {
+		if next >= len(args) {
+			return nil, fmt.Errorf("Missing template argument %d", next+1)
+		}
+		next++
+		return args[next-1], nil
+	}
+
+	for i := 0; i < len(template); i++ {
+		ch := template[i]
+		switch {
+		case ch == '%' && i+1 < len(template) && template[i+1] == '%':
+			src.WriteByte('%')
+			i++
+
+		case ch == '%':
+			end := i + 1
+			for end < len(template) && strings.IndexByte("+-# 0123456789.", template[end]) >= 0 {
+				end++
+			}
+			if end >= len(template) {
+				return nil, fmt.Errorf("Missing verb at the end of the template")
+			}
+
+			arg, err := nextArg()
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(src, template[i:end+1], arg)
+			i = end
+
+		case ch == '$' && i+1 < len(template) && template[i+1] == '$':
+			src.WriteByte('$')
+			i++
+
+		case ch == '$' && i+1 < len(template) && isIdentifierByte(template[i+1]):
+			end := i + 1
+			for end < len(template) && isIdentifierByte(template[end]) {
+				end++
+			}
+			name := template[i+1 : end]
+
+			identifier, ok := identifiers[name]
+			if !ok {
+				arg, err := nextArg()
+				if err != nil {
+					return nil, err
+				}
+
+				identifier = fmt.Sprintf("%s%d", GOT_PLACEHOLDER_PREFIX, len(identifiers))
+				identifiers[name] = identifier
+				q.values[identifier] = arg
+				q.names[identifier] = name
+			}
+			src.WriteString(identifier)
+
+			// The ellipsis of a list is part of the placeholder, the
+			// ellipsis of an expression is a variadic argument.
+			if strings.HasPrefix(template[end:], "...") && isList(q.values[identifier]) {
+				end += len("...")
+			}
+			i = end - 1
+
+		default:
+			src.WriteByte(ch)
+		}
+	}
+
+	if next != len(args) {
+		return nil, fmt.Errorf("Template has %d arguments, got %d", next, len(args))
+	}
+
+	q.src = src.String()
+	return q, nil
+}
+
+// quoteExpr builds an expression from a template.
+func quoteExpr(template string, args ...interface{}) (ast.Expr, error) {
+	q, err := quote(template, args)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := parser.ParseExprFrom(token.NewFileSet(), "", q.src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse template: %v", err)
+	}
+
+	node, err := q.substitute(expr)
+	if err != nil {
+		return nil, err
+	}
+	return node.(ast.Expr), nil
+}
+
+// quoteStmts builds a list of statements from a template.
+func quoteStmts(template string, args ...interface{}) ([]ast.Stmt, error) {
+	q, err := quote(template, args)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), "",
+		"package p\nfunc _() {\n"+q.src+"\n}\n", parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse template: %v", err)
+	}
+
+	node, err := q.substitute(file.Decls[0].(*ast.FuncDecl).Body)
+	if err != nil {
+		return nil, err
+	}
+	return node.(*ast.BlockStmt).List, nil
+}
+
+// quoteDecl builds a declaration from a template.
+func quoteDecl(template string, args ...interface{}) (ast.Decl, error) {
+	q, err := quote(template, args)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+q.src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse template: %v", err)
+	}
+	if len(file.Decls) != 1 {
+		return nil, fmt.Errorf("Template has %d declarations, expected 1", len(file.Decls))
+	}
+
+	node, err := q.substitute(file.Decls[0])
+	if err != nil {
+		return nil, err
+	}
+	return node.(ast.Decl), nil
+}
+
+// substitute replaces the placeholders of the parsed template by their
+// arguments, and returns the root node.
+func (q *quoted) substitute(root ast.Node) (result ast.Node, err error) {
+	resetPositions(root)
+
+	used := map[string]bool{}
+	var current string
+	defer func() {
+		// The cursor panics when a node doesn't fit in its field.
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Placeholder `$%s` can't be a %T here", q.names[current], q.values[current])
+		}
+	}()
+
+	result = astutil.Apply(root, nil, func(c *astutil.Cursor) bool {
+		switch node := c.Node().(type) {
+		case *ast.ExprStmt:
+			name, ok := q.placeholder(node.X)
+			if !ok {
+				return true
+			}
+			current = name
+
+			switch value := q.values[name].(type) {
+			case ast.Stmt:
+				c.Replace(cloneNode(value))
+			case []ast.Stmt:
+				for _, stmt := range value {
+					c.InsertBefore(cloneNode(stmt))
+				}
+				c.Delete()
+			default:
+				return true
+			}
+			used[name] = true
+
+		case *ast.Field:
+			name, ok := q.placeholder(node.Type)
+			if !ok || len(node.Names) > 0 {
+				return true
+			}
+			current = name
+
+			var fields []*ast.Field
+			switch value := q.values[name].(type) {
+			case *ast.FieldList:
+				fields = value.List
+			case []*ast.Field:
+				fields = value
+			default:
+				return true
+			}
+			for _, field := range fields {
+				c.InsertBefore(cloneNode(field))
+			}
+			c.Delete()
+			used[name] = true
+
+		case *ast.Ident:
+			name, ok := q.placeholder(node)
+			if !ok {
+				return true
+			}
+			current = name
+
+			// The nodes are copied, since they may be used several
+			// times.
+			switch value := q.values[name].(type) {
+			case string:
+				c.Replace(ast.NewIdent(value))
+			case *ast.Ident:
+				c.Replace(ast.NewIdent(value.Name))
+			case ast.Expr:
+				c.Replace(cloneNode(value))
+			case []ast.Expr:
+				for _, expr := range value {
+					c.InsertBefore(cloneNode(expr))
+				}
+				c.Delete()
+			case []*ast.Ident:
+				for _, ident := range value {
+					c.InsertBefore(ast.NewIdent(ident.Name))
+				}
+				c.Delete()
+			default:
+				// Statements and fields are replaced with their parent.
+				return true
+			}
+			used[name] = true
+		}
+
+		return true
+	})
+
+	for name, value := range q.values {
+		if !used[name] {
+			return nil, fmt.Errorf("Placeholder `$%s` can't be a %T here", q.names[name], value)
+		}
+	}
+
+	return result, nil
+}
+
+// placeholder returns the identifier of the placeholder the node stands
+// for, if it does.
+func (q *quoted) placeholder(node ast.Node) (string, bool) {
+	ident, ok := node.(*ast.Ident)
+	if !ok {
+		return "", false
+	}
+
+	_, ok = q.values[ident.Name]
+	return ident.Name, ok
+}
+
+// resetPositions removes the positions of the parsed template nodes, which
+// are not positions of the file they are inserted in.
+// The ellipsis of the calls is kept valid, since it marks a variadic call.
+func resetPositions(root ast.Node) {
+	posType := reflect.TypeOf(token.NoPos)
+
+	ast.Inspect(root, func(node ast.Node) bool {
+		if node == nil {
+			return false
+		}
+
+		call, isCall := node.(*ast.CallExpr)
+		variadic := isCall && call.Ellipsis.IsValid()
+
+		v := reflect.ValueOf(node).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if field := v.Field(i); field.Type() == posType {
+				field.SetInt(0)
+			}
+		}
+
+		if variadic {
+			call.Ellipsis = 1
+		}
+
+		return true
+	})
+}
+
+// cloneNode returns a deep copy of a node. The objects and scopes resolved
+// by the parser are shared, since they are not part of the tree.
+func cloneNode(node ast.Node) ast.Node {
+	return cloneValue(reflect.ValueOf(node)).Interface().(ast.Node)
+}
+
+var (
+	objectType = reflect.TypeOf(&ast.Object{})
+	scopeType  = reflect.TypeOf(&ast.Scope{})
+)
+
+// cloneValue returns a deep copy of a value of an ast node.
+func cloneValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || v.Type() == objectType || v.Type() == scopeType {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(cloneValue(v.Elem()))
+		return c
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(cloneValue(v.Elem()))
+		return c
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(cloneValue(v.Index(i)))
+		}
+		return c
+
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			c.Field(i).Set(cloneValue(v.Field(i)))
+		}
+		return c
+	}
+
+	return v
+}
+
+// isList reports whether a placeholder argument is a list spliced in place
+// of the placeholder.
+func isList(value interface{}) bool {
+	switch value.(type) {
+	case []ast.Expr, []*ast.Ident, []ast.Stmt, []*ast.Field, *ast.FieldList:
+		return true
+	}
+	return false
+}
+
+// isIdentifierByte reports whether the byte can be part of an identifier.
+func isIdentifierByte(b byte) bool {
+	return b == '_' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9'
+}
+
+// Expr builds an expression from a Go template, such as
+// `c.Expr("$x + 1", x)`. Types are expressions as well.
+//
+// Templates are Go snippets building nodes for the decorators, like:
+//
+//	c.Stmts(`fmt.Printf(%q, $args...)`, format, args)
+//
+// The arguments are consumed in order by the template:
+//   - fmt verbs, such as `%q` or `%d`, format their argument into the
+//     template text, `%%` being a percent sign;
+//   - `$name` placeholders are replaced by their argument, a node. Using
+//     the same name again refers to the same argument, and `$$` is a
+//     dollar sign.
+//
+// A placeholder argument can be:
+//   - a string, which becomes an identifier;
+//   - an ast.Expr, which includes the identifiers and the types;
+//   - an ast.Stmt or a []ast.Stmt, in place of a statement;
+//   - a []ast.Expr or a []*ast.Ident, spliced in a list of expressions,
+//     such as the arguments of a call, written `$name...`;
+//   - a *ast.FieldList or a []*ast.Field, spliced in a list of parameters,
+//     results or struct fields, written `$name...`.
+//
+// The nodes of the arguments are copied wherever they are placed, so an
+// argument can be used several times, and the nodes built are never part
+// of the tree the arguments were taken from.
+func (t *TransformContext) Expr(template string, args ...interface{}) (ast.Expr, error) {
+	return quoteExpr(template, args...)
+}
+
+// Stmts builds a list of statements from a Go template, such as
+// `c.Stmts("defer $unlock()", unlock)`.
+// See Expr for the template syntax.
+func (t *TransformContext) Stmts(template string, args ...interface{}) ([]ast.Stmt, error) {
+	return quoteStmts(template, args...)
+}
+
+// Decl builds a declaration from a Go template, such as
+// `c.Decl("func (v $type) String() string { return %q }", typ, name)`.
+// See Expr for the template syntax.
+func (t *TransformContext) Decl(template string, args ...interface{}) (ast.Decl, error) {
+	return quoteDecl(template, args...)
+}
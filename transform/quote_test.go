@@ -0,0 +1,130 @@
+package transform
+
+import (
+	"bytes"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func printNode(t *testing.T, node interface{}) string {
+	buf := bytes.NewBuffer([]byte{})
+	if err := printer.Fprint(buf, token.NewFileSet(), node); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func testQuoteExpr(t *testing.T, expected string, template string, args ...interface{}) {
+	expr, err := quoteExpr(template, args...)
+	if err != nil {
+		t.Errorf("Expected %s, got error %v", expected, err)
+		return
+	}
+
+	if result := printNode(t, expr); result != expected {
+		t.Errorf("Expected %s, got %s", expected, result)
+	}
+}
+
+func testQuoteError(t *testing.T, expected string, template string, args ...interface{}) {
+	_, err := quoteStmts(template, args...)
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+}
+
+func TestQuoteExpr(t *testing.T) {
+	args := []ast.Expr{ast.NewIdent("a"), ast.NewIdent("b")}
+
+	testQuoteExpr(t, `fmt.Printf("%s, %s\n", a, b)`, `fmt.Printf(%q, $args...)`, "%s, %s\n", args)
+	testQuoteExpr(t, `append(b, s...)`, `append($b, $s...)`, ast.NewIdent("b"), ast.NewIdent("s"))
+	testQuoteExpr(t, `f(x, y)`, `f($idents...)`, []*ast.Ident{ast.NewIdent("x"), ast.NewIdent("y")})
+	testQuoteExpr(t, `u.Name + u.Name`, `$u.$field + $u.$field`, "u", "Name")
+	testQuoteExpr(t, `map[string]User{}`, `map[string]$type{}`, ast.NewIdent("User"))
+	testQuoteExpr(t, `x%2 == 0`, `$x%%2 == %d`, "x", 0)
+	testQuoteExpr(t, `f("$HOME")`, `f("$$HOME")`)
+}
+
+func TestQuoteExprCopies(t *testing.T) {
+	sel := &ast.SelectorExpr{X: ast.NewIdent("u"), Sel: ast.NewIdent("Name")}
+	expr, err := quoteExpr(`$x + $x`, sel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each use of the argument is a copy, so modifying one leaves the
+	// other and the argument unchanged.
+	binary := expr.(*ast.BinaryExpr)
+	if binary.X == binary.Y || binary.X == ast.Expr(sel) {
+		t.Fatalf("Expected copies of the argument, got the same node")
+	}
+	binary.X.(*ast.SelectorExpr).Sel.Name = "ID"
+	if result := printNode(t, expr); result != "u.ID + u.Name" {
+		t.Errorf("Expected u.ID + u.Name, got %s", result)
+	}
+	if sel.Sel.Name != "Name" {
+		t.Errorf("Expected the argument to be unchanged, got %s", sel.Sel.Name)
+	}
+}
+
+func TestQuoteStmts(t *testing.T) {
+	body, err := quoteStmts("println(1)\nreturn 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmts, err := quoteStmts(`
+		$name := 0
+		for _, v := range $values {
+			$name += v
+		}
+		$body
+	`, "sum", ast.NewIdent("values"), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := printNode(t, &ast.BlockStmt{List: stmts})
+	expected := "{\n\tsum := 0\n\tfor _, v := range values {\n\t\tsum += v\n\t}\n\tprintln(1)\n\treturn 2\n}"
+	if result != expected {
+		t.Errorf("Expected %s, got %s", expected, result)
+	}
+}
+
+func TestQuoteDecl(t *testing.T) {
+	params := &ast.FieldList{List: []*ast.Field{{
+		Names: []*ast.Ident{ast.NewIdent("name")},
+		Type:  ast.NewIdent("string"),
+	}}}
+	body, err := quoteStmts("return %q + name", "Hello ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decl, err := quoteDecl(`func (u *$type) $name($params...) string {
+		$body
+	}`, ast.NewIdent("User"), "Greet", params, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := printNode(t, decl)
+	expected := "func (u *User) Greet(name string) string {\n\treturn \"Hello \" + name\n}"
+	if result != expected {
+		t.Errorf("Expected %s, got %s", expected, result)
+	}
+}
+
+func TestQuoteErrors(t *testing.T) {
+	testQuoteError(t, "Missing template argument 2", "$a = $b", "a")
+	testQuoteError(t, "Template has 1 arguments, got 2", "$a = 1", "a", "b")
+	testQuoteError(t, "Failed to parse template", "$a = ", "a")
+	testQuoteError(t, "Placeholder `$sel` can't be a *ast.CallExpr here", "x.$sel()",
+		&ast.CallExpr{Fun: ast.NewIdent("f")})
+	testQuoteError(t, "Placeholder `$x` can't be a int here", "f($x)", 1)
+	testQuoteError(t, "Placeholder `$args` can't be a []ast.Expr here", "$args...",
+		[]ast.Expr{ast.NewIdent("a")})
+}
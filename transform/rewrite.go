@@ -0,0 +1,536 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"io"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// The rewriting engine parses each file once, binds its attributes to their
+// nodes once, then applies the attributes directly on the AST, with one
+// traversal for the builtin attributes and one for the others. The
+// generated source is printed once at the end, from the original source and
+// the transformed declarations (see fileLayout).
+
+type attributesUsage struct {
+	// group is the comment group holding the attribute comment.
+	group      *ast.CommentGroup
+	position   token.Position
+	attributes []AttributeInstruction
+
+	// node is the node the attributes are bound to, and bound the node
+	// they were bound to when the file was parsed, which the other files
+	// of the package see.
+	node  ast.Node
+	bound ast.Node
+
+	// done records the attributes already applied or skipped, by index,
+	// and queried the attributes queried by a decorator.
+	done    []bool
+	queried []bool
+}
+
+// extractAttributeUsages parses the source and returns its attribute usages.
+func extractAttributeUsages(src io.Reader) ([]*attributesUsage, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse file: %v", err)
+	}
+
+	usages, diagnostics := attributeUsages(fset, file)
+	if len(diagnostics) > 0 {
+		return nil, errors.New(diagnostics[0].String())
+	}
+	return usages, nil
+}
+
+// attributeUsages returns the attribute usages of a parsed file, in the
+// order of their comments, and the diagnostics of the attribute comments
+// which can't be parsed.
+func attributeUsages(fset *token.FileSet, file *ast.File) ([]*attributesUsage, []Diagnostic) {
+	var usages []*attributesUsage
+	var diagnostics []Diagnostic
+
+	for _, comments := range file.Comments {
+		for _, comment := range comments.List {
+			usage, err := parseComment(comment)
+			if err != nil {
+				diagnostics = append(diagnostics, Diagnostic{
+					Pos:     fset.Position(comment.Pos() + token.Pos(err.Offset)),
+					Message: err.Message,
+				})
+				continue
+			}
+			if usage != nil {
+				usage.group = comments
+				usage.position = fset.Position(comment.Pos())
+				usages = append(usages, usage)
+			}
+		}
+	}
+
+	return usages, diagnostics
+}
+
+// extractComment returns the attribute usage of a comment, or nil when it
+// is not a valid attribute comment.
+func extractComment(comment *ast.Comment) *attributesUsage {
+	usage, _ := parseComment(comment)
+	return usage
+}
+
+// parseComment returns the attribute usage of a comment, nil when it is
+// not an attribute comment, or the error of an attribute comment which
+// can't be parsed, at an offset of the comment.
+func parseComment(comment *ast.Comment) (*attributesUsage, *ParseError) {
+	if constraint.IsGoBuild(comment.Text) {
+		return nil, nil
+	}
+
+	// The text of a comment starts with its `//`, so the offsets in the
+	// trimmed line are the offsets in the comment.
+	line := strings.TrimSpace(comment.Text)
+	if !IsLineGotPrefixed(line) {
+		return nil, nil
+	}
+
+	parser := NewInstructionParser(strings.NewReader(line), AllInstructions)
+	_, err := parser.Parse()
+	if err != nil {
+		parseErr, ok := err.(*ParseError)
+		if !ok {
+			parseErr = &ParseError{Message: err.Error()}
+		}
+		return nil, parseErr
+	}
+	var usage *attributesUsage
+
+	for _, instruction := range parser.result {
+		if instruction.Type() == AttributeInstructionType {
+			attr := instruction.(AttributeInstruction)
+
+			if usage == nil {
+				usage = &attributesUsage{
+					attributes: []AttributeInstruction{},
+				}
+			}
+
+			usage.attributes = append(usage.attributes, attr)
+			usage.done = append(usage.done, false)
+			usage.queried = append(usage.queried, false)
+		} else {
+			return nil, nil
+		}
+	}
+
+	return usage, nil
+}
+
+// bindAttributes binds each attribute usage to the node it applies to. The
+// comment groups are associated with their nodes like ast.CommentMap does,
+// then the attributes are bound with explicit rules (see attributeTarget).
+// The usages which can't be bound unambiguously are left unbound, with a
+// nil node, and reported as diagnostics.
+func bindAttributes(fset *token.FileSet, file *ast.File, usages []*attributesUsage) (map[ast.Node][]*attributesUsage, []Diagnostic) {
+	bindings := map[ast.Node][]*attributesUsage{}
+	if len(usages) == 0 {
+		return bindings, nil
+	}
+
+	var diagnostics []Diagnostic
+	associated := associatedNodes(fset, file)
+	for _, usage := range usages {
+		target, err := attributeTarget(fset, usage.group, associated[usage.group])
+		if err != nil {
+			diagnostics = append(diagnostics, Diagnostic{
+				Pos:     usage.position,
+				Message: fmt.Sprintf("attribute `%s` %v", usage.attributes[0].Name, err),
+			})
+			continue
+		}
+
+		usage.node = target
+		usage.bound = target
+		bindings[target] = append(bindings[target], usage)
+	}
+
+	return bindings, diagnostics
+}
+
+// associatedNodes returns the nodes the comment groups of the file are
+// associated with, like ast.CommentMap does.
+func associatedNodes(fset *token.FileSet, file *ast.File) map[*ast.CommentGroup]ast.Node {
+	associated := map[*ast.CommentGroup]ast.Node{}
+	for node, groups := range ast.NewCommentMap(fset, file, file.Comments) {
+		for _, group := range groups {
+			associated[group] = node
+		}
+	}
+	return associated
+}
+
+// attributeTarget returns the node the attributes of a comment group apply
+// to, given the node the group is associated with: a declaration, a
+// statement, a spec of a grouped declaration or a field.
+// The group must be:
+//   - the doc comment of the node;
+//   - a leading comment, ending on the line preceding the node;
+//   - or a trailing comment, starting on the line where the node ends.
+//
+// The attributes of a declaration statement apply to its declaration.
+func attributeTarget(fset *token.FileSet, group *ast.CommentGroup, node ast.Node) (ast.Node, error) {
+	if node == nil {
+		return nil, errors.New("is not attached to any declaration or statement")
+	}
+
+	groupStart := fset.Position(group.Pos()).Line
+	groupEnd := fset.Position(group.End()).Line
+	nodeStart := fset.Position(node.Pos()).Line
+	nodeEnd := fset.Position(node.End()).Line
+
+	isDoc := false
+	if decl, ok := node.(ast.Decl); ok {
+		isDoc = declDoc(decl) == group
+	}
+	isLeading := group.End() <= node.Pos() && groupEnd+1 == nodeStart
+	isTrailing := group.Pos() >= node.End() && groupStart == nodeEnd
+
+	switch {
+	case isDoc || isLeading || isTrailing:
+	case group.End() <= node.Pos():
+		return nil, fmt.Errorf("is separated from the %s at line %d by blank lines", nodeKind(node), nodeStart)
+	default:
+		return nil, fmt.Errorf("follows the %s at line %d but is not on its last line", nodeKind(node), nodeStart)
+	}
+
+	switch v := node.(type) {
+	case *ast.DeclStmt:
+		return v.Decl, nil
+	case ast.Decl, ast.Stmt, ast.Spec, *ast.Field:
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("is attached to a %s, not to a declaration, statement or field", nodeKind(node))
+}
+
+// nodeKind returns the name of the node type, such as "FuncDecl".
+func nodeKind(node ast.Node) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+}
+
+// processAttributeTransforms applies the attributes bound to the nodes of
+// the file, in a single traversal visiting the nodes after their children.
+// When builtinOnly is true, only the builtin attributes are applied.
+func (t *Transformer) processAttributeTransforms(
+	f *fileTransform,
+	builtinOnly bool,
+) (isModified bool, err error) {
+	if len(f.bindings) == 0 {
+		return false, nil
+	}
+
+	// ancestors are the nodes enclosing the current node, from the file.
+	var ancestors []ast.Node
+	pre := func(c *astutil.Cursor) bool {
+		ancestors = append(ancestors, c.Node())
+		return true
+	}
+
+	var processErr error
+	astutil.Apply(f.file, pre, func(c *astutil.Cursor) bool {
+		ancestors = ancestors[:len(ancestors)-1]
+
+		usages, ok := f.bindings[c.Node()]
+		if !ok {
+			return true
+		}
+
+		modified, err := t.applyChain(f, c, ancestors, usages, builtinOnly)
+		if err != nil {
+			processErr = err
+			return false
+		}
+		if modified {
+			isModified = true
+		}
+
+		return true
+	})
+	if processErr != nil {
+		return isModified, processErr
+	}
+
+	return isModified, nil
+}
+
+// applyChain applies the attributes bound to the current node of the
+// cursor, in order, as a pipeline: each attribute receives the output of
+// the previous ones (see TransformContext). The output is then written to
+// the AST, and the declarations it transformed are recorded in the layout.
+func (t *Transformer) applyChain(
+	f *fileTransform,
+	c *astutil.Cursor,
+	ancestors []ast.Node,
+	usages []*attributesUsage,
+	builtinOnly bool,
+) (bool, error) {
+	originalNode := c.Node()
+	pos := f.fset.Position(originalNode.Pos()).Offset
+
+	tc := &TransformContext{
+		Cursor:      c,
+		file:        f,
+		currentNode: originalNode,
+		nodes:       []ast.Node{originalNode},
+		ancestors:   ancestors,
+		File:        f.file,
+		fileSrc:     f.original,
+	}
+
+	for _, usage := range usages {
+		for i, attribute := range usage.attributes {
+			if usage.done[i] {
+				continue
+			}
+			if tc.stopped {
+				f.log(fmt.Sprintf("Skipping attribute `%s`: the chain was stopped", attribute.Name))
+				usage.done[i] = true
+				continue
+			}
+			if tc.deletedBy != "" {
+				return false, fmt.Errorf("%s: attribute `%s` targets the node deleted by `%s`",
+					usage.position, attribute.Name, tc.deletedBy)
+			}
+
+			handler, ok := BuiltinAttributes[attribute.Name]
+			if ok {
+				f.log(fmt.Sprintf(
+					"Executing builtin attribute: `%s` on position %d",
+					attribute.Name, pos))
+			} else if !builtinOnly {
+				handler, ok = t.decorator(f.path, attribute.Name)
+				if ok {
+					f.log(fmt.Sprintf("Executing decorator: `%s` on position %d", attribute.Name, pos))
+				}
+			}
+			if !ok {
+				continue
+			}
+
+			tc.args = attribute.Arguments
+			if spec, ok := t.decoratorSpec(f.path, attribute.Name); ok && !attribute.IsBuiltin {
+				args, err := spec.validate(tc.Node(), attribute.Arguments)
+				if err != nil {
+					return false, fmt.Errorf("%s: attribute `%s` %v", usage.position, attribute.Name, err)
+				}
+				tc.args = args
+			}
+			tc.position = usage.position
+			tc.attribute = attribute.Name
+
+			// Builtin attributes are part of got, so only the decorators
+			// are limited in time.
+			timeout := t.callTimeout(attribute.Name)
+			if attribute.IsBuiltin {
+				timeout = 0
+			}
+			err := isolate(timeout, func(ctx context.Context) error {
+				tc.ctx = ctx
+				return handler(tc)
+			})
+			if isolated, stack := isolationError(err); isolated {
+				if stack != nil {
+					f.log(fmt.Sprintf("Decorator `%s` panicked:\n%s", attribute.Name, stack))
+				}
+				return false, fmt.Errorf("%s: attribute `%s` %v", usage.position, attribute.Name, err)
+			}
+			if err != nil {
+				return false, fmt.Errorf("Failed to execute decorator `%s`: %v", attribute.Name, err)
+			}
+			usage.done[i] = true
+			f.recordApplied(usage, attribute)
+		}
+	}
+
+	if !tc.modified {
+		return false, nil
+	}
+
+	if err := tc.commit(); err != nil {
+		return false, fmt.Errorf("Failed to execute decorator `%s`: %v", tc.attribute, err)
+	}
+
+	f.log(fmt.Sprintf("Attribute `%s` modified source", tc.attribute))
+
+	// The attributes left for the next traversal apply to the node which
+	// replaced the original one.
+	if len(tc.nodes) > 0 && tc.nodes[0] != originalNode {
+		f.bindings[tc.nodes[0]] = usages
+		for _, usage := range usages {
+			usage.node = tc.nodes[0]
+		}
+	}
+
+	if _, ok := c.Parent().(*ast.File); !ok {
+		f.layout.touch(f.layout.enclosingDecl(originalNode))
+	} else {
+		if tc.replaced && len(tc.nodes) > 0 {
+			f.layout.replace(originalNode, tc.nodes[0])
+		}
+		f.layout.derive(originalNode, tc.Nodes())
+	}
+
+	return true, nil
+}
+
+// cleanupEdits returns the edits cleaning up the original source of the
+// file: the got attribute comments are removed. All the other comments are
+// kept, including the directives (`//go:`, `//export`, ...) and the cgo
+// preamble, which are part of the program semantics, except for the
+// obsolete `// +build` lines.
+// The build constraint is replaced by the constraint of the generated file,
+// requiring the generated tag when addTag is true. The returned flag
+// reports whether the file has a build constraint.
+func cleanupEdits(fset *token.FileSet, file *ast.File, addTag bool) ([]sourceEdit, bool) {
+	hasConstraint := false
+	handleComment := func(comment *ast.Comment) string {
+		if constraint.IsGoBuild(comment.Text) {
+			exp, err := constraint.Parse(comment.Text)
+			if err != nil {
+				return ""
+			}
+			hasConstraint = true
+
+			exp = generatedConstraint(exp, addTag)
+			if exp == nil {
+				return ""
+			}
+
+			return "//go:build " + exp.String()
+		}
+
+		if isRemovedComment(comment) {
+			return ""
+		}
+
+		return comment.Text
+	}
+
+	edits := []sourceEdit{}
+	for _, comments := range file.Comments {
+		if isCgoPreamble(file, comments) {
+			continue
+		}
+
+		for _, c := range comments.List {
+			commentText := handleComment(c)
+			if commentText == c.Text {
+				continue
+			}
+
+			edits = append(edits, sourceEdit{
+				start: fset.Position(c.Pos()).Offset,
+				end:   fset.Position(c.End()).Offset,
+				text:  commentText,
+			})
+		}
+	}
+
+	return edits, hasConstraint
+}
+
+// isRemovedComment reports whether the comment is removed from the
+// generated source: an attribute comment or an obsolete `// +build` line.
+func isRemovedComment(comment *ast.Comment) bool {
+	return constraint.IsPlusBuild(comment.Text) || extractComment(comment) != nil
+}
+
+// printGeneratedSource prints the transformed file with the cleanup edits
+// applied. The file is not formatted again: the original source is copied
+// and the transformed declarations are printed like gofmt does.
+func printGeneratedSource(f *fileTransform, addTag bool) ([]byte, error) {
+	edits, hasConstraint := cleanupEdits(f.fset, f.file, addTag)
+
+	buf := bytes.NewBuffer([]byte{})
+	if !hasConstraint && addTag {
+		buf.WriteString("//go:build " + GENERATED_TAG + "\n\n")
+	}
+
+	if err := f.layout.print(buf, edits); err != nil {
+		return nil, fmt.Errorf("Failed to print file: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// sourceEdit replaces the source between two offsets by the text.
+type sourceEdit struct {
+	start int
+	end   int
+	text  string
+}
+
+// applySourceEdits applies the edits, sorted by offset, to the source.
+// When a removed text is alone in its line, the whole line is removed.
+func applySourceEdits(src []byte, edits []sourceEdit) []byte {
+	result := bytes.NewBuffer([]byte{})
+
+	last := 0
+	for _, edit := range expandEdits(src, edits) {
+		result.Write(src[last:edit.start])
+		result.WriteString(edit.text)
+		last = edit.end
+	}
+	result.Write(src[last:])
+
+	return result.Bytes()
+}
+
+// expandEdits extends the edits removing a text alone in its line to the
+// whole line, and the edits removing a text at the end of a line to the
+// blank space preceding it.
+func expandEdits(src []byte, edits []sourceEdit) []sourceEdit {
+	expanded := make([]sourceEdit, 0, len(edits))
+
+	last := 0
+	for _, edit := range edits {
+		start, end := edit.start, edit.end
+
+		if edit.text == "" {
+			lineStart := start
+			for lineStart > last && (src[lineStart-1] == ' ' || src[lineStart-1] == '\t') {
+				lineStart--
+			}
+			lineEnd := end
+			for lineEnd < len(src) && (src[lineEnd] == ' ' || src[lineEnd] == '\t' || src[lineEnd] == '\r') {
+				lineEnd++
+			}
+
+			atLineStart := lineStart == 0 || src[lineStart-1] == '\n'
+			atLineEnd := lineEnd == len(src) || src[lineEnd] == '\n'
+			if atLineStart && atLineEnd {
+				start, end = lineStart, lineEnd
+				if end < len(src) {
+					end++
+				}
+			} else if atLineEnd {
+				start = lineStart
+			}
+		}
+
+		expanded = append(expanded, sourceEdit{start: start, end: end, text: edit.text})
+		last = end
+	}
+
+	return expanded
+}
@@ -0,0 +1,327 @@
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+func testBindAttribute(t *testing.T, src string, expected string) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "main.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usages, _ := attributeUsages(fset, file)
+	bindings, diagnostics := bindAttributes(fset, file, usages)
+	if len(diagnostics) > 0 {
+		if !strings.Contains(diagnostics[0].String(), expected) {
+			t.Errorf("Expected %s, got %v", expected, diagnostics)
+		}
+		return
+	}
+
+	for node := range bindings {
+		result := fmt.Sprintf("%s at line %d", nodeKind(node), fset.Position(node.Pos()).Line)
+		if result != expected {
+			t.Errorf("Expected %s, got %s", expected, result)
+		}
+	}
+	if len(bindings) != 1 {
+		t.Errorf("Expected 1 binding, got %d", len(bindings))
+	}
+}
+
+func TestBindAttributes(t *testing.T) {
+	// Leading line and doc group.
+	testBindAttribute(t, "package p\n\n// #[A]\nfunc F() {}\n", "FuncDecl at line 4")
+	testBindAttribute(t, "package p\n\n// #[A]\n// T is a type.\ntype T int\n", "GenDecl at line 5")
+	testBindAttribute(t, "package p\n\nfunc F() {\n\t// #[A]\n\tprintln()\n}\n", "ExprStmt at line 5")
+	testBindAttribute(t, "package p\n\nfunc F() {\n\t// #[A]\n\tvar x = 1\n\t_ = x\n}\n", "GenDecl at line 5")
+
+	// Fields and specs.
+	testBindAttribute(t, "package p\n\ntype T struct {\n\t// #[A]\n\tX int\n}\n", "Field at line 5")
+	testBindAttribute(t, "package p\n\ntype T struct {\n\tX int // #[A]\n}\n", "Field at line 4")
+	testBindAttribute(t, "package p\n\nconst (\n\t// #[A]\n\tX = 1\n)\n", "ValueSpec at line 5")
+
+	// Trailing same-line.
+	testBindAttribute(t, "package p\n\nfunc F() {\n\tx := 1 // #[A]\n\t_ = x\n}\n", "AssignStmt at line 4")
+	testBindAttribute(t, "package p\n\nfunc F() {\n\tif true {\n\t} // #[A]\n}\n", "IfStmt at line 4")
+
+	// Ambiguous.
+	testBindAttribute(t, "package p\n\n// #[A]\n\nfunc F() {}\n",
+		"main.go:3:1: attribute `A` is separated from the FuncDecl at line 5 by blank lines")
+	testBindAttribute(t, "package p\n\nvar x = 1\n// #[A]\n\nfunc F() {}\n",
+		"attribute `A` follows the GenDecl at line 3 but is not on its last line")
+	testBindAttribute(t, "package p\n\nfunc F() {\n\tif true {\n\t}\n\t// #[A]\n}\n",
+		"attribute `A` follows the IfStmt at line 4 but is not on its last line")
+	testBindAttribute(t, "package p\n\nfunc F() {\n\tprintln(1,\n\t\t// #[A]\n\t\t2)\n}\n",
+		"attribute `A` is attached to a BasicLit, not to a declaration, statement or field")
+}
+
+// generateSource generates a file with n functions, half of them having an
+// attribute, and the other half having an attribute in their body.
+func generateSource(n int) []byte {
+	src := &strings.Builder{}
+	src.WriteString("package p\n\nimport \"fmt\"\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(src, "\n// F%d is a function.\n", i)
+		if i%2 == 0 {
+			src.WriteString("// #[Rename]\n")
+		}
+		fmt.Fprintf(src, "func F%d() {\n", i)
+		if i%2 == 1 {
+			src.WriteString("\t// #[Double]\n")
+		}
+		fmt.Fprintf(src, "\tx := %d\n\tfmt.Println(x)\n}\n", i)
+	}
+	return []byte(src.String())
+}
+
+var renameDecorator = func(c *TransformContext) error {
+	fn := c.Node().(*ast.FuncDecl)
+	fn.Name = ast.NewIdent(fn.Name.Name + "Renamed")
+	c.Replace(fn)
+	return nil
+}
+
+var doubleDecorator = func(c *TransformContext) error {
+	assign := c.Node().(*ast.AssignStmt)
+	assign.Rhs[0] = &ast.BinaryExpr{X: assign.Rhs[0], Op: token.MUL, Y: ast.NewIdent("2")}
+	c.Replace(assign)
+	return nil
+}
+
+var modelDecorator = func(c *TransformContext) error {
+	decl := c.Node().(*ast.GenDecl)
+	structType := decl.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType)
+	field := &ast.Field{Names: []*ast.Ident{ast.NewIdent("ID")}, Type: ast.NewIdent("int")}
+	c.SetDoc(field, "ID identifies the configuration.")
+	structType.Fields.List = append(structType.Fields.List, field)
+	c.Replace(decl)
+
+	table := &ast.GenDecl{Tok: token.CONST, Specs: []ast.Spec{&ast.ValueSpec{
+		Names:  []*ast.Ident{ast.NewIdent("Table")},
+		Values: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: `"configs"`}},
+	}}}
+	c.SetDoc(table, "Table is the table of the configurations.")
+	c.InsertBefore(table)
+	return nil
+}
+
+var dropDecorator = func(c *TransformContext) error {
+	c.Delete()
+	return nil
+}
+
+var aroundDecorator = func(c *TransformContext) error {
+	before := &ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{&ast.ValueSpec{
+		Names:  []*ast.Ident{ast.NewIdent("before")},
+		Values: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "0"}},
+	}}}
+	after := &ast.FuncDecl{
+		Name: ast.NewIdent("after"),
+		Type: &ast.FuncType{Params: &ast.FieldList{}, Results: &ast.FieldList{List: []*ast.Field{{Type: ast.NewIdent("int")}}}},
+		Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ReturnStmt{Results: []ast.Expr{ast.NewIdent("v")}}}},
+	}
+	c.InsertBefore(before)
+	c.InsertAfter(after)
+	return nil
+}
+
+// rewriteTestOptions are the decorators of the files of testdata/rewrite.
+func rewriteTestOptions() []Option {
+	return []Option{
+		WithDecorator("Rename", renameDecorator),
+		WithDecorator("Double", doubleDecorator),
+		WithDecorator("Model", modelDecorator),
+		WithDecorator("Drop", dropDecorator),
+		WithDecorator("Around", aroundDecorator),
+	}
+}
+
+// TestRewriteGolden checks that the files of testdata/rewrite are printed
+// like the engine reprinting the whole file did: the golden files are its
+// output.
+func TestRewriteGolden(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "rewrite", "*.go"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("Expected the files of testdata/rewrite, got %v", err)
+	}
+
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := os.ReadFile(strings.TrimSuffix(path, ".go") + ".golden")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The header of the generated file is left out: it is not part of
+		// the printed source.
+		transformer := NewTransformer(".", rewriteTestOptions()...)
+		f, err := transformer.newFileTransform(filepath.Base(path), src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := transformer.applyAttributes(f); err != nil {
+			t.Fatal(err)
+		}
+		out, err := printGeneratedSource(f, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != string(expected) {
+			t.Errorf("Expected %s to be rewritten to:\n%s\ngot:\n%s", path, expected, out)
+		}
+	}
+}
+
+func benchmarkTransform(b *testing.B, n int) {
+	src := generateSource(n)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		t := NewTransformer(".", WithDecorator("Rename", renameDecorator),
+			WithDecorator("Double", doubleDecorator))
+		t.inMemory = true
+		if _, _, err := t.transformFile("p.go", src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTransform100(b *testing.B)  { benchmarkTransform(b, 100) }
+func BenchmarkTransform1000(b *testing.B) { benchmarkTransform(b, 1000) }
+
+// reprintTransform transforms the source like the engine before the single
+// pass rewrite (63997ac) did, as a baseline for benchmarkTransform: the
+// source is parsed to find the attributes, then for each of the builtin
+// and decorator passes, and once more to clean it up. Each pass walks the
+// whole file for each attribute, and prints the whole file after each
+// attribute modifying it. The declarations are printed with fileLayout,
+// like the single pass engine does.
+func reprintTransform(t *Transformer, path string, src []byte) ([]byte, error) {
+	usages, err := extractAttributeUsages(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, builtinOnly := range []bool{true, false} {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		layout := newFileLayout(fset, file, src)
+		updated := bytes.NewBuffer(append([]byte{}, src...))
+
+		for _, usage := range usages {
+			commentEnd := usage.position.Offset + bytes.IndexByte(src[usage.position.Offset:], '\n')
+
+			var processErr error
+			astutil.Apply(file, nil, func(c *astutil.Cursor) bool {
+				node := c.Node()
+				if node == nil {
+					return true
+				}
+				nodePos := fset.Position(node.Pos()).Offset
+				endPos := fset.Position(node.End()).Offset
+				switch n := node.(type) {
+				case *ast.FuncDecl:
+					endPos = fset.Position(n.Body.Lbrace).Offset
+				case *ast.GenDecl, *ast.AssignStmt, *ast.IfStmt:
+				default:
+					return true
+				}
+
+				if nodePos > commentEnd && strings.TrimSpace(string(src[commentEnd:nodePos])) != "" {
+					return true
+				}
+				if commentEnd > endPos {
+					return true
+				}
+
+				context := &TransformContext{Cursor: c, File: file, currentNode: node, position: usage.position}
+				for _, attribute := range usage.attributes {
+					context.args = attribute.Arguments
+					handler, ok := BuiltinAttributes[attribute.Name]
+					if !ok && !builtinOnly {
+						handler, ok = t.decorator(path, attribute.Name)
+					}
+					if ok {
+						if processErr = handler(context); processErr != nil {
+							return false
+						}
+					}
+				}
+
+				if context.modified {
+					if context.replaced {
+						c.Replace(context.currentNode)
+					}
+					if _, ok := c.Parent().(*ast.File); !ok {
+						layout.touch(layout.enclosingDecl(node))
+					} else if context.replaced {
+						layout.replace(node, context.currentNode)
+					}
+
+					updated.Reset()
+					processErr = layout.print(updated, nil)
+				}
+				return false
+			})
+			if processErr != nil {
+				return nil, processErr
+			}
+		}
+
+		src = updated.Bytes()
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	edits, hasConstraint := cleanupEdits(fset, file, true)
+	result := applySourceEdits(src, edits)
+	if !hasConstraint {
+		result = append([]byte("//go:build "+GENERATED_TAG+"\n\n"), result...)
+	}
+	return format.Source(result)
+}
+
+func benchmarkReprintTransform(b *testing.B, n int) {
+	src := generateSource(n)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		t := NewTransformer(".", WithDecorator("Rename", renameDecorator),
+			WithDecorator("Double", doubleDecorator))
+		if _, err := reprintTransform(t, "p.go", src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReprintTransform100(b *testing.B)  { benchmarkReprintTransform(b, 100) }
+func BenchmarkReprintTransform1000(b *testing.B) { benchmarkReprintTransform(b, 1000) }
@@ -0,0 +1,70 @@
+package transform
+
+import (
+	"context"
+	"path/filepath"
+)
+
+// TransformSource transforms a go source in memory and returns the source
+// of its generated file, along with the diagnostics reported by the
+// attributes. The filename is used to report positions and to resolve the
+// imports of the generated source.
+// No file is read or written: the functions marked with the `decorator`
+// and `method` attributes are not extracted, so the decorators and methods
+// must be registered with the WithDecorator and WithMethod options.
+// The source is returned unchanged when no attribute modifies it.
+func TransformSource(ctx context.Context, filename string, src []byte, opts ...Option) ([]byte, []Diagnostic, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	t := NewTransformer(filepath.Dir(filename), opts...)
+	t.inMemory = true
+
+	out, result, err := t.transformFile(filename, src)
+	if err != nil {
+		return nil, result.Diagnostics, err
+	}
+
+	if out == nil {
+		return src, result.Diagnostics, nil
+	}
+
+	out, err = goImportsSource(filename, out)
+	if err != nil {
+		return nil, result.Diagnostics, err
+	}
+
+	return out, result.Diagnostics, nil
+}
+
+// GeneratedSource transforms the package of the file like Run, and returns
+// the generated source of the file, or its source when no attribute
+// modifies it. The decorators of the package are extracted and built to
+// plugins in the build directory like with Run, but no generated file is
+// written and no source file is modified.
+func GeneratedSource(ctx context.Context, filename string, opts ...Option) ([]byte, error) {
+	filename = filepath.Clean(filename)
+	if isTestFile(filename) {
+		opts = append(opts, WithTests(true))
+	}
+	t := NewTransformer(filepath.Dir(filename), opts...)
+
+	f, _, err := t.prepareFile(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.applyAttributes(f); err != nil {
+		return nil, err
+	}
+
+	src, err := t.generatedSource(f)
+	if err != nil {
+		return nil, err
+	}
+	if src == nil {
+		return f.original, nil
+	}
+
+	return goImportsSource(f.path, src)
+}
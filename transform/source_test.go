@@ -0,0 +1,88 @@
+package transform
+
+import (
+	"context"
+	"go/ast"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransformSource(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "main.go")
+
+	src := `//go:build !generated
+
+package main
+
+// #[Rename(Bar)]
+func Foo() {}
+
+// #[Unknown]
+func Baz() {}
+`
+
+	rename := func(c *TransformContext) error {
+		fn := c.Node().(*ast.FuncDecl)
+		c.Reportf("renaming %s", fn.Name.Name)
+		fn.Name = ast.NewIdent(c.Args()[0])
+		c.Replace(fn)
+		return nil
+	}
+
+	out, diagnostics, err := TransformSource(context.Background(), filename, []byte(src),
+		WithDecorator("Rename", rename), WithVersion("v1.2.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `// Code generated by got v1.2.0 from main.go. DO NOT EDIT.
+
+// Decorators applied:
+//   - Rename(Bar) at main.go:5
+
+//go:build generated
+
+package main
+
+func Bar() {}
+
+func Baz() {}
+`
+	if string(out) != expected {
+		t.Errorf("Expected %s, got %s", expected, out)
+	}
+
+	messages := []string{}
+	for _, d := range diagnostics {
+		messages = append(messages, d.String())
+	}
+	expectedMessages := []string{
+		filename + ":5:1: renaming Foo",
+		filename + ":8:1: unknown attribute `Unknown`",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expectedMessages, "\n") {
+		t.Errorf("Expected %q, got %q", expectedMessages, messages)
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected no file to be written, got %d", len(entries))
+	}
+}
+
+func TestTransformSourceUnchanged(t *testing.T) {
+	src := "package main\n\nfunc main() {}\n"
+
+	out, diagnostics, err := TransformSource(context.Background(), "main.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != src {
+		t.Errorf("Expected %s, got %s", src, out)
+	}
+	if len(diagnostics) != 0 {
+		t.Errorf("Expected no diagnostics, got %v", diagnostics)
+	}
+}
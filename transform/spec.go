@@ -0,0 +1,269 @@
+package transform
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// DecoratorSpec describes the nodes a decorator applies to and its
+// arguments. The usages of a decorator with a spec are validated before the
+// decorator is invoked, so it doesn't have to check its node and its
+// arguments itself.
+//
+// Decorators declare their spec with attributes along the `decorator`
+// attribute:
+//
+//	// #[decorator]
+//	// #[describe(Generates the struct of a JSON schema)]
+//	// #[target(TypeDecl)]
+//	// #[arg(file, string)]
+//	// #[arg(strict, bool, false)]
+//	func FromSchema(c *got.TransformContext) error
+//
+// A decorator with a spec accepts no other arguments than the declared
+// ones, unless it is declared `variadic`.
+type DecoratorSpec struct {
+	// Description is a short description of the decorator.
+	Description string `json:"description,omitempty"`
+
+	// Targets are the kinds of nodes the decorator applies to: the name of
+	// a node type, such as `FuncDecl` or `AssignStmt`, `TypeDecl`,
+	// `VarDecl`, `ConstDecl` or `ImportDecl` for the declarations by their
+	// token, or `Decl`, `Stmt` and `Spec` for any declaration, statement
+	// or spec. Any node is accepted without targets.
+	Targets []string `json:"targets,omitempty"`
+
+	// Args are the arguments of the decorator, in order.
+	Args []ArgSpec `json:"args,omitempty"`
+
+	// Variadic decorators accept any number of arguments after Args,
+	// which are not validated.
+	Variadic bool `json:"variadic,omitempty"`
+}
+
+// ArgSpec describes an argument of a decorator.
+type ArgSpec struct {
+	Name string `json:"name"`
+
+	// Type is the type of the argument: `string`, `int`, `float`, `bool`
+	// or `ident`, for a Go identifier.
+	Type string `json:"type"`
+
+	// Optional arguments are given their Default value when they are
+	// missing. They must follow the required arguments.
+	Optional bool   `json:"optional,omitempty"`
+	Default  string `json:"default,omitempty"`
+}
+
+// String returns the argument as written in an `arg` attribute.
+func (a ArgSpec) String() string {
+	if a.Optional {
+		return fmt.Sprintf("%s %s = %q", a.Name, a.Type, a.Default)
+	}
+	return fmt.Sprintf("%s %s", a.Name, a.Type)
+}
+
+// specAttributes are the builtin attributes declaring the spec of a
+// decorator.
+var specAttributes = map[string]bool{
+	"describe": true,
+	"target":   true,
+	"arg":      true,
+	"variadic": true,
+}
+
+// DecoratorSpecAttribute is the builtin attribute of the `describe`,
+// `target`, `arg` and `variadic` attributes, which are read by the
+// `decorator` attribute of the same function.
+func DecoratorSpecAttribute(c *TransformContext) error {
+	for _, attribute := range c.file.boundAttributes(c.Cursor.Node(), false) {
+		if attribute.Name == "decorator" {
+			return nil
+		}
+	}
+
+	c.Reportf("attribute `%s` only describes a function with the `decorator` attribute", c.attribute)
+	return nil
+}
+
+// parseDecoratorSpec returns the spec declared by the attributes of a
+// decorator, and whether it declares one.
+func parseDecoratorSpec(attributes []Attribute) (DecoratorSpec, bool, error) {
+	spec := DecoratorSpec{}
+	declared := false
+
+	for _, attribute := range attributes {
+		if !specAttributes[attribute.Name] {
+			continue
+		}
+		declared = true
+
+		args := make([]string, len(attribute.Args))
+		for i, arg := range attribute.Args {
+			args[i] = strings.TrimSpace(arg)
+		}
+
+		switch attribute.Name {
+		case "describe":
+			spec.Description = strings.Join(args, ", ")
+
+		case "target":
+			for _, target := range args {
+				if !isTargetKind(target) {
+					return spec, false, fmt.Errorf("%s: unknown target `%s`", attribute.Pos, target)
+				}
+				spec.Targets = append(spec.Targets, target)
+			}
+
+		case "arg":
+			if len(args) < 2 || len(args) > 3 {
+				return spec, false, fmt.Errorf("%s: attribute `arg` expects a name, a type and an optional default value", attribute.Pos)
+			}
+
+			arg := ArgSpec{Name: args[0], Type: args[1], Optional: len(args) == 3}
+			if arg.Optional {
+				arg.Default = args[2]
+			}
+			if err := checkArgType(arg.Type, arg.Default, arg.Optional); err != nil {
+				return spec, false, fmt.Errorf("%s: argument `%s` %v", attribute.Pos, arg.Name, err)
+			}
+			if n := len(spec.Args); n > 0 && spec.Args[n-1].Optional && !arg.Optional {
+				return spec, false, fmt.Errorf("%s: required argument `%s` follows an optional argument", attribute.Pos, arg.Name)
+			}
+			spec.Args = append(spec.Args, arg)
+
+		case "variadic":
+			if len(args) > 0 {
+				return spec, false, fmt.Errorf("%s: attribute `variadic` expects no arguments", attribute.Pos)
+			}
+			spec.Variadic = true
+		}
+	}
+
+	return spec, declared, nil
+}
+
+// validate checks that the decorator can be applied to the node with the
+// arguments, and returns the arguments with the default values of the
+// missing optional arguments. The arguments of a variadic decorator
+// following the declared ones are returned as they are.
+func (s DecoratorSpec) validate(node ast.Node, args []string) ([]string, error) {
+	if len(s.Targets) > 0 && !matchesTargets(node, s.Targets) {
+		return nil, fmt.Errorf("can't be applied to a %s, expected %s", targetKinds(node)[0], strings.Join(s.Targets, " or "))
+	}
+
+	if len(args) > len(s.Args) && !s.Variadic {
+		if len(s.Args) == 0 {
+			return nil, fmt.Errorf("expects no arguments, got %d", len(args))
+		}
+		return nil, fmt.Errorf("expects at most %d arguments, got %d", len(s.Args), len(args))
+	}
+
+	result := append([]string{}, args...)
+	for i, arg := range s.Args {
+		if i >= len(args) {
+			if !arg.Optional {
+				return nil, fmt.Errorf("requires the argument `%s` (%s)", arg.Name, arg.Type)
+			}
+			result = append(result, arg.Default)
+			continue
+		}
+
+		if err := checkArgType(arg.Type, args[i], true); err != nil {
+			return nil, fmt.Errorf("argument `%s` %v", arg.Name, err)
+		}
+	}
+
+	return result, nil
+}
+
+// checkArgType checks that the type of an argument is known, and that the
+// value is of this type, when there is a value.
+func checkArgType(typ, value string, hasValue bool) error {
+	value = strings.TrimSpace(value)
+
+	var err error
+	switch typ {
+	case "string":
+	case "int":
+		if hasValue {
+			_, err = strconv.Atoi(value)
+		}
+	case "float":
+		if hasValue {
+			_, err = strconv.ParseFloat(value, 64)
+		}
+	case "bool":
+		if hasValue {
+			_, err = strconv.ParseBool(value)
+		}
+	case "ident":
+		if hasValue && !token.IsIdentifier(value) {
+			err = fmt.Errorf("not an identifier")
+		}
+	default:
+		return fmt.Errorf("has an unknown type `%s`", typ)
+	}
+
+	if err != nil {
+		return fmt.Errorf("must be of type %s, got `%s`", typ, value)
+	}
+	return nil
+}
+
+// targetKinds returns the kinds of targets matching a node, the most
+// precise first, such as `TypeDecl`, `GenDecl` and `Decl` for a type
+// declaration.
+func targetKinds(node ast.Node) []string {
+	kinds := []string{}
+	if decl, ok := node.(*ast.GenDecl); ok {
+		switch decl.Tok {
+		case token.TYPE:
+			kinds = append(kinds, "TypeDecl")
+		case token.VAR:
+			kinds = append(kinds, "VarDecl")
+		case token.CONST:
+			kinds = append(kinds, "ConstDecl")
+		case token.IMPORT:
+			kinds = append(kinds, "ImportDecl")
+		}
+	}
+	kinds = append(kinds, nodeKind(node))
+
+	switch node.(type) {
+	case ast.Decl:
+		kinds = append(kinds, "Decl")
+	case ast.Stmt:
+		kinds = append(kinds, "Stmt")
+	case ast.Spec:
+		kinds = append(kinds, "Spec")
+	}
+
+	return kinds
+}
+
+// matchesTargets reports whether a node matches one of the targets.
+func matchesTargets(node ast.Node, targets []string) bool {
+	for _, kind := range targetKinds(node) {
+		for _, target := range targets {
+			if kind == target {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isTargetKind reports whether the target is a kind of node attributes can
+// be bound to.
+func isTargetKind(target string) bool {
+	switch target {
+	case "TypeDecl", "VarDecl", "ConstDecl", "ImportDecl", "Decl", "Stmt", "Spec",
+		"FuncDecl", "GenDecl", "Field", "TypeSpec", "ValueSpec", "ImportSpec":
+		return true
+	}
+	return strings.HasSuffix(target, "Stmt")
+}
@@ -0,0 +1,205 @@
+package transform
+
+import (
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestParseDecoratorSpec(t *testing.T) {
+	attributes := []Attribute{
+		{Name: "decorator"},
+		{Name: "describe", Args: []string{"Generates a struct", " from a schema"}},
+		{Name: "target", Args: []string{"TypeDecl", " FuncDecl"}},
+		{Name: "arg", Args: []string{"file", " string"}},
+		{Name: "arg", Args: []string{"strict", " bool", " false"}},
+	}
+
+	spec, ok, err := parseDecoratorSpec(attributes)
+	if err != nil || !ok {
+		t.Fatalf("Expected a spec, got %v", err)
+	}
+
+	if spec.Description != "Generates a struct, from a schema" {
+		t.Errorf("Expected Generates a struct, from a schema, got %s", spec.Description)
+	}
+	if strings.Join(spec.Targets, ",") != "TypeDecl,FuncDecl" {
+		t.Errorf("Expected TypeDecl,FuncDecl, got %s", strings.Join(spec.Targets, ","))
+	}
+
+	args := []string{}
+	for _, arg := range spec.Args {
+		args = append(args, arg.String())
+	}
+	if strings.Join(args, ", ") != `file string, strict bool = "false"` {
+		t.Errorf(`Expected file string, strict bool = "false", got %s`, strings.Join(args, ", "))
+	}
+
+	if spec.Variadic {
+		t.Errorf("Expected the decorator not to be variadic")
+	}
+
+	if _, ok, _ := parseDecoratorSpec([]Attribute{{Name: "decorator"}}); ok {
+		t.Errorf("Expected no spec")
+	}
+
+	spec, ok, err = parseDecoratorSpec([]Attribute{{Name: "decorator"}, {Name: "variadic"}})
+	if err != nil || !ok || !spec.Variadic {
+		t.Errorf("Expected a variadic spec, got %+v, %v", spec, err)
+	}
+}
+
+func testParseDecoratorSpecError(t *testing.T, attributes []Attribute, expected string) {
+	_, _, err := parseDecoratorSpec(attributes)
+	if err == nil || !strings.HasSuffix(err.Error(), expected) {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+}
+
+func TestParseDecoratorSpecErrors(t *testing.T) {
+	testParseDecoratorSpecError(t, []Attribute{{Name: "target", Args: []string{"Expr"}}},
+		"unknown target `Expr`")
+	testParseDecoratorSpecError(t, []Attribute{{Name: "arg", Args: []string{"file"}}},
+		"attribute `arg` expects a name, a type and an optional default value")
+	testParseDecoratorSpecError(t, []Attribute{{Name: "arg", Args: []string{"file", "path"}}},
+		"argument `file` has an unknown type `path`")
+	testParseDecoratorSpecError(t, []Attribute{{Name: "arg", Args: []string{"n", "int", "one"}}},
+		"argument `n` must be of type int, got `one`")
+	testParseDecoratorSpecError(t, []Attribute{
+		{Name: "arg", Args: []string{"n", "int", "1"}},
+		{Name: "arg", Args: []string{"file", "string"}},
+	}, "required argument `file` follows an optional argument")
+	testParseDecoratorSpecError(t, []Attribute{{Name: "variadic", Args: []string{"args"}}},
+		"attribute `variadic` expects no arguments")
+}
+
+func TestDecoratorSpecValidate(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\ntype T int\n\nfunc F() {}\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeDecl, funcDecl := file.Decls[0], file.Decls[1]
+
+	spec := DecoratorSpec{
+		Targets: []string{"TypeDecl"},
+		Args: []ArgSpec{
+			{Name: "name", Type: "ident"},
+			{Name: "count", Type: "int", Optional: true, Default: "1"},
+		},
+	}
+
+	cases := []struct {
+		node     ast.Node
+		args     []string
+		expected string
+	}{
+		{typeDecl, []string{"Kind"}, "Kind,1"},
+		{typeDecl, []string{"Kind", " 2"}, "Kind, 2"},
+		{funcDecl, []string{"Kind"}, "can't be applied to a FuncDecl, expected TypeDecl"},
+		{typeDecl, []string{}, "requires the argument `name` (ident)"},
+		{typeDecl, []string{"Kind", "2", "3"}, "expects at most 2 arguments, got 3"},
+		{typeDecl, []string{"my-kind"}, "argument `name` must be of type ident, got `my-kind`"},
+		{typeDecl, []string{"Kind", "two"}, "argument `count` must be of type int, got `two`"},
+	}
+
+	for _, c := range cases {
+		args, err := spec.validate(c.node, c.args)
+		result := strings.Join(args, ",")
+		if err != nil {
+			result = err.Error()
+		}
+		if result != c.expected {
+			t.Errorf("Expected %s, got %s", c.expected, result)
+		}
+	}
+
+	noArgs := DecoratorSpec{Targets: []string{"TypeDecl"}}
+	variadic := DecoratorSpec{Args: spec.Args, Variadic: true}
+
+	specCases := []struct {
+		spec     DecoratorSpec
+		args     []string
+		expected string
+	}{
+		{noArgs, []string{}, ""},
+		{noArgs, []string{"Kind"}, "expects no arguments, got 1"},
+		{DecoratorSpec{Variadic: true}, []string{"a", "b"}, "a,b"},
+		{variadic, []string{"Kind"}, "Kind,1"},
+		{variadic, []string{"Kind", "2", "x", "y"}, "Kind,2,x,y"},
+		{variadic, []string{"Kind", "two", "x"}, "argument `count` must be of type int, got `two`"},
+	}
+
+	for _, c := range specCases {
+		args, err := c.spec.validate(typeDecl, c.args)
+		result := strings.Join(args, ",")
+		if err != nil {
+			result = err.Error()
+		}
+		if result != c.expected {
+			t.Errorf("Expected %s, got %s", c.expected, result)
+		}
+	}
+}
+
+func TestDecoratorSpecUsage(t *testing.T) {
+	var args []string
+	opts := []Option{
+		WithDecorator("Options", func(c *TransformContext) error {
+			args = c.Args()
+			return nil
+		}),
+		WithDecoratorSpec("Options", DecoratorSpec{
+			Targets: []string{"ConstDecl"},
+			Args: []ArgSpec{
+				{Name: "type", Type: "ident"},
+				{Name: "prefix", Type: "string", Optional: true, Default: "Kind"},
+			},
+		}),
+	}
+
+	src := "package p\n\n// #[Options(Kind)]\nconst (\n\tA = 1\n)\n"
+	if _, _, err := TransformSource(context.Background(), "p.go", []byte(src), opts...); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(args, ",") != "Kind,Kind" {
+		t.Errorf("Expected Kind,Kind, got %s", strings.Join(args, ","))
+	}
+
+	src = "package p\n\n// #[Options(Kind)]\ntype T int\n"
+	_, _, err := TransformSource(context.Background(), "p.go", []byte(src), opts...)
+	expected := "p.go:3:1: attribute `Options` can't be applied to a TypeDecl, expected ConstDecl"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+}
+
+func TestDecoratorSpecAttribute(t *testing.T) {
+	src := "package p\n\n// #[arg(name, string)]\nfunc F() {}\n"
+	_, diagnostics, err := TransformSource(context.Background(), "p.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "p.go:3:1: attribute `arg` only describes a function with the `decorator` attribute"
+	if len(diagnostics) != 1 || diagnostics[0].String() != expected {
+		t.Errorf("Expected %s, got %v", expected, diagnostics)
+	}
+}
+
+func TestDecoratorSpecUnknownAttribute(t *testing.T) {
+	// The spec attributes read the attributes of the decorator without
+	// hiding the unknown ones.
+	src := "package p\n\n// #[decorator]\n// #[describe(Logs the calls)]\n// #[Typo]\nfunc Log(c *TransformContext) error { return nil }\n"
+	_, diagnostics, err := TransformSource(context.Background(), "p.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "p.go:5:1: unknown attribute `Typo`"
+	if len(diagnostics) != 1 || diagnostics[0].String() != expected {
+		t.Errorf("Expected %s, got %v", expected, diagnostics)
+	}
+}
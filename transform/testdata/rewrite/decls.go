@@ -0,0 +1,36 @@
+package p
+
+import "fmt"
+
+// Config is the configuration.
+// #[Model]
+type Config struct {
+	// Name is the name.
+	Name string // the name
+
+	Size int
+}
+
+// F is a function.
+// #[Rename]
+func F() {
+	// x is doubled
+	// #[Double]
+	x := 1
+	fmt.Println(x) // print it
+}
+
+// #[Drop]
+func G() {}
+
+// H is kept.
+func H() {
+	y := 2 // y is kept
+	fmt.Println(y)
+}
+
+// #[Around]
+var v = 1
+
+// I is the last function.
+func I() {}
@@ -0,0 +1,73 @@
+package p
+
+import "fmt"
+
+// F0 is a function.
+// #[Rename]
+func F0() {
+	x := 0
+	fmt.Println(x)
+}
+
+// F1 is a function.
+func F1() {
+	// #[Double]
+	x := 1
+	fmt.Println(x)
+}
+
+// F2 is a function.
+// #[Rename]
+func F2() {
+	x := 2
+	fmt.Println(x)
+}
+
+// F3 is a function.
+func F3() {
+	// #[Double]
+	x := 3
+	fmt.Println(x)
+}
+
+// F4 is a function.
+// #[Rename]
+func F4() {
+	x := 4
+	fmt.Println(x)
+}
+
+// F5 is a function.
+func F5() {
+	// #[Double]
+	x := 5
+	fmt.Println(x)
+}
+
+// F6 is a function.
+// #[Rename]
+func F6() {
+	x := 6
+	fmt.Println(x)
+}
+
+// F7 is a function.
+func F7() {
+	// #[Double]
+	x := 7
+	fmt.Println(x)
+}
+
+// F8 is a function.
+// #[Rename]
+func F8() {
+	x := 8
+	fmt.Println(x)
+}
+
+// F9 is a function.
+func F9() {
+	// #[Double]
+	x := 9
+	fmt.Println(x)
+}
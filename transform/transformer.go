@@ -2,31 +2,101 @@ package transform
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"go/ast"
-	"go/build/constraint"
+	"go/build"
 	"go/parser"
-	"go/printer"
 	"go/token"
-	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"golang.org/x/tools/go/ast/astutil"
 )
 
-// gotTransformer transforms go files that contains got attributes.
+// Transformer transforms go files that contains got attributes.
 // First it scans and applies builtin attributes, extracts the functions and
 // saves them as plugins. Then it applies all remaining attributes.
 // Finally it cleans up the source code.
-type gotTransformer struct {
-	baseDir     string
-	currentFile string
+// Files are transformed in parallel, each step being applied to all the
+// files before the next one, so every extracted function is available to
+// all the files. The decorators and methods declared in test files are only
+// available to other test files.
+type Transformer struct {
+	baseDir      string
+	includeTests bool
+	buildContext build.Context
+	buildDir     string
+	fileFilter   func(path string) bool
+	sources      map[string][]byte
+	logger       Logger
+	parallelism  int
+	inMemory     bool
+
+	// prebuilt is set when the plugins of the extracted functions must
+	// have been built by a previous run: nothing is extracted or built.
+	prebuilt bool
+
+	// version is the version of got written in the header of the
+	// generated files.
+	version string
+
+	constraintMode ConstraintMode
+
+	// timeout is the time a decorator or a finalizer call may take, and
+	// timeouts the timeouts of the decorators and finalizers set by name.
+	timeout  time.Duration
+	timeouts map[string]time.Duration
+
+	// registered are the functions and specs registered with options,
+	// which each run starts with.
+	registered registeredFunctions
+
+	// mu guards the fields below, shared by the files transformed in
+	// parallel.
+	mu      sync.Mutex
+	overlay map[string]string
+	builds  map[string]*pluginBuild
 
 	methods    map[string]ExtractedMethod
 	decorators map[string]ExtractedDecorator
+
+	testMethods    map[string]ExtractedMethod
+	testDecorators map[string]ExtractedDecorator
+
+	// specs and testSpecs are the specs of the decorators, see
+	// DecoratorSpec.
+	specs     map[string]DecoratorSpec
+	testSpecs map[string]DecoratorSpec
+
+	finalizers map[string]ExtractedFinalizer
+
+	// store is shared by the decorators and the finalizers of a run.
+	store *Store
+
+	// names are the names of the package scope and the fresh names
+	// returned to the decorators. dotImports are the packages imported
+	// with a dot, and whether their names were loaded.
+	names      map[string]bool
+	dotImports map[string]bool
+}
+
+// registeredFunctions are the functions and specs registered with options.
+type registeredFunctions struct {
+	methods        map[string]ExtractedMethod
+	decorators     map[string]ExtractedDecorator
+	testMethods    map[string]ExtractedMethod
+	testDecorators map[string]ExtractedDecorator
+	specs          map[string]DecoratorSpec
+	testSpecs      map[string]DecoratorSpec
+	finalizers     map[string]ExtractedFinalizer
 }
 
 // ExtractedMethod is a function signature for a extracted method.
@@ -35,316 +105,949 @@ type ExtractedMethod = func(...interface{}) interface{}
 // ExtractedDecorator is a function signature for a extracted decorator.
 type ExtractedDecorator = func(c *TransformContext) (err error)
 
-// GotTransform creates a new gotTransformer.
-func GotTransform(baseDir string) *gotTransformer {
-	return &gotTransformer{
-		baseDir:     baseDir,
-		currentFile: "",
+// ExtractedFinalizer is a function signature for a extracted finalizer.
+type ExtractedFinalizer = func(c *FinalizeContext) (err error)
+
+// Result is the result of a transformer run.
+type Result struct {
+	// Files are the transformed source files, in the order they were
+	// found. Files without any attribute are not included.
+	Files []FileResult
+
+	// Finalizers are the finalizers called once the files were
+	// transformed, in order.
+	Finalizers []FinalizerResult
+
+	// Overlay maps the absolute paths of the source files to their
+	// generated files when using the overlay constraints mode.
+	Overlay map[string]string
+}
+
+// FinalizerResult is the result of a finalizer.
+type FinalizerResult struct {
+	Name string
+
+	// Generated is the path of the written generated file, or empty when
+	// the finalizer added no declaration.
+	Generated string
+
+	// Output is the generated source when transforming in memory, in
+	// which case nothing is written to Generated.
+	Output []byte
+}
+
+// FileResult is the result of the transformation of a source file.
+type FileResult struct {
+	// Source is the path of the source file.
+	Source string
+
+	// Generated is the path of the written generated file, or empty when
+	// the file was not modified by its attributes.
+	Generated string
+
+	// Output is the generated source when transforming in memory, in
+	// which case nothing is written to Generated.
+	Output []byte
+
+	// Attributes are the attributes applied to the file.
+	Attributes []AppliedAttribute
+
+	// Diagnostics are the diagnostics reported while transforming the file.
+	Diagnostics []Diagnostic
+}
+
+// AppliedAttribute is an attribute applied to a source file.
+type AppliedAttribute struct {
+	Name string
+	Args []string
+
+	// Pos is the position of the attribute comment.
+	Pos token.Position
+}
+
+// Diagnostic is a message about a source file reported by the transformer
+// or by a decorator. Diagnostics don't stop the transformation.
+type Diagnostic struct {
+	Pos     token.Position
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
+
+// NewTransformer creates a new Transformer for the package in the base
+// directory, configured by the given options.
+func NewTransformer(baseDir string, opts ...Option) *Transformer {
+	t := &Transformer{
+		baseDir:      baseDir,
+		buildContext: build.Default,
+		buildDir:     GOT_BUILD_DIR,
+		parallelism:  runtime.GOMAXPROCS(0),
+		version:      GOT_DEFAULT_VERSION,
+		timeout:      GOT_DEFAULT_TIMEOUT,
+		timeouts:     map[string]time.Duration{},
 
 		methods:    map[string]ExtractedMethod{},
 		decorators: map[string]ExtractedDecorator{},
+
+		testMethods:    map[string]ExtractedMethod{},
+		testDecorators: map[string]ExtractedDecorator{},
+
+		specs:     map[string]DecoratorSpec{},
+		testSpecs: map[string]DecoratorSpec{},
+
+		finalizers: map[string]ExtractedFinalizer{},
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	t.registered = registeredFunctions{
+		methods:        t.methods,
+		decorators:     t.decorators,
+		testMethods:    t.testMethods,
+		testDecorators: t.testDecorators,
+		specs:          t.specs,
+		testSpecs:      t.testSpecs,
+		finalizers:     t.finalizers,
 	}
+	t.reset()
+
+	return t
 }
 
-// Execute lookup all go files in the base directory and transforms them.
-func (t *gotTransformer) Execute() error {
-	targetFiles := LookupGoFiles(t.baseDir)
-	for _, path := range targetFiles {
-		err := t.executeFile(path)
-		if err != nil {
-			return fmt.Errorf("Failed to transform file `%s`: \n\t%v",
-				path, err)
-		}
+// reset clears the state of the previous run: the functions extracted
+// from the sources, the plugin builds, the store, the names of the package
+// and the overlay. Only the functions registered with options are kept.
+func (t *Transformer) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.overlay = map[string]string{}
+	t.builds = map[string]*pluginBuild{}
+	t.store = newStore()
+
+	t.methods = copyMap(t.registered.methods)
+	t.decorators = copyMap(t.registered.decorators)
+	t.testMethods = copyMap(t.registered.testMethods)
+	t.testDecorators = copyMap(t.registered.testDecorators)
+	t.specs = copyMap(t.registered.specs)
+	t.testSpecs = copyMap(t.registered.testSpecs)
+	t.finalizers = copyMap(t.registered.finalizers)
+
+	t.names = map[string]bool{}
+	t.dotImports = map[string]bool{}
+}
+
+// copyMap returns a copy of the map.
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
 	}
+	return c
+}
 
-	return nil
+// GotTransform creates a new Transformer with the default options.
+func GotTransform(baseDir string) *Transformer {
+	return NewTransformer(baseDir)
 }
 
-// executeFile transforms a single go file.
-// This is the main function of the gotExtractor.
-// It reads the file, extracts the attributes, applies the builtin attributes,
-// extracts the functions and saves them as plugins.
-func (t *gotTransformer) executeFile(path string) error {
-	t.currentFile = path
+// Execute transforms the package in the base directory.
+// It is the same as Run, ignoring the result.
+func (t *Transformer) Execute() error {
+	_, err := t.Run(context.Background())
+	return err
+}
 
-	f, err := os.Open(path)
+// Run lookup all go files of the package in the base directory and
+// transforms them. Test files are only transformed when tests are
+// included.
+// The files are read and their builtin attributes applied, then the
+// extracted functions are loaded, and finally the remaining attributes are
+// applied and the generated files written. Each of these steps transforms
+// the files in parallel. With WithInMemory, the generated sources are
+// returned in the result instead of being written.
+// The context is checked before transforming each file.
+// Each run starts from the options of the transformer: nothing is kept
+// from the previous runs.
+func (t *Transformer) Run(ctx context.Context) (*Result, error) {
+	t.reset()
+	result := &Result{Overlay: t.overlay}
+
+	paths, err := t.packageFiles()
 	if err != nil {
-		println(fmt.Errorf("Failed to open file: %s", err))
-		os.Exit(1)
+		return result, err
 	}
-	defer f.Close()
 
-	srcBytes, err := io.ReadAll(f)
+	files, err := t.prepareFiles(ctx, paths)
 	if err != nil {
-		println(fmt.Errorf("Failed to read file: %s", err))
-		os.Exit(1)
+		return result, err
 	}
 
-	exportedDecorators = []string{}
-	exportedMethods = []string{}
+	// The fresh names of a file depend on the names declared by the files
+	// before it, see FreshName.
+	for i, f := range files {
+		f.earlier = files[:i]
+		f.done = make(chan struct{})
+	}
 
-	src := bytes.NewBuffer(srcBytes)
+	err = t.forEach(ctx, paths, func(i int, path string) error {
+		defer t.reserveFreshNames(files[i])
+		return t.executeFile(files[i])
+	})
+	if err == nil {
+		// The attributes of a file may be queried by the decorators of
+		// the other files until all of them are transformed.
+		for _, f := range files {
+			t.reportUnapplied(f)
+		}
+		result.Finalizers, err = t.runFinalizers(files)
+	}
 
-	usages, err := extractAttributeUsages(src)
-	if err != nil {
-		return err
+	for _, f := range files {
+		if f == nil {
+			continue
+		}
+
+		file := f.result()
+		if file.Generated != "" || len(file.Attributes) > 0 || len(file.Diagnostics) > 0 {
+			result.Files = append(result.Files, file)
+		}
 	}
 
-	var isModified bool
-	if len(usages) > 0 {
-		t.log("Applying builtin only attributes...")
+	return result, err
+}
 
-		var isMod bool
-		if isMod, err = t.processAttributeTransforms(src, &usages, true); err != nil {
-			return err
+// packageFiles returns the paths of the go files of the package to
+// transform.
+func (t *Transformer) packageFiles() ([]string, error) {
+	targetFiles, err := LookupGoFiles(&t.buildContext, t.baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to lookup files in `%s`: %v", t.baseDir, err)
+	}
+
+	paths := []string{}
+	for _, path := range targetFiles {
+		if t.fileFilter != nil && !t.fileFilter(path) {
+			continue
+		}
+		if isTestFile(path) && !t.includeTests {
+			continue
 		}
 
-		if isMod {
-			isModified = true
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// prepareFiles reads the files and applies their builtin attributes in
+// parallel, then loads the functions they extracted. The names of the
+// other files of the package are declared first.
+func (t *Transformer) prepareFiles(ctx context.Context, paths []string) ([]*fileTransform, error) {
+	if err := t.declarePackageNames(paths); err != nil {
+		return nil, err
+	}
+
+	files := make([]*fileTransform, len(paths))
+	err := t.forEach(ctx, paths, func(i int, path string) error {
+		srcBytes, err := t.readSource(path)
+		if err != nil {
+			return fmt.Errorf("Failed to read file: %v", err)
 		}
 
-		err = t.loadExtractedFunctions()
+		files[i], err = t.newFileTransform(path, srcBytes)
 		if err != nil {
 			return err
 		}
 
-		t.log("Applying all remaining attributes...")
-		if isMod, err = t.processAttributeTransforms(src, &usages, false); err != nil {
-			return err
+		return t.applyBuiltinAttributes(files[i])
+	})
+	if err != nil {
+		return files, err
+	}
+
+	for _, f := range files {
+		f.pkg = files
+	}
+	return files, t.loadExtractedFunctions(files)
+}
+
+// readSource returns the content of a file of the package, set with
+// WithSources or read from the disk.
+func (t *Transformer) readSource(path string) ([]byte, error) {
+	if src, ok := t.sources[path]; ok {
+		return src, nil
+	}
+	return os.ReadFile(path)
+}
+
+// forEach calls fn for each path, using up to parallelism goroutines.
+// Once a call fails, the paths left are skipped, and the error of the
+// first path failing is returned.
+func (t *Transformer) forEach(ctx context.Context, paths []string, fn func(i int, path string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := t.parallelism
+	if workers < 1 {
+		workers = 1
+	}
+
+	errs := make([]error, len(paths))
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+
+	for w := 0; w < workers && w < len(paths); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range indexes {
+				if err := fn(i, paths[i]); err != nil {
+					errs[i] = fmt.Errorf("Failed to transform file `%s`: \n\t%v",
+						paths[i], err)
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for i := range paths {
+		if ctx.Err() != nil {
+			break
 		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
 
-		if isMod {
-			isModified = true
+	for _, err := range errs {
+		if err != nil {
+			return err
 		}
 	}
 
-	if !isModified {
-		return nil
+	return ctx.Err()
+}
+
+// executeFile applies the remaining attributes to a file and writes its
+// generated file.
+// When no attribute modifies the source, the generated file previously
+// written for the file is removed. When transforming in memory, the
+// generated source is kept instead.
+func (t *Transformer) executeFile(f *fileTransform) error {
+	if err := t.applyAttributes(f); err != nil {
+		return err
 	}
 
-	t.log("Cleaning up...")
-	err = cleanupSource(src)
+	src, err := t.generatedSource(f)
 	if err != nil {
 		return err
 	}
 
-	if bytes.Equal(src.Bytes(), srcBytes) {
-		t.log("No changes detected. Skipping...")
-		return nil
+	path := f.path
+	goFile := generatedFileName(path)
+
+	if t.inMemory {
+		if src == nil {
+			return nil
+		}
+		f.generated = goFile
+		f.output, err = goImportsSource(path, src)
+		return err
+	}
+
+	if src == nil {
+		return t.removeStaleFile(goFile)
+	}
+
+	if t.constraintMode == ConstraintsOverlay {
+		if err := t.removeStaleFile(goFile); err != nil {
+			return err
+		}
+
+		goFile = filepath.Join(t.buildDir, GOT_OVERLAY_DIR, goFile)
+		if err := os.MkdirAll(filepath.Dir(goFile), 0755); err != nil {
+			return err
+		}
+	} else if err := t.updateSourceConstraint(f); err != nil {
+		return err
 	}
 
-	goFile := strings.Replace(path, GO_FILE_EXTENSION, "_generated.go", 1)
-	t.log("Writing to file:", goFile)
-	err = os.WriteFile(goFile, src.Bytes(), 0644)
+	f.log("Writing to file:", goFile)
+	err = writeGeneratedFile(goFile, src)
 	if err != nil {
 		return err
 	}
+	f.generated = goFile
 
-	t.log("Executing goimports on file")
+	f.log("Executing goimports on file")
 	err = executeGoImports(goFile)
 	if err != nil {
 		return err
 	}
 
+	if t.constraintMode == ConstraintsOverlay {
+		source, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		generated, err := filepath.Abs(goFile)
+		if err != nil {
+			return err
+		}
+
+		t.mu.Lock()
+		t.overlay[source] = generated
+		t.mu.Unlock()
+	}
+
 	return nil
 }
 
-func (t *gotTransformer) applyTemplate(src *bytes.Buffer) error {
-	result := bytes.NewBuffer([]byte{})
-	fns := template.FuncMap{}
-	for name, method := range t.methods {
-		fns[name] = method
-	}
+// fileTransform is the state of the transformation of a single file.
+type fileTransform struct {
+	transformer *Transformer
+
+	path      string
+	original  []byte
+	fset      *token.FileSet
+	file      *ast.File
+	layout    *fileLayout
+	usages    []*attributesUsage
+	bindings  map[ast.Node][]*attributesUsage
+	modified  bool
+	generated string
+	output    []byte
+
+	// pkg are the files of the package, when transformed with Run or
+	// explained, whose attributes are found by FindAnnotated.
+	pkg []*fileTransform
+
+	// fresh are the fresh names returned to the decorators of the file.
+	// earlier are the files of the package before it, when transformed
+	// with Run, and done is closed once the file is transformed.
+	fresh   map[string]bool
+	earlier []*fileTransform
+	done    chan struct{}
+
+	applied     []AppliedAttribute
+	diagnostics []Diagnostic
+
+	// exportedMethods, exportedDecorators and exportedFinalizers are the
+	// functions extracted from the file by the builtin attributes.
+	exportedMethods    []string
+	exportedDecorators []string
+	exportedFinalizers []string
+}
 
-	tpl, err := template.New("").Funcs(fns).Parse(src.String())
+// newFileTransform starts the transformation of a go source. The source is
+// parsed, the only time, and its attributes are bound to their nodes.
+func (t *Transformer) newFileTransform(path string, srcBytes []byte) (*fileTransform, error) {
+	f, diagnostics, err := t.parseFile(path, srcBytes)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if len(diagnostics) > 0 {
+		return nil, errors.New(diagnostics[0].String())
 	}
 
-	err = tpl.Execute(result, nil)
+	f.layout = newFileLayout(f.fset, f.file, srcBytes)
+	f.fresh = map[string]bool{}
+	t.declareNames(f.file)
+	return f, nil
+}
+
+// parseFile parses a go source and binds its attributes. The attribute
+// comments which can't be parsed and the attributes which can't be bound
+// to a node are left out, and returned as diagnostics, in the order of the
+// file.
+// When the source is only partially parsed, the file is returned along with
+// the error, so that its attributes can still be analyzed.
+func (t *Transformer) parseFile(path string, srcBytes []byte) (*fileTransform, []Diagnostic, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, srcBytes, parser.ParseComments)
 	if err != nil {
-		return err
+		err = fmt.Errorf("Failed to parse file: %v", err)
+	}
+	if file == nil {
+		return nil, nil, err
 	}
 
-	src.Reset()
-	_, _ = src.Write(result.Bytes())
+	usages, diagnostics := attributeUsages(fset, file)
+	bindings, unbound := bindAttributes(fset, file, usages)
+	diagnostics = append(diagnostics, unbound...)
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Pos.Offset < diagnostics[j].Pos.Offset
+	})
 
-	return nil
+	bound := []*attributesUsage{}
+	for _, usage := range usages {
+		if usage.node != nil {
+			bound = append(bound, usage)
+		}
+	}
+
+	return &fileTransform{
+		transformer: t,
+		path:        path,
+		original:    srcBytes,
+		fset:        fset,
+		file:        file,
+		usages:      bound,
+		bindings:    bindings,
+	}, diagnostics, err
 }
 
-func (t *gotTransformer) loadExtractedFunctions() error {
-	for _, methodName := range exportedMethods {
-		fn, err := loadExtractedFunction[ExtractedMethod](filepath.Join(t.baseDir, GOT_BUILD_DIR, GOT_METHODS_DIR, fmt.Sprintf("%s.so", methodName)))
-		if err != nil {
-			log(fmt.Sprintf("Failed to load method `%s`: %s", methodName, err))
-		}
-		t.log("Extracted method:", methodName)
-		t.methods[methodName] = fn
+// transformFile applies the attributes of a go source and returns the
+// cleaned up source of its generated file, or nil when no attribute
+// modified the source.
+// It extracts the attributes, applies the builtin attributes, extracts the
+// functions and saves them as plugins. Then it applies all the remaining
+// attributes.
+func (t *Transformer) transformFile(path string, srcBytes []byte) ([]byte, FileResult, error) {
+	f, err := t.newFileTransform(path, srcBytes)
+	if err != nil {
+		return nil, FileResult{Source: path}, err
 	}
 
-	for _, decoratorName := range exportedDecorators {
-		fn, err := loadExtractedFunction[ExtractedDecorator](
-			filepath.Join(t.baseDir, GOT_BUILD_DIR, GOT_DECORATORS_DIR,
-				fmt.Sprintf("%s.so", decoratorName)))
-		if err != nil {
-			log(fmt.Sprintf("Failed to load decorator `%s`: %s", decoratorName, err))
-			return err
+	if err := t.applyBuiltinAttributes(f); err != nil {
+		return nil, f.result(), err
+	}
+
+	if !t.inMemory {
+		if err := t.loadExtractedFunctions([]*fileTransform{f}); err != nil {
+			return nil, f.result(), err
 		}
-		t.log("Extracted decorator:", decoratorName)
-		t.decorators[decoratorName] = fn
 	}
 
+	if err := t.applyAttributes(f); err != nil {
+		return nil, f.result(), err
+	}
+
+	out, err := t.generatedSource(f)
+	return out, f.result(), err
+}
+
+// applyBuiltinAttributes applies the builtin attributes of the file,
+// extracting the functions declared by the file.
+func (t *Transformer) applyBuiltinAttributes(f *fileTransform) error {
+	if len(f.usages) == 0 {
+		return nil
+	}
+
+	f.log("Applying builtin only attributes...")
+	isMod, err := t.processAttributeTransforms(f, true)
+	if isMod {
+		f.modified = true
+	}
+
+	return err
+}
+
+// applyAttributes applies all the remaining attributes of the file and
+// reports the attributes which were not applied.
+func (t *Transformer) applyAttributes(f *fileTransform) error {
+	if len(f.usages) == 0 {
+		return nil
+	}
+
+	f.log("Applying all remaining attributes...")
+	isMod, err := t.processAttributeTransforms(f, false)
+	if isMod {
+		f.modified = true
+	}
+	if err != nil {
+		return err
+	}
+
+	// The unapplied attributes of the files of a package are reported
+	// once all of them are transformed, see Run.
+	if f.pkg == nil {
+		t.reportUnapplied(f)
+	}
 	return nil
 }
 
-func (t *gotTransformer) processAttributeTransforms(
-	src *bytes.Buffer,
-	usages *[]*attributesUsage,
-	builtinOnly bool,
-) (isModified bool, err error) {
-	if len(*usages) == 0 {
-		return false, nil
+// generatedSource returns the cleaned up source of the generated file, or
+// nil when no attribute modified the source.
+func (t *Transformer) generatedSource(f *fileTransform) ([]byte, error) {
+	if !f.modified {
+		return nil, nil
 	}
 
-	fset := token.NewFileSet()
-	pfile, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	f.log("Cleaning up...")
+	src, err := printGeneratedSource(f, t.constraintMode != ConstraintsOverlay)
 	if err != nil {
-		return false, fmt.Errorf("Failed to parse file: %v", err)
+		return nil, err
 	}
 
-	buf := make([]byte, src.Len())
-	copy(buf, src.Bytes())
-	updatedSrc := bytes.NewBuffer(buf)
+	if bytes.Equal(src, f.original) {
+		f.log("No changes detected. Skipping...")
+		return nil, nil
+	}
+
+	return append([]byte(f.header()), src...), nil
+}
 
-	srcOffset := 0
-	process := func(c *astutil.Cursor, usage *attributesUsage) error {
-		originalLength := c.Node().End() - c.Node().Pos()
-		pos := int(c.Node().Pos()) - 1 - srcOffset
+// header returns the header of the generated file: the generated code
+// comment, followed by the decorators applied to the source file.
+func (f *fileTransform) header() string {
+	header := generatedHeader(f.transformer.version, filepath.Base(f.path))
 
-		context := &TransformContext{
-			Cursor:      c,
-			currentNode: c.Node(),
-			File:        pfile,
-			fileSrc:     src.Bytes(),
+	decorators := []string{}
+	for _, applied := range f.applied {
+		if _, ok := BuiltinAttributes[applied.Name]; ok {
+			continue
 		}
 
-		for _, attribute := range usage.attributes {
-			context.args = attribute.Arguments
-			attributeName := attribute.Name
-
-			if handler, ok := BuiltinAttributes[attributeName]; ok {
-				t.log(fmt.Sprintf(
-					"Executing builtin attribute: `%s` on position %d",
-					attributeName, pos))
-				err := handler(context)
-				if err != nil {
-					return fmt.Errorf("Failed to execute decorator `%s`: %v", attributeName, err)
-				}
-				usage.isApplied = true
-			}
+		decorator := applied.Name
+		if len(applied.Args) > 0 {
+			decorator += "(" + strings.Join(applied.Args, ",") + ")"
+		}
+		decorators = append(decorators, fmt.Sprintf("//   - %s at %s:%d\n",
+			decorator, filepath.Base(applied.Pos.Filename), applied.Pos.Line))
+	}
+	if len(decorators) == 0 {
+		return header
+	}
 
-			if !builtinOnly {
-				if handler, ok := t.decorators[attributeName]; ok {
-					t.log(fmt.Sprintf("Executing decorator: `%s` on position %d", attributeName, pos))
-					err := handler(context)
-					if err != nil {
-						return fmt.Errorf("Failed to execute decorator `%s`: %v", attributeName, err)
-					}
-					usage.isApplied = true
-				}
-			}
+	return header + "// Decorators applied:\n" + strings.Join(decorators, "") + "\n"
+}
+
+// generatedHeader returns the generated code comment of a file generated
+// from the source, followed by a blank line.
+func generatedHeader(version, source string) string {
+	return fmt.Sprintf("%s%s from %s%s\n\n", GOT_GENERATED_PREFIX, version, source, GOT_GENERATED_SUFFIX)
+}
+
+// hasGeneratedHeader reports whether a source starts with the generated
+// code comment of got, before its package clause.
+func hasGeneratedHeader(src []byte) bool {
+	_, ok := generatedFrom(src)
+	return ok
+}
+
+// generatedFrom returns the source named by the generated code comment of
+// got starting a source, like `main.go` or `finalizer Routes`, and whether
+// the source starts with the comment.
+func generatedFrom(src []byte) (string, bool) {
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, GOT_GENERATED_PREFIX) && strings.HasSuffix(line, GOT_GENERATED_SUFFIX) {
+			header := strings.TrimSuffix(strings.TrimPrefix(line, GOT_GENERATED_PREFIX), GOT_GENERATED_SUFFIX)
+			_, from, _ := strings.Cut(header, " from ")
+			return from, true
 		}
+		if line != "" && !strings.HasPrefix(line, SINGLE_COMMENT) {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// writeGeneratedFile writes a generated file. A file without the generated
+// code comment of got is never overwritten, since it may have been written
+// or edited by hand.
+func writeGeneratedFile(goFile string, src []byte) error {
+	existing, err := os.ReadFile(goFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && !hasGeneratedHeader(existing) {
+		return fmt.Errorf("Refusing to overwrite `%s`: it has no `%s` header, remove it to generate it again",
+			goFile, strings.TrimSpace(GOT_GENERATED_PREFIX))
+	}
 
-		if context.modified {
-			t.log(fmt.Sprintf("Attribute `%s` modified source", usage.attributes[0].Name), "")
-			updatedNode := bytes.NewBuffer([]byte{})
-			printer.Fprint(updatedNode, fset, context.currentNode)
-			srcOffset += updatedNode.Len() - int(originalLength)
+	return os.WriteFile(goFile, src, 0644)
+}
 
-			updatedSrc.Reset()
-			err := printer.Fprint(updatedSrc, fset, pfile)
-			if err != nil {
-				return fmt.Errorf("Failed to update source: %v", err)
+// result returns the result of the file transformation.
+func (f *fileTransform) result() FileResult {
+	return FileResult{
+		Source:      f.path,
+		Generated:   f.generated,
+		Output:      f.output,
+		Attributes:  f.applied,
+		Diagnostics: f.diagnostics,
+	}
+}
+
+// reportUnapplied reports the attributes which were not applied, either
+// because they are unknown or because they are not attached to any
+// declaration or statement.
+func (t *Transformer) reportUnapplied(f *fileTransform) {
+	for _, usage := range f.usages {
+		for i, attribute := range usage.attributes {
+			if usage.done[i] {
+				continue
 			}
 
-			isModified = true
+			// The attributes queried by a decorator are markers, such as
+			// the attributes of struct fields.
+			_, isDecorator := t.decorator(f.path, attribute.Name)
+			if usage.queried[i] && !isDecorator {
+				continue
+			}
+
+			_, isBuiltin := BuiltinAttributes[attribute.Name]
+			if isDecorator || isBuiltin {
+				f.report(usage.position, "attribute `%s` is not attached to any declaration or statement", attribute.Name)
+			} else {
+				f.report(usage.position, "unknown attribute `%s`", attribute.Name)
+			}
 		}
+	}
+}
+
+// report records a diagnostic of the file.
+func (f *fileTransform) report(pos token.Position, format string, args ...interface{}) {
+	diagnostic := Diagnostic{Pos: pos, Message: fmt.Sprintf(format, args...)}
+	f.log(diagnostic.Message)
+	f.diagnostics = append(f.diagnostics, diagnostic)
+}
+
+// log sends a message about the file to the transformer logger.
+func (f *fileTransform) log(args ...interface{}) {
+	f.transformer.log(append([]interface{}{f.path + ":"}, args...)...)
+}
+
+// updateSourceConstraint makes sure the source file is not compiled with its
+// generated file, by checking its build constraint excludes the generated
+// tag. When the constraint is missing and using the auto constraints mode,
+// the `!generated` constraint is added to the source file.
+func (t *Transformer) updateSourceConstraint(f *fileTransform) error {
+	path, src := f.path, f.original
+
+	expr, err := parseFileConstraint(src)
+	if err != nil {
+		return err
+	}
+
+	ok, err := checkSourceConstraint(path, expr)
+	if err != nil || ok {
+		return err
+	}
+
+	if t.constraintMode == ConstraintsVerify {
+		return fmt.Errorf(
+			"%s: missing `//go:build !%s` constraint, required by its generated file `%s`",
+			path, GENERATED_TAG, filepath.Base(generatedFileName(path)))
+	}
+
+	f.log(fmt.Sprintf("Adding `!%s` build constraint to source", GENERATED_TAG))
+	updated, err := addSourceConstraint(src, expr)
+	if err != nil {
+		return err
+	}
 
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, updated, info.Mode().Perm())
+}
+
+// removeStaleFile removes a file previously generated from the file which
+// is not generated anymore.
+// Files without the generated code comment of got or not requiring the
+// generated tag were not generated by got, or were edited by hand, and are
+// kept.
+func (t *Transformer) removeStaleFile(goFile string) error {
+	src, err := os.ReadFile(goFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !hasGeneratedHeader(src) {
 		return nil
 	}
 
-	var processErr error
+	expr, err := parseFileConstraint(src)
+	if err != nil || expr == nil {
+		return nil
+	}
+	if !mentionsTag(expr, hasGeneratedTag) || excludesTag(expr, hasGeneratedTag) {
+		return nil
+	}
 
-	for _, usage := range *usages {
-		if usage.isApplied {
-			continue
+	t.log("Removing stale generated file:", goFile)
+	return os.Remove(goFile)
+}
+
+// generatedFileName returns the name of the file generated from the given
+// source file. Test files keep their `_test.go` suffix.
+func generatedFileName(path string) string {
+	if isTestFile(path) {
+		return strings.TrimSuffix(path, "_test"+GO_FILE_EXTENSION) +
+			"_generated_test" + GO_FILE_EXTENSION
+	}
+
+	return strings.TrimSuffix(path, GO_FILE_EXTENSION) + "_generated" + GO_FILE_EXTENSION
+}
+
+// isTestFile reports whether the file is a go test file.
+func isTestFile(path string) bool {
+	return strings.HasSuffix(path, "_test"+GO_FILE_EXTENSION)
+}
+
+// decorator returns the decorator with the given name available to the
+// file. Decorators declared in test files are only available to test files.
+func (t *Transformer) decorator(path, name string) (ExtractedDecorator, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if isTestFile(path) {
+		if fn, ok := t.testDecorators[name]; ok {
+			return fn, true
 		}
+	}
 
-		astutil.Apply(pfile, nil, func(c *astutil.Cursor) bool {
-			n := c.Node()
-			if n == nil {
-				return true
-			}
+	fn, ok := t.decorators[name]
+	return fn, ok
+}
 
-			node := c.Node()
+// callTimeout returns the time the call of the decorator or finalizer with
+// the given name may take, zero meaning it has no limit.
+func (t *Transformer) callTimeout(name string) time.Duration {
+	if timeout, ok := t.timeouts[name]; ok {
+		return timeout
+	}
+	return t.timeout
+}
 
-			nodePos := int(node.Pos()) - 1 - srcOffset
-			endPos := int(node.End()) - 1 - srcOffset
+// decoratorSpec returns the spec of the decorator with the given name
+// available to the file, like decorator.
+func (t *Transformer) decoratorSpec(path, name string) (DecoratorSpec, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-			var name string
-			switch v := node.(type) {
-			case *ast.GenDecl:
-				switch v.Tok {
-				case token.TYPE:
-					name = v.Specs[0].(*ast.TypeSpec).Name.Name
-				default:
-				}
+	if isTestFile(path) {
+		if spec, ok := t.testSpecs[name]; ok {
+			return spec, true
+		}
+		if _, ok := t.testDecorators[name]; ok {
+			return DecoratorSpec{}, false
+		}
+	}
 
-			case *ast.FuncDecl:
-				name = v.Name.Name
+	spec, ok := t.specs[name]
+	return spec, ok
+}
 
-				// Change endPos to the start of the function body
-				endPos = int(v.Body.Lbrace) - 1 - srcOffset
+// setDecoratorSpec records the spec of a decorator declared by a file.
+func (t *Transformer) setDecoratorSpec(path, name string, spec DecoratorSpec) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-			case *ast.AssignStmt, *ast.IfStmt:
+	if isTestFile(path) {
+		t.testSpecs[name] = spec
+	} else {
+		t.specs[name] = spec
+	}
+}
+
+func (t *Transformer) applyTemplate(f *fileTransform, src *bytes.Buffer) error {
+	result := bytes.NewBuffer([]byte{})
+	fns := template.FuncMap{}
+
+	t.mu.Lock()
+	for name, method := range t.methods {
+		fns[name] = method
+	}
+	if isTestFile(f.path) {
+		for name, method := range t.testMethods {
+			fns[name] = method
+		}
+	}
+	t.mu.Unlock()
+
+	tpl, err := template.New("").Funcs(fns).Parse(src.String())
+	if err != nil {
+		return err
+	}
+
+	err = tpl.Execute(result, nil)
+	if err != nil {
+		return err
+	}
+
+	src.Reset()
+	_, _ = src.Write(result.Bytes())
 
-			default:
-				return true
+	return nil
+}
+
+// loadExtractedFunctions loads the plugins of the functions extracted from
+// the files. Functions declared in test files are only available to test
+// files, and the functions declared by several files are loaded once.
+func (t *Transformer) loadExtractedFunctions(files []*fileTransform) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, f := range files {
+		methods, decorators := t.methods, t.decorators
+		if isTestFile(f.path) {
+			methods, decorators = t.testMethods, t.testDecorators
+		}
+
+		for _, methodName := range f.exportedMethods {
+			if _, ok := methods[methodName]; ok {
+				continue
 			}
 
-			_ = name
+			fn, err := loadExtractedFunction[ExtractedMethod](
+				filepath.Join(t.pluginDir(), kindDir(GOT_METHODS_DIR, f.path),
+					fmt.Sprintf("%s.so", methodName)))
+			if err != nil {
+				return fmt.Errorf("Failed to load method `%s`: %v", methodName, err)
+			}
+			f.log("Extracted method:", methodName)
+			methods[methodName] = fn
+		}
 
-			if nodePos > usage.commentPos {
-				separation := string(src.Bytes()[usage.commentPos:nodePos])
-				separation = strings.Replace(separation, "\n", "", 1)
-				separation = strings.TrimSpace(separation)
-				if separation != "" {
-					return true
-				}
+		for _, decoratorName := range f.exportedDecorators {
+			if _, ok := decorators[decoratorName]; ok {
+				continue
 			}
 
-			if usage.commentPos > endPos {
-				return true
+			fn, err := loadExtractedFunction[ExtractedDecorator](
+				filepath.Join(t.pluginDir(), kindDir(GOT_DECORATORS_DIR, f.path),
+					fmt.Sprintf("%s.so", decoratorName)))
+			if err != nil {
+				return fmt.Errorf("Failed to load decorator `%s`: %v", decoratorName, err)
 			}
+			f.log("Extracted decorator:", decoratorName)
+			decorators[decoratorName] = fn
+		}
 
-			processErr = process(c, usage)
-			if processErr != nil {
-				return false
+		for _, finalizerName := range f.exportedFinalizers {
+			if _, ok := t.finalizers[finalizerName]; ok {
+				continue
 			}
 
-			return false
-		})
-		if processErr != nil {
-			return false, err
+			fn, err := loadExtractedFunction[ExtractedFinalizer](
+				filepath.Join(t.pluginDir(), kindDir(GOT_FINALIZERS_DIR, f.path),
+					fmt.Sprintf("%s.so", finalizerName)))
+			if err != nil {
+				return fmt.Errorf("Failed to load finalizer `%s`: %v", finalizerName, err)
+			}
+			f.log("Extracted finalizer:", finalizerName)
+			t.finalizers[finalizerName] = fn
 		}
 	}
 
-	src.Reset()
-	_, _ = src.Write(updatedSrc.Bytes())
-
-	return isModified, nil
+	return nil
 }
 
+// DeletedNode is the node of a TransformContext after it was deleted.
 type DeletedNode struct{}
 
 func (e *DeletedNode) Pos() token.Pos {
@@ -355,14 +1058,51 @@ func (e *DeletedNode) End() token.Pos {
 	return 0
 }
 
+// TransformContext is the context of the attributes applied to a node.
+//
+// The attributes bound to the same node, such as `#[A, B]`, are applied in
+// order as a pipeline: each attribute receives the output of the previous
+// ones. Node returns the node the attribute applies to, which is the node
+// replacing the original node after Replace, or the first node given to
+// ReplaceWith. Nodes returns all the nodes taking the place of the
+// original node, including the inserted ones.
+// An attribute can end the pipeline with Stop. After Delete, the pipeline
+// must be stopped: applying another attribute to the deleted node fails.
+//
+// The output of the pipeline is written to the AST once all its attributes
+// are applied.
 type TransformContext struct {
 	*astutil.Cursor
 	*ast.File
-	fileSrc []byte
-	args    []string
+	ctx       context.Context
+	fileSrc   []byte
+	args      []string
+	file      *fileTransform
+	position  token.Position
+	attribute string
 
 	modified    bool
+	replaced    bool
 	currentNode ast.Node
+
+	// ancestors are the nodes enclosing the original node, from the file.
+	ancestors []ast.Node
+
+	// before, nodes and after are the output of the pipeline: the nodes
+	// inserted before, the nodes replacing the original node and the nodes
+	// inserted after.
+	before    []ast.Node
+	nodes     []ast.Node
+	after     []ast.Node
+	deletedBy string
+	stopped   bool
+}
+
+// Context returns the context of the decorator call, which is cancelled
+// when the call times out. Decorators running for long should return once
+// it is done: the transformation is aborted anyway.
+func (t *TransformContext) Context() context.Context {
+	return t.ctx
 }
 
 func (t *TransformContext) Args() []string {
@@ -377,223 +1117,154 @@ func (t *TransformContext) FileSrc() []byte {
 	return t.fileSrc
 }
 
+// Node returns the node the attribute applies to, or a *DeletedNode if it
+// was deleted.
 func (t *TransformContext) Node() ast.Node {
 	return t.currentNode
 }
 
+// Nodes returns the nodes taking the place of the original node so far:
+// the inserted nodes and the nodes replacing it, in order.
+func (t *TransformContext) Nodes() []ast.Node {
+	nodes := append([]ast.Node{}, t.before...)
+	nodes = append(nodes, t.nodes...)
+	return append(nodes, t.after...)
+}
+
+// Replace replaces the node.
 func (t *TransformContext) Replace(node ast.Node) {
-	t.Cursor.Replace(node)
+	t.ReplaceWith(node)
+}
+
+// ReplaceWith replaces the node with several nodes, which requires the node
+// to be part of a list, like the declarations of a file or the statements
+// of a block. The next attributes apply to the first node.
+// Without any node, the node is deleted.
+func (t *TransformContext) ReplaceWith(nodes ...ast.Node) {
+	if len(nodes) == 0 {
+		t.Delete()
+		return
+	}
+
+	t.nodes = append([]ast.Node{}, nodes...)
 	t.modified = true
-	t.currentNode = node
+	t.replaced = true
+	t.currentNode = nodes[0]
+	t.deletedBy = ""
 }
 
+// Delete deletes the node. The nodes inserted before and after it are
+// kept.
 func (t *TransformContext) Delete() {
-	t.Cursor.Delete()
+	t.nodes = nil
 	t.modified = true
 	t.currentNode = &DeletedNode{}
+	t.deletedBy = t.attribute
 }
 
+// InsertBefore inserts a node before the node, after the nodes already
+// inserted before it.
 func (t *TransformContext) InsertBefore(node ast.Node) {
-	t.Cursor.InsertBefore(node)
+	t.before = append(t.before, node)
 	t.modified = true
 }
 
+// InsertAfter inserts a node right after the node, before the nodes
+// already inserted after it, like astutil.Cursor.InsertAfter.
 func (t *TransformContext) InsertAfter(node ast.Node) {
-	t.Cursor.InsertAfter(node)
+	t.after = append([]ast.Node{node}, t.after...)
 	t.modified = true
 }
 
-func cleanupSource(src *bytes.Buffer) error {
-	fset := token.NewFileSet()
-
-	pfile, err := parser.ParseFile(fset, "", src, parser.ParseComments)
-	if err != nil {
-		return fmt.Errorf("Failed to parse file: %v", err)
-	}
-
-	handleComment := func(comment *ast.Comment) string {
-		if constraint.IsGoBuild(comment.Text) {
-			exp, err := constraint.Parse(comment.Text)
-			if err != nil {
-				return ""
-			}
-
-			if exp.Eval(hasGeneratedTag) {
-				return comment.Text
-			} else if strings.Contains(comment.Text, "generated") {
-				result := removeTag(exp, func(tag string) bool {
-					return strings.EqualFold(tag, "generated")
-				})
-				exp = result
-
-				if result != nil {
-					return "//go:build " + result.String()
-				}
-			}
-
-			if exp == nil {
-				exp = &constraint.TagExpr{Tag: "generated"}
-			} else {
-				exp = &constraint.AndExpr{
-					X: exp,
-					Y: &constraint.TagExpr{Tag: "generated"},
-				}
-			}
+// Stop ends the pipeline: the next attributes bound to the node are not
+// applied.
+func (t *TransformContext) Stop() {
+	t.stopped = true
+}
 
-			return "//go:build " + exp.String()
-		}
+// commit writes the output of the pipeline to the AST.
+func (t *TransformContext) commit() error {
+	original := t.Cursor.Node()
 
-		return ""
+	inserted := append([]ast.Node{}, t.after...)
+	if len(t.nodes) > 1 {
+		inserted = append(append([]ast.Node{}, t.nodes[1:]...), inserted...)
 	}
 
-	astutil.Apply(pfile, nil, func(c *astutil.Cursor) bool {
-		n := c.Node()
-		if n == nil {
-			return true
-		}
-
-		if comment, ok := n.(*ast.Comment); ok {
-			commentText := handleComment(comment)
-
-			if commentText == "" {
-				c.Delete()
-			} else {
-				comment.Text = commentText
-			}
-
-			return true
-		}
-
-		return true
-	})
-
-	updatedComments := []*ast.CommentGroup{}
-	for _, comments := range pfile.Comments {
-		updatedGroup := []*ast.Comment{}
-		for _, c := range comments.List {
-			commentText := handleComment(c)
-			if commentText != "" {
-				c.Text = commentText
-				updatedGroup = append(updatedGroup, c)
-			}
-		}
+	inList := t.Cursor.Index() >= 0
+	if !inList && len(t.before)+len(inserted) > 0 {
+		return fmt.Errorf("Failed to insert nodes: the %s is not part of a list", nodeKind(original))
+	}
+	if !inList && len(t.nodes) == 0 {
+		return fmt.Errorf("Failed to delete node: the %s is not part of a list", nodeKind(original))
+	}
 
-		comments.List = updatedGroup
-		if len(comments.List) > 0 {
-			updatedComments = append(updatedComments, comments)
-		}
+	for _, node := range t.before {
+		t.Cursor.InsertBefore(node)
+	}
+	for i := len(inserted) - 1; i >= 0; i-- {
+		t.Cursor.InsertAfter(inserted[i])
 	}
-	pfile.Comments = updatedComments
 
-	src.Reset()
-	printer.Fprint(src, fset, pfile)
+	if len(t.nodes) == 0 {
+		t.Cursor.Delete()
+	} else if t.nodes[0] != original {
+		t.Cursor.Replace(t.nodes[0])
+	}
 
 	return nil
 }
 
-func (t *gotTransformer) log(args ...interface{}) {
-	log(append([]interface{}{t.currentFile + ":"}, args...)...)
-}
-
-type attributesUsage struct {
-	commentPos int
-	attributes []AttributeInstruction
-	isApplied  bool
+// Reportf reports a diagnostic at the position of the attribute being
+// applied. Unlike returning an error, it doesn't stop the transformation.
+func (t *TransformContext) Reportf(format string, args ...interface{}) {
+	t.file.report(t.position, format, args...)
 }
 
-func extractAttributeUsages(src io.Reader) ([]*attributesUsage, error) {
-	var usages []*attributesUsage
-
-	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to parse file: %v", err)
+// SetDoc sets the doc comment of a declaration, spec or field, replacing
+// its current doc comment. Each line of the text becomes a `//` comment
+// line, and an empty text removes the doc comment.
+// When the current node doc comment is replaced, its directives are kept.
+// The node may be any node of the file, such as a field of another
+// declaration, which is then printed again with its new doc comment.
+func (t *TransformContext) SetDoc(node ast.Node, text string) {
+	var doc *ast.CommentGroup
+	if text != "" {
+		doc = docComment(text)
 	}
 
-	for _, comments := range file.Comments {
-		for _, comment := range comments.List {
-			usage := extractComment(comment)
-			if usage != nil {
-				usages = append(usages, usage)
-			}
-		}
+	field, _, ok := commentFields(node)
+	if !ok {
+		return
 	}
+	*field = doc
 
-	return usages, nil
-}
-
-func extractComment(comment *ast.Comment) *attributesUsage {
-	if constraint.IsGoBuild(comment.Text) {
-		return nil
+	if node == t.currentNode {
+		t.modified = true
+		t.replaced = true
+		return
 	}
 
-	line := strings.TrimSpace(comment.Text)
-	if !IsLineGotPrefixed(line) {
-		return nil
+	// The declarations of the source not modified by the attributes are
+	// copied from the source, without the new doc comment.
+	if decl := t.file.layout.enclosingDecl(node); decl != nil {
+		t.file.layout.touch(decl)
+		t.file.modified = true
 	}
-
-	parser := NewInstructionParser(strings.NewReader(line), AllInstructions)
-	_, err := parser.Parse()
-	if err != nil {
-		return nil
-	}
-	var usage *attributesUsage
-
-	for _, instruction := range parser.result {
-		if instruction.Type() == AttributeInstructionType {
-			attr := instruction.(AttributeInstruction)
-
-			if usage == nil {
-				pos := int(comment.End())
-				usage = &attributesUsage{
-					commentPos: pos - 1,
-					attributes: []AttributeInstruction{},
-				}
-			}
-
-			usage.attributes = append(usage.attributes, attr)
-		} else {
-			return nil
-		}
-	}
-
-	return usage
 }
 
-var hasGeneratedTag = func(tag string) bool {
-	return strings.EqualFold(tag, "generated")
+// recordApplied records an attribute applied to the file.
+func (f *fileTransform) recordApplied(usage *attributesUsage, attribute AttributeInstruction) {
+	f.applied = append(f.applied, AppliedAttribute{
+		Name: attribute.Name,
+		Args: attribute.Arguments,
+		Pos:  usage.position,
+	})
 }
 
-func removeTag(expr constraint.Expr, shouldRemove func(string) bool) constraint.Expr {
-	switch v := expr.(type) {
-	case *constraint.AndExpr:
-		v.X = removeTag(v.X, shouldRemove)
-		v.Y = removeTag(v.Y, shouldRemove)
-		if v.X == nil {
-			return v.Y
-		}
-		if v.Y == nil {
-			return v.X
-		}
-	case *constraint.OrExpr:
-		v.X = removeTag(v.X, shouldRemove)
-		v.Y = removeTag(v.Y, shouldRemove)
-		if v.X == nil {
-			return v.Y
-		}
-		if v.Y == nil {
-			return v.X
-		}
-	case *constraint.NotExpr:
-		v.X = removeTag(v.X, shouldRemove)
-		if v.X == nil {
-			return nil
-		}
-	case *constraint.TagExpr:
-		if shouldRemove(v.Tag) {
-			return nil
-		}
+// log sends a message to the transformer logger, if any.
+func (t *Transformer) log(args ...interface{}) {
+	if t.logger != nil {
+		t.logger.Println(args...)
 	}
-
-	return expr
 }
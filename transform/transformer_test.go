@@ -2,6 +2,15 @@ package transform
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -47,3 +56,592 @@ func Foo() {}`,
 		[]string{"Foo", "Foo", "decorator"},
 	)
 }
+
+func TestGeneratedFileName(t *testing.T) {
+	cases := map[string]string{
+		"main.go":          "main_generated.go",
+		"pkg/user.go":      "pkg/user_generated.go",
+		"user_test.go":     "user_generated_test.go",
+		"pkg/testdata.go":  "pkg/testdata_generated.go",
+		"pkg/my_test_x.go": "pkg/my_test_x_generated.go",
+	}
+
+	for path, expected := range cases {
+		if result := generatedFileName(path); result != expected {
+			t.Errorf("Expected %s, got %s", expected, result)
+		}
+	}
+}
+
+func TestTestOnlyDecorators(t *testing.T) {
+	transformer := GotTransform(".")
+	transformer.decorators["Foo"] = func(c *TransformContext) error { return nil }
+	transformer.testDecorators["Bar"] = func(c *TransformContext) error { return nil }
+
+	if _, ok := transformer.decorator("foo.go", "Foo"); !ok {
+		t.Errorf("Expected decorator Foo to be available to foo.go")
+	}
+	if _, ok := transformer.decorator("foo.go", "Bar"); ok {
+		t.Errorf("Expected test decorator Bar to be unavailable to foo.go")
+	}
+
+	if _, ok := transformer.decorator("foo_test.go", "Foo"); !ok {
+		t.Errorf("Expected decorator Foo to be available to foo_test.go")
+	}
+	if _, ok := transformer.decorator("foo_test.go", "Bar"); !ok {
+		t.Errorf("Expected test decorator Bar to be available to foo_test.go")
+	}
+}
+
+func transformTestSource(t *testing.T, decorators map[string]ExtractedDecorator, src string) string {
+	transformer := GotTransform(".")
+	for name, fn := range decorators {
+		transformer.decorators[name] = fn
+	}
+
+	f, err := transformer.newFileTransform("main.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := transformer.applyAttributes(f); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := transformer.generatedSource(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(out)
+}
+
+func TestPreserveDirectives(t *testing.T) {
+	src := `//go:build !generated
+
+package main
+
+/*
+#include <stdio.h>
+*/
+import "C"
+
+import (
+	_ "embed"
+	"fmt"
+)
+
+//export Exported
+func Exported() {}
+
+//go:noinline
+// #[Wrap]
+func Hello() {
+	fmt.Println("hello")
+}
+
+// Content is embedded.
+//
+//go:embed file.txt
+var content string
+`
+
+	result := transformTestSource(t, map[string]ExtractedDecorator{
+		"Wrap": func(c *TransformContext) error {
+			fn := c.Node().(*ast.FuncDecl)
+			c.InsertBefore(&ast.FuncDecl{
+				Name: ast.NewIdent("before"),
+				Type: &ast.FuncType{Params: &ast.FieldList{}},
+				Body: &ast.BlockStmt{
+					List: []ast.Stmt{
+						&ast.ExprStmt{X: &ast.CallExpr{
+							Fun:  ast.NewIdent("println"),
+							Args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: `"a long string moving the printer offsets"`}},
+						}},
+					},
+				},
+			})
+			c.Replace(&ast.FuncDecl{
+				Name: fn.Name,
+				Type: fn.Type,
+				Body: &ast.BlockStmt{},
+			})
+			return nil
+		},
+	}, src)
+
+	expected := []string{
+		"//go:build generated\n",
+		"/*\n#include <stdio.h>\n*/\nimport \"C\"\n",
+		"//export Exported\nfunc Exported() {}\n",
+		"//go:noinline\nfunc Hello() {}\n",
+		"//go:embed file.txt\nvar content string\n",
+	}
+	for _, part := range expected {
+		if !strings.Contains(result, part) {
+			t.Errorf("Expected generated source to contain:\n%s\ngot:\n%s", part, result)
+		}
+	}
+
+	if strings.Contains(result, "#[Wrap]") {
+		t.Errorf("Expected attribute comments to be removed, got:\n%s", result)
+	}
+}
+
+func TestKeepDocComments(t *testing.T) {
+	src := `//go:build !generated
+
+// Package main is documented.
+package main
+
+// User is a user.
+// #[Model]
+type User struct {
+	// Name is the user name.
+	Name string
+}
+
+// Hello says hello.
+// #[Hello]
+func Hello() {
+	// say hello
+	println("hello")
+}
+`
+
+	result := transformTestSource(t, map[string]ExtractedDecorator{
+		"Model": func(c *TransformContext) error {
+			field := &ast.Field{
+				Names: []*ast.Ident{ast.NewIdent("ID")},
+				Type:  ast.NewIdent("int"),
+			}
+			c.SetDoc(field, "ID identifies the user.")
+
+			decl := c.Node().(*ast.GenDecl)
+			structType := decl.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType)
+			structType.Fields.List = append(structType.Fields.List, field)
+			c.Replace(decl)
+
+			table := &ast.GenDecl{
+				Tok: token.CONST,
+				Specs: []ast.Spec{&ast.ValueSpec{
+					Names:  []*ast.Ident{ast.NewIdent("UserTable")},
+					Values: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: `"users"`}},
+				}},
+			}
+			c.SetDoc(table, "UserTable is the table of the users.\nIt is generated.")
+			c.InsertBefore(table)
+			return nil
+		},
+		"Hello": func(c *TransformContext) error {
+			c.SetDoc(c.Node(), "Hello is decorated.")
+			return nil
+		},
+	}, src)
+
+	expected := []string{
+		"// Package main is documented.\npackage main\n",
+		"// UserTable is the table of the users.\n// It is generated.\nconst UserTable = \"users\"\n",
+		"// User is a user.\ntype User struct {\n\t// Name is the user name.\n\tName string\n\t// ID identifies the user.\n\tID int\n}\n",
+		"// Hello is decorated.\nfunc Hello() {\n\t// say hello\n\tprintln(\"hello\")\n}\n",
+	}
+	for _, part := range expected {
+		if !strings.Contains(result, part) {
+			t.Errorf("Expected generated source to contain:\n%s\ngot:\n%s", part, result)
+		}
+	}
+}
+
+func TestSetDocOtherDecl(t *testing.T) {
+	src := `package main
+
+type User struct {
+	Name string
+}
+
+// #[Document]
+func Hello() {}
+`
+
+	result := transformTestSource(t, map[string]ExtractedDecorator{
+		"Document": func(c *TransformContext) error {
+			// Only the doc of a field of another declaration is set.
+			for _, decl := range c.ASTFile().Decls {
+				if gen, ok := decl.(*ast.GenDecl); ok {
+					field := gen.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List[0]
+					c.SetDoc(field, "Name is the user name.")
+				}
+			}
+			return nil
+		},
+	}, src)
+
+	expected := "type User struct {\n\t// Name is the user name.\n\tName string\n}\n"
+	if !strings.Contains(result, expected) {
+		t.Errorf("Expected generated source to contain:\n%s\ngot:\n%s", expected, result)
+	}
+}
+
+func TestAttributeChain(t *testing.T) {
+	src := `//go:build !generated
+
+package main
+
+// #[Rename(Bar), Split]
+// #[Check]
+func Foo() {}
+
+// #[Skip, Unknown]
+func Baz() {}
+`
+
+	funcDecl := func(name string) *ast.FuncDecl {
+		return &ast.FuncDecl{
+			Name: ast.NewIdent(name),
+			Type: &ast.FuncType{Params: &ast.FieldList{}},
+			Body: &ast.BlockStmt{},
+		}
+	}
+
+	var names []string
+	result := transformTestSource(t, map[string]ExtractedDecorator{
+		"Rename": func(c *TransformContext) error {
+			c.Replace(funcDecl(c.Args()[0]))
+			return nil
+		},
+		"Split": func(c *TransformContext) error {
+			name := c.Node().(*ast.FuncDecl).Name.Name
+			c.InsertBefore(funcDecl(name + "Before"))
+			c.ReplaceWith(c.Node(), funcDecl(name+"After"))
+			return nil
+		},
+		"Check": func(c *TransformContext) error {
+			for _, node := range c.Nodes() {
+				names = append(names, node.(*ast.FuncDecl).Name.Name)
+			}
+			return nil
+		},
+		"Skip": func(c *TransformContext) error {
+			c.Delete()
+			c.Stop()
+			return nil
+		},
+	}, src)
+
+	if strings.Join(names, ",") != "BarBefore,Bar,BarAfter" {
+		t.Errorf("Expected BarBefore,Bar,BarAfter, got %s", strings.Join(names, ","))
+	}
+
+	expected := "func BarBefore() {\n}\n\nfunc Bar() {\n}\n\nfunc BarAfter() {\n}\n"
+	if !strings.HasSuffix(result, expected) {
+		t.Errorf("Expected generated source to end with:\n%s\ngot:\n%s", expected, result)
+	}
+	if strings.Contains(result, "Baz") {
+		t.Errorf("Expected Baz to be deleted, got:\n%s", result)
+	}
+}
+
+func TestAttributeChainDeletedNode(t *testing.T) {
+	src := "package main\n\n// #[Delete, Check]\nfunc Foo() {}\n"
+
+	transformer := GotTransform(".")
+	transformer.decorators["Delete"] = func(c *TransformContext) error {
+		c.Delete()
+		return nil
+	}
+	transformer.decorators["Check"] = func(c *TransformContext) error {
+		t.Errorf("Expected Check not to be applied to a deleted node")
+		return nil
+	}
+
+	f, err := transformer.newFileTransform("main.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = transformer.applyAttributes(f)
+	expected := "main.go:3:1: attribute `Check` targets the node deleted by `Delete`"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+}
+
+func TestTransformerRun(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": "package p\n\n//#[Foo(1)]\nfunc A() {}\n",
+		"b.go": "package p\n\n//#[Foo]\nfunc B() {}\n",
+		"c.go": "package p\n\nfunc C() {}\n",
+	})
+
+	transformer := NewTransformer(dir,
+		WithBuildDir(t.TempDir()),
+		WithFileFilter(func(path string) bool {
+			return filepath.Base(path) != "b.go"
+		}),
+		WithDecorator("Foo", func(c *TransformContext) error { return nil }),
+	)
+
+	result, err := transformer.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Files) != 1 {
+		t.Fatalf("Expected 1 file, got %d", len(result.Files))
+	}
+
+	file := result.Files[0]
+	if file.Source != filepath.Join(dir, "a.go") {
+		t.Errorf("Expected %s, got %s", filepath.Join(dir, "a.go"), file.Source)
+	}
+	if file.Generated != "" {
+		t.Errorf("Expected no generated file, got %s", file.Generated)
+	}
+	if len(file.Attributes) != 1 {
+		t.Fatalf("Expected 1 attribute, got %d", len(file.Attributes))
+	}
+
+	attr := file.Attributes[0]
+	if attr.Name != "Foo" || strings.Join(attr.Args, ",") != "1" {
+		t.Errorf("Expected Foo(1), got %s(%s)", attr.Name, strings.Join(attr.Args, ","))
+	}
+	if attr.Pos.Filename != file.Source || attr.Pos.Line != 3 {
+		t.Errorf("Expected %s:3, got %s", file.Source, attr.Pos)
+	}
+}
+
+func TestTransformerRunTwice(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": "package p\n\n// #[Helper]\nfunc A() {}\n",
+	})
+
+	helper := func(c *TransformContext) error {
+		name := c.FreshName("helper")
+		decl, err := c.Decl("var $name = 1", name)
+		if err != nil {
+			return err
+		}
+		c.InsertAfter(decl)
+
+		c.Store().Append("helpers", name.Name)
+		helpers, _ := c.Store().Get("helpers")
+		c.Reportf("%s %d", name.Name, len(helpers.([]interface{})))
+		return nil
+	}
+
+	// The names and the store of the first run are not kept.
+	transformer := NewTransformer(dir, WithBuildDir(t.TempDir()), WithDecorator("Helper", helper), WithInMemory(true))
+	for i := 0; i < 2; i++ {
+		result, err := transformer.Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Files) != 1 || len(result.Files[0].Diagnostics) != 1 {
+			t.Fatalf("Expected one diagnostic, got %v", result.Files)
+		}
+		if message := result.Files[0].Diagnostics[0].Message; message != "helper 1" {
+			t.Errorf("Expected helper 1, got %s", message)
+		}
+	}
+}
+
+func TestTransformerRunGeneratedHeader(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go":           "//go:build !generated\n\npackage p\n\n// #[Drop]\nfunc A() {}\n\nfunc B() {}\n",
+		"a_generated.go": "//go:build generated\n\npackage p\n\nfunc B() {}\n",
+	})
+	opts := append(explainTestOptions(), WithBuildDir(t.TempDir()), WithVersion("v1.2.0"))
+
+	// A generated file without header may have been edited by hand.
+	_, err := NewTransformer(dir, opts...).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Refusing to overwrite") {
+		t.Errorf("Expected the generated file not to be overwritten, got %v", err)
+	}
+
+	generated := filepath.Join(dir, "a_generated.go")
+	if err := os.Remove(generated); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := NewTransformer(dir, opts...).Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src, err := os.ReadFile(generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `// Code generated by got v1.2.0 from a.go. DO NOT EDIT.
+
+// Decorators applied:
+//   - Drop at a.go:5
+
+//go:build generated
+
+package p
+
+func B() {}
+`
+	if string(src) != expected {
+		t.Errorf("Expected %s, got %s", expected, src)
+	}
+}
+
+func TestTransformerRunStaleFiles(t *testing.T) {
+	dir := t.TempDir()
+	handwritten := "//go:build generated\n\npackage p\n\nfunc B() {}\n"
+	writeTestFiles(t, dir, map[string]string{
+		"a.go":           "//go:build !generated\n\npackage p\n\nfunc A() {}\n",
+		"a_generated.go": handwritten,
+		"b.go":           "//go:build !generated\n\npackage p\n\nfunc C() {}\n",
+		"b_generated.go": generatedHeader("v1.2.0", "b.go") + handwritten,
+	})
+	opts := append(explainTestOptions(), WithBuildDir(t.TempDir()))
+
+	if _, err := NewTransformer(dir, opts...).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file without header requires the generated tag, but was not
+	// written by got.
+	src, err := os.ReadFile(filepath.Join(dir, "a_generated.go"))
+	if err != nil || string(src) != handwritten {
+		t.Errorf("Expected the file without header to be kept, got %s, %v", src, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b_generated.go")); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale generated file to be removed, got %v", err)
+	}
+}
+
+func TestHasGeneratedHeader(t *testing.T) {
+	tests := map[string]bool{
+		"// Code generated by got v1.2.0 from a.go. DO NOT EDIT.\n\npackage p\n":           true,
+		"//go:build generated\n\n// Code generated by got devel from a.go. DO NOT EDIT.\n": true,
+		"// Code generated by stringer. DO NOT EDIT.\n\npackage p\n":                       false,
+		"package p\n\n// Code generated by got devel from a.go. DO NOT EDIT.\n":            false,
+	}
+
+	for src, expected := range tests {
+		if hasGeneratedHeader([]byte(src)) != expected {
+			t.Errorf("Expected %v for %q", expected, src)
+		}
+	}
+}
+
+func TestTransformerRunErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": "package p\n",
+	})
+
+	if _, err := NewTransformer(dir).Run(ctx); err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+
+	if _, err := NewTransformer(filepath.Join(dir, "missing")).Run(context.Background()); err == nil {
+		t.Errorf("Expected an error for a missing directory")
+	}
+
+	// The attribute comments are parsed like by the language server.
+	writeTestFiles(t, dir, map[string]string{
+		"a.go": "package p\n\n// #[Log(info]\nfunc A() {}\n",
+	})
+	_, err := NewTransformer(dir, WithBuildDir(t.TempDir())).Run(context.Background())
+	expected := filepath.Join(dir, "a.go") + ":3:9: the arguments of attribute `Log` are not closed"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+}
+
+func TestTransformerRunParallel(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{}
+	for i := 0; i < 16; i++ {
+		files[fmt.Sprintf("f%02d.go", i)] = fmt.Sprintf("package p\n\n//#[Check]\nfunc F%d() {}\n", i)
+	}
+	writeTestFiles(t, dir, files)
+
+	check := func(c *TransformContext) error {
+		c.Reportf("checked %s", c.Node().(*ast.FuncDecl).Name.Name)
+		return nil
+	}
+
+	// Two transformers running at the same time share nothing.
+	results := make([]*Result, 2)
+	errs := make([]error, 2)
+	wg := sync.WaitGroup{}
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = NewTransformer(dir,
+				WithBuildDir(t.TempDir()),
+				WithParallelism(4),
+				WithDecorator("Check", check),
+			).Run(context.Background())
+		}(i)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+
+		if len(result.Files) != 16 {
+			t.Fatalf("Expected 16 files, got %d", len(result.Files))
+		}
+
+		for j, file := range result.Files {
+			expected := fmt.Sprintf("checked F%d", j)
+			if len(file.Diagnostics) != 1 || file.Diagnostics[0].Message != expected {
+				t.Errorf("Expected %s, got %v", expected, file.Diagnostics)
+			}
+		}
+	}
+}
+
+func TestBuildPluginOnce(t *testing.T) {
+	transformer := NewTransformer(".")
+
+	var builds int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = transformer.buildPlugin("decorator", GOT_DECORATORS_DIR, "Foo", "hash", token.Position{}, func() error {
+				atomic.AddInt32(&builds, 1)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	if builds != 1 {
+		t.Errorf("Expected 1 build, got %d", builds)
+	}
+
+	// Another source of the decorator would overwrite its plugin.
+	pos := token.Position{Filename: "b.go", Line: 4, Column: 1}
+	err := transformer.buildPlugin("decorator", GOT_DECORATORS_DIR, "Foo", "other", pos, func() error {
+		atomic.AddInt32(&builds, 1)
+		return nil
+	})
+	expected := "b.go:4:1: decorator `Foo` declared twice, first at -"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+	if builds != 1 {
+		t.Errorf("Expected 1 build, got %d", builds)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !generated
+
+package a
+
+// #[Rename(Bar)]
+func Foo() {}
+
+// #[Deprecated]
+func Old() {} // want "Old is deprecated"
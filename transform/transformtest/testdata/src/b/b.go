@@ -0,0 +1,9 @@
+//go:build !generated
+
+package b
+
+// #[Rename(Baz)]
+func Foo() {} // want "never reported"
+
+// #[Deprecated]
+func Old() {}
@@ -0,0 +1,4 @@
+package c
+
+// #[Register]
+func B() {}
@@ -0,0 +1,387 @@
+// Package transformtest provides utilities for testing decorators.
+//
+// Like analysistest, a test points at a testdata directory laid out as a
+// GOPATH, with the packages to transform in `testdata/src/<pkg>`. Each
+// package is transformed in memory by Transformer.Run, like by the got
+// command, with the decorators and finalizers under test, so they share
+// the store and the fresh names of the package. The generated source of
+// each go file is compared with the expected output in the
+// `<file>.go.golden` file next to it. Files without a golden file are
+// expected to be left unchanged. The files generated by finalizers are
+// compared with their golden file the same way, such as
+// `got_<name>_generated.go.golden`.
+//
+// Expected diagnostics are declared with `// want` comments, holding one or
+// more quoted regular expressions, at the end of the line the diagnostics
+// are reported on:
+//
+//	// #[JSON]
+//	type User int // want "can only be used on structs"
+//
+// Diagnostics reported on an attribute comment are expected on the line of
+// the declaration or statement the attribute is attached to.
+//
+// Running the tests with the -update flag writes the golden files from the
+// current outputs.
+package transformtest
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pedronasser/got/transform"
+)
+
+var update = flag.Bool("update", false, "update the .golden files")
+
+// Testing is the subset of *testing.T used by this package.
+type Testing interface {
+	Errorf(format string, args ...interface{})
+}
+
+// Result is the result of the transformation of a testdata file, or of a
+// finalizer of a testdata package.
+type Result struct {
+	// Filename is the path of the source file, or of the file generated by
+	// the finalizer.
+	Filename string
+
+	// Output is the transformed source.
+	Output []byte
+
+	// Diagnostics are the diagnostics reported while transforming the file.
+	Diagnostics []transform.Diagnostic
+
+	// Err is the error returned by the transformation.
+	Err error
+}
+
+// TestData returns the absolute path of the testdata directory of the
+// package being tested.
+func TestData() string {
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		panic(err)
+	}
+	return testdata
+}
+
+// Run transforms each package in dir/src/<pattern> with the decorators,
+// and reports to t the outputs differing from their golden files and the
+// diagnostics not matching the `// want` comments.
+func Run(t Testing, dir string, decorators map[string]transform.ExtractedDecorator, patterns ...string) []*Result {
+	opts := []transform.Option{}
+	for name, fn := range decorators {
+		opts = append(opts, transform.WithDecorator(name, fn))
+	}
+
+	return RunWithOptions(t, dir, opts, patterns...)
+}
+
+// RunWithOptions is like Run, with the options of the transformer, which
+// register the decorators, methods and finalizers under test.
+func RunWithOptions(t Testing, dir string, opts []transform.Option, patterns ...string) []*Result {
+	results := []*Result{}
+	for _, pattern := range patterns {
+		pkgDir := filepath.Join(dir, "src", filepath.FromSlash(pattern))
+		results = append(results, runPackage(t, pkgDir, opts)...)
+	}
+
+	return results
+}
+
+// runPackage transforms a testdata package and checks the result of each
+// of its files and finalizers.
+func runPackage(t Testing, pkgDir string, opts []transform.Option) []*Result {
+	files, err := filepath.Glob(filepath.Join(pkgDir, "*.go"))
+	if err != nil || len(files) == 0 {
+		t.Errorf("no go files found in %s", pkgDir)
+		return nil
+	}
+
+	opts = append([]transform.Option{transform.WithTests(true)}, opts...)
+	opts = append(opts, transform.WithInMemory(true))
+	run, err := transform.NewTransformer(pkgDir, opts...).Run(context.Background())
+
+	results := []*Result{}
+	if err != nil {
+		t.Errorf("%s: %v", pkgDir, err)
+		for _, filename := range files {
+			results = append(results, &Result{Filename: filename, Err: err})
+		}
+		return results
+	}
+
+	transformed := map[string]transform.FileResult{}
+	for _, file := range run.Files {
+		transformed[file.Source] = file
+	}
+
+	for _, filename := range files {
+		result := &Result{Filename: filename}
+		results = append(results, result)
+
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", filename, err)
+			result.Err = err
+			continue
+		}
+
+		file := transformed[filename]
+		result.Output, result.Diagnostics = file.Output, file.Diagnostics
+		if result.Output == nil {
+			result.Output = src
+		}
+
+		checkDiagnostics(t, filename, src, result.Diagnostics)
+		checkGolden(t, filename, src, result.Output)
+	}
+
+	for _, finalizer := range run.Finalizers {
+		if finalizer.Output == nil {
+			continue
+		}
+
+		results = append(results, &Result{Filename: finalizer.Generated, Output: finalizer.Output})
+		checkGolden(t, finalizer.Generated, nil, finalizer.Output)
+	}
+
+	return results
+}
+
+// checkGolden compares the output of a file with its golden file, or
+// updates the golden file when the -update flag is set.
+func checkGolden(t Testing, filename string, src, output []byte) {
+	golden := filename + ".golden"
+	unchanged := bytes.Equal(src, output)
+
+	if *update {
+		var err error
+		if unchanged {
+			err = os.Remove(golden)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		} else {
+			err = os.WriteFile(golden, output, 0644)
+		}
+		if err != nil {
+			t.Errorf("failed to update %s: %v", golden, err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(golden)
+	if os.IsNotExist(err) {
+		if !unchanged {
+			t.Errorf("%s: the file was transformed but has no golden file %s", filename, filepath.Base(golden))
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("failed to read %s: %v", golden, err)
+		return
+	}
+
+	if !bytes.Equal(expected, output) {
+		t.Errorf("%s: output differs from %s (-want +got):\n%s",
+			filename, filepath.Base(golden), diff(string(expected), string(output)))
+	}
+}
+
+// expectation is an expected diagnostic declared by a `// want` comment.
+type expectation struct {
+	line    int
+	pattern *regexp.Regexp
+	matched bool
+}
+
+// checkDiagnostics checks that each diagnostic matches an expectation on its
+// line, and that each expectation is matched.
+func checkDiagnostics(t Testing, filename string, src []byte, diagnostics []transform.Diagnostic) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		t.Errorf("failed to parse %s: %v", filename, err)
+		return
+	}
+
+	expectations, err := parseExpectations(fset, file)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	commentLines := commentOnlyLines(fset, file, src)
+
+	for _, d := range diagnostics {
+		line := d.Pos.Line
+		for commentLines[line] {
+			line++
+		}
+
+		found := false
+		for _, e := range expectations {
+			if e.line == line && !e.matched && e.pattern.MatchString(d.Message) {
+				e.matched = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%s: unexpected diagnostic: %s", d.Pos, d.Message)
+		}
+	}
+
+	for _, e := range expectations {
+		if !e.matched {
+			t.Errorf("%s:%d: no diagnostic was reported matching %q", filename, e.line, e.pattern)
+		}
+	}
+}
+
+// parseExpectations parses the `// want` comments of the file.
+func parseExpectations(fset *token.FileSet, file *ast.File) ([]*expectation, error) {
+	expectations := []*expectation{}
+
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			text := strings.TrimPrefix(comment.Text, "//")
+			text = strings.TrimSpace(text)
+			if !strings.HasPrefix(text, "want ") {
+				continue
+			}
+
+			pos := fset.Position(comment.Pos())
+			rest := strings.TrimSpace(strings.TrimPrefix(text, "want "))
+			for rest != "" {
+				quoted, err := strconv.QuotedPrefix(rest)
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid want comment: %v", pos, err)
+				}
+				rest = strings.TrimSpace(rest[len(quoted):])
+
+				value, err := strconv.Unquote(quoted)
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid want comment: %v", pos, err)
+				}
+
+				pattern, err := regexp.Compile(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid want pattern: %v", pos, err)
+				}
+
+				expectations = append(expectations, &expectation{
+					line:    pos.Line,
+					pattern: pattern,
+				})
+			}
+		}
+	}
+
+	return expectations, nil
+}
+
+// commentOnlyLines returns the lines holding nothing but comments.
+func commentOnlyLines(fset *token.FileSet, file *ast.File, src []byte) map[int]bool {
+	lines := map[int]bool{}
+
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			pos := fset.Position(comment.Pos())
+			lineStart := bytes.LastIndexByte(src[:pos.Offset], '\n') + 1
+			if len(bytes.TrimSpace(src[lineStart:pos.Offset])) > 0 {
+				continue
+			}
+
+			end := fset.Position(comment.End())
+			rest := src[end.Offset:]
+			if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+				rest = rest[:i]
+			}
+			if len(bytes.TrimSpace(rest)) > 0 {
+				continue
+			}
+
+			for line := pos.Line; line <= end.Line; line++ {
+				lines[line] = true
+			}
+		}
+	}
+
+	return lines
+}
+
+// diff returns a line diff of two texts, with the removed lines prefixed
+// by `-` and the added lines prefixed by `+`.
+func diff(a, b string) string {
+	x := splitLines(a)
+	y := splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	out := &strings.Builder{}
+	line := func(prefix, text string) {
+		out.WriteString(prefix + strings.TrimSuffix(text, "\n") + "\n")
+	}
+
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			line(" ", x[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			line("-", x[i])
+			i++
+		default:
+			line("+", y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		line("-", x[i])
+	}
+	for ; j < len(y); j++ {
+		line("+", y[j])
+	}
+
+	return out.String()
+}
+
+// splitLines splits a text after each new line.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,103 @@
+package transformtest
+
+import (
+	"fmt"
+	"go/ast"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pedronasser/got/transform"
+)
+
+var decorators = map[string]transform.ExtractedDecorator{
+	"Rename": func(c *transform.TransformContext) error {
+		fn := c.Node().(*ast.FuncDecl)
+		fn.Name = ast.NewIdent(c.Args()[0])
+		c.Replace(fn)
+		return nil
+	},
+	"Deprecated": func(c *transform.TransformContext) error {
+		c.Reportf("%s is deprecated", c.Node().(*ast.FuncDecl).Name.Name)
+		return nil
+	},
+}
+
+func TestRun(t *testing.T) {
+	results := Run(t, TestData(), decorators, "a")
+	if len(results) != 2 {
+		t.Errorf("Expected 2 results, got %d", len(results))
+	}
+}
+
+func TestRunWithOptions(t *testing.T) {
+	register := func(c *transform.TransformContext) error {
+		name := c.FreshName("handler")
+		decl, err := c.Decl("var $name = $fn", name, c.Node().(*ast.FuncDecl).Name)
+		if err != nil {
+			return err
+		}
+		c.InsertAfter(decl)
+		c.Store().Append("handlers", name.Name)
+		return nil
+	}
+	handlers := func(c *transform.FinalizeContext) error {
+		values, _ := c.Store().Get("handlers")
+		names := []string{}
+		for _, value := range values.([]interface{}) {
+			names = append(names, value.(string))
+		}
+		sort.Strings(names)
+
+		decl, err := c.Decl("var handlers = []func(){" + strings.Join(names, ", ") + "}")
+		if err != nil {
+			return err
+		}
+		c.AddDecl(decl)
+		return nil
+	}
+
+	results := RunWithOptions(t, TestData(), []transform.Option{
+		transform.WithDecorator("Register", register),
+		transform.WithFinalizer("Handlers", handlers),
+	}, "c")
+	if len(results) != 4 {
+		t.Errorf("Expected 4 results, got %d", len(results))
+	}
+}
+
+// errorsRecorder records the errors reported by Run.
+type errorsRecorder struct {
+	errors []string
+}
+
+func (r *errorsRecorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRunFailures(t *testing.T) {
+	recorder := &errorsRecorder{}
+	Run(recorder, TestData(), decorators, "b")
+
+	expected := []string{
+		"unexpected diagnostic: Old is deprecated",
+		`no diagnostic was reported matching "never reported"`,
+		"output differs from b.go.golden",
+		"+func Baz() {} // want \"never reported\"",
+	}
+
+	errors := strings.Join(recorder.errors, "\n")
+	for _, e := range expected {
+		if !strings.Contains(errors, e) {
+			t.Errorf("Expected errors to contain %q, got:\n%s", e, errors)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	result := diff("a\nb\nc\n", "a\nc\nd\n")
+	expected := " a\n-b\n c\n+d\n"
+	if result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
@@ -1,27 +1,18 @@
 package transform
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"go/ast"
+	"go/build"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 )
 
-var VerboseLog = false
-
-// log prints a message if VerboseLog is true.
-func log(v ...interface{}) {
-	if !VerboseLog {
-		return
-	}
-	msg := append([]interface{}{}, GOT_PREFIX)
-	msg = append(msg, v...)
-	fmt.Println(msg...)
-}
-
 // convertSlice converts a slice of any type to a slice of interface{}.
 func convertSlice[T any](s []T) []interface{} {
 	res := []interface{}{}
@@ -31,28 +22,80 @@ func convertSlice[T any](s []T) []interface{} {
 	return res
 }
 
-// LookupGoFiles returns a list of go files in the target directory.
-func LookupGoFiles(targetDir string) []string {
+// LookupGoFiles returns the go files of the package in the target directory
+// selected by the build context, including the test files.
+// Just like the go command, the files are selected by their build
+// constraints and GOOS/GOARCH suffixes, and the names starting with `_` or
+// `.` are ignored. Subdirectories are other packages and are not visited.
+// Files generated by got are never returned.
+func LookupGoFiles(ctxt *build.Context, targetDir string) ([]string, error) {
+	entries, err := os.ReadDir(targetDir)
+	if err != nil {
+		return nil, err
+	}
+
 	foundFiles := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != GO_FILE_EXTENSION {
+			continue
+		}
+		if isGeneratedFile(name) {
+			continue
+		}
 
-	_ = filepath.Walk(targetDir, func(path string, info os.FileInfo, err error) error {
-		if path == "got" || (len(path) > 1 && string(path[0]) == ".") {
-			return filepath.SkipDir
+		match, err := ctxt.MatchFile(targetDir, name)
+		if err != nil {
+			return nil, err
 		}
-		if strings.Contains(filepath.Base(path), "_test") {
-			return nil
+		if match {
+			foundFiles = append(foundFiles, filepath.Join(targetDir, name))
 		}
-		if strings.Contains(filepath.Base(path), "_generated") {
-			return nil
+	}
+
+	return foundFiles, nil
+}
+
+// LookupPackageDirs returns the root directory and all its subdirectories
+// that may contain go packages, as matched by the `root/...` pattern.
+// Like the go command, the `testdata` and `vendor` directories and the
+// directories starting with `_` or `.` are skipped, and so is the got
+// build directory.
+func LookupPackageDirs(root string) ([]string, error) {
+	dirs := []string{}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
 		}
-		if filepath.Ext(path) == GO_FILE_EXTENSION {
-			foundFiles = append(foundFiles, path)
+		if !d.IsDir() {
 			return nil
 		}
+
+		if path != root {
+			name := d.Name()
+			if name == "testdata" || name == "vendor" ||
+				name == strings.TrimSuffix(GOT_BUILD_DIR, "/") ||
+				strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+		}
+
+		dirs = append(dirs, path)
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dirs, nil
+}
 
-	return foundFiles
+// isGeneratedFile reports whether the file was generated by got.
+func isGeneratedFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasSuffix(name, "_generated"+GO_FILE_EXTENSION) ||
+		strings.HasSuffix(name, "_generated_test"+GO_FILE_EXTENSION)
 }
 
 // GetGoRoot returns the GOROOT environment variable.
@@ -67,13 +110,12 @@ func GetGoRoot() (string, error) {
 	return goRoot, nil
 }
 
-// GetGoFiles returns a list of go files in the target directory.
+// executeGoImports executes goimports on the file, updating it in place.
 func executeGoImports(file string) error {
-	gopath, err := GetGoPath()
+	goImportsBin, err := goImportsPath()
 	if err != nil {
 		return err
 	}
-	goImportsBin := filepath.Join(gopath, "bin", "goimports")
 	cmd := exec.Command(goImportsBin, "-w", "-v", file)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -85,6 +127,39 @@ func executeGoImports(file string) error {
 	return nil
 }
 
+// goImportsSource executes goimports on a source, resolving its imports as
+// if it was the file with the given name, and returns the updated source.
+func goImportsSource(filename string, src []byte) ([]byte, error) {
+	goImportsBin, err := goImportsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout := bytes.NewBuffer([]byte{})
+	stderr := bytes.NewBuffer([]byte{})
+
+	cmd := exec.Command(goImportsBin, "-srcdir", filepath.Dir(filename))
+	cmd.Stdin = bytes.NewReader(src)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to execute goimports: %s: %s",
+			err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// goImportsPath returns the path of the goimports binary.
+func goImportsPath() (string, error) {
+	gopath, err := GetGoPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(gopath, "bin", "goimports"), nil
+}
+
 // GetGoPath returns the GOPATH environment variable.
 func GetGoPath() (string, error) {
 	// Get GOPATH
@@ -109,7 +184,11 @@ func buildAsPlugin(srcPath, dstPath string) error {
 		return err
 	}
 	goBuildBin := filepath.Join(goroot, "bin", "go")
-	cmd := exec.Command(goBuildBin, "build", "-buildmode=plugin", "-o", dstPath, srcPath)
+	args := []string{"build", "-buildmode=plugin"}
+	if raceEnabled {
+		args = append(args, "-race")
+	}
+	cmd := exec.Command(goBuildBin, append(args, "-o", dstPath, srcPath)...)
 	cmd.Stdout = os.Stdout
 	err = cmd.Run()
 	if err != nil {
@@ -0,0 +1,90 @@
+package transform
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLookupGoFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"main.go":               "//go:build !generated\n\npackage main\n",
+		"main_generated.go":     "//go:build generated\n\npackage main\n",
+		"main_test.go":          "package main\n",
+		"tagged.go":             "//go:build foo\n\npackage main\n",
+		"ignored.go":            "//go:build ignore\n\npackage main\n",
+		"os_plan9.go":           "package main\n",
+		"os_linux.go":           "package main\n",
+		"_hidden.go":            "package main\n",
+		".hidden.go":            "package main\n",
+		"sub/sub.go":            "package sub\n",
+		"testdata/src/input.go": "package input\n",
+	})
+
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.BuildTags = []string{"foo"}
+
+	files, err := LookupGoFiles(&ctxt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "main.go"),
+		filepath.Join(dir, "main_test.go"),
+		filepath.Join(dir, "os_linux.go"),
+		filepath.Join(dir, "tagged.go"),
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected %v, got %v", expected, files)
+	}
+}
+
+func TestLookupPackageDirs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"main.go":                "package main\n",
+		"pkg/a/a.go":             "package a\n",
+		"pkg/testdata/x.go":      "package x\n",
+		"vendor/v/v.go":          "package v\n",
+		"_skip/s.go":             "package s\n",
+		".git/HEAD":              "",
+		"got/extracted/x/x.go":   "package main\n",
+		"internal/b/b.go":        "package b\n",
+		"internal/b/_old/b.go":   "package b\n",
+		"internal/b/c/c_test.go": "package c\n",
+	})
+
+	dirs, err := LookupPackageDirs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		dir,
+		filepath.Join(dir, "internal"),
+		filepath.Join(dir, "internal/b"),
+		filepath.Join(dir, "internal/b/c"),
+		filepath.Join(dir, "pkg"),
+		filepath.Join(dir, "pkg/a"),
+	}
+	if !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("Expected %v, got %v", expected, dirs)
+	}
+}
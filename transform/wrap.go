@@ -0,0 +1,278 @@
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"reflect"
+)
+
+// WrapSpec describes the statements WrapFunc adds around the body of a
+// function. Each field builds its statements from the names of the
+// wrapped function, and can be nil.
+type WrapSpec struct {
+	// Before builds the statements run before the body.
+	Before func(w *Wrap) ([]ast.Stmt, error)
+
+	// After builds the statements run once the body returned, when its
+	// deferred calls are done. The results can be read and assigned.
+	After func(w *Wrap) ([]ast.Stmt, error)
+
+	// OnPanic builds the statements run when the body panics, with the
+	// recovered value. The panic goes on after them, unless Recover is
+	// true.
+	OnPanic func(w *Wrap) ([]ast.Stmt, error)
+
+	// Recover stops the panics of the body after OnPanic, if any, the
+	// function returning its results, which OnPanic can assign.
+	Recover bool
+}
+
+// Wrap holds the names of a function wrapped by WrapFunc. The receiver,
+// parameters and results without a name, or named `_`, are given a fresh
+// name.
+type Wrap struct {
+	// Func is the wrapped function.
+	Func *ast.FuncDecl
+
+	// Recv is the receiver of a method, or nil.
+	Recv *ast.Ident
+
+	// Args are the parameters of the function.
+	Args []*ast.Ident
+
+	// Results are the results of the function.
+	Results []*ast.Ident
+
+	// Err is the last result when it is an error, or nil.
+	Err *ast.Ident
+
+	// Recovered is the value recovered from a panic, in OnPanic.
+	Recovered *ast.Ident
+}
+
+// WrapFunc wraps the body of the function the attribute applies to with
+// the statements of the spec. The original body is moved into a closure
+// called by the function, so its early returns and deferred calls behave
+// as before:
+//
+//	func F(a int) (result int, err error) {
+//		// Before
+//		defer func() {
+//			if recovered := recover(); recovered != nil {
+//				// OnPanic
+//				panic(recovered)
+//			}
+//		}()
+//		result, err = func() (int, error) {
+//			// original body
+//		}()
+//		// After
+//		return result, err
+//	}
+//
+// Methods and generic functions are wrapped the same way.
+func (t *TransformContext) WrapFunc(spec WrapSpec) error {
+	fn, ok := t.Node().(*ast.FuncDecl)
+	if !ok {
+		return fmt.Errorf("Only functions can be wrapped, got a %s", nodeKind(t.Node()))
+	}
+	if fn.Body == nil {
+		return fmt.Errorf("Function `%s` has no body", fn.Name.Name)
+	}
+
+	// The closure returns the results of the original body, with their
+	// original names.
+	closureType := &ast.FuncType{Params: &ast.FieldList{}}
+	if fn.Type.Results != nil {
+		results, err := cloneFieldList(t.file.fset, fn.Type.Results)
+		if err != nil {
+			return err
+		}
+		closureType.Results = results
+	}
+
+	w := &Wrap{Func: fn}
+	if fn.Recv != nil {
+		w.Recv = t.nameFields(fn.Recv, "recv")[0]
+	}
+	w.Args = t.nameFields(fn.Type.Params, "arg")
+	w.Results = t.nameFields(fn.Type.Results, "result")
+	if n := len(w.Results); n > 0 {
+		if last := fn.Type.Results.List[len(fn.Type.Results.List)-1]; isErrorType(last.Type) {
+			w.Err = w.Results[n-1]
+		}
+	}
+
+	body := []ast.Stmt{}
+	if spec.Before != nil {
+		stmts, err := spec.Before(w)
+		if err != nil {
+			return err
+		}
+		body = append(body, stmts...)
+	}
+
+	if spec.OnPanic != nil {
+		w.Recovered = t.FreshName("recovered")
+		onPanic, err := spec.OnPanic(w)
+		if err != nil {
+			return err
+		}
+
+		template := "defer func() {\nif $r := recover(); $r != nil {\n$onPanic\npanic($r)\n}\n}()"
+		if spec.Recover {
+			template = "defer func() {\nif $r := recover(); $r != nil {\n$onPanic\n}\n}()"
+		}
+		stmts, err := quoteStmts(template, w.Recovered.Name, onPanic)
+		if err != nil {
+			return err
+		}
+		body = append(body, stmts...)
+	} else if spec.Recover {
+		stmts, err := quoteStmts("defer func() {\nrecover()\n}()")
+		if err != nil {
+			return err
+		}
+		body = append(body, stmts...)
+	}
+
+	original := fn.Body
+	closure := &ast.CallExpr{
+		Fun:    &ast.FuncLit{Type: closureType, Body: original},
+		Lparen: original.Rbrace,
+		Rparen: original.Rbrace,
+	}
+	if len(w.Results) > 0 {
+		lhs := []ast.Expr{}
+		for _, result := range w.Results {
+			lhs = append(lhs, ast.NewIdent(result.Name))
+		}
+		body = append(body, &ast.AssignStmt{Lhs: lhs, Tok: token.ASSIGN, Rhs: []ast.Expr{closure}})
+	} else {
+		body = append(body, &ast.ExprStmt{X: closure})
+	}
+	for _, stmt := range body {
+		anchorPositions(stmt, original.Lbrace)
+	}
+
+	after := []ast.Stmt{}
+	if spec.After != nil {
+		stmts, err := spec.After(w)
+		if err != nil {
+			return err
+		}
+		after = append(after, stmts...)
+	}
+
+	if len(w.Results) > 0 {
+		results := []ast.Expr{}
+		for _, result := range w.Results {
+			results = append(results, ast.NewIdent(result.Name))
+		}
+		after = append(after, &ast.ReturnStmt{Results: results})
+	}
+	for _, stmt := range after {
+		anchorPositions(stmt, original.Rbrace)
+	}
+
+	fn.Body = &ast.BlockStmt{
+		Lbrace: original.Lbrace,
+		List:   append(body, after...),
+		Rbrace: original.Rbrace,
+	}
+	t.Replace(fn)
+
+	return nil
+}
+
+// anchorPositions sets the missing positions of the nodes to the position,
+// so the comments of the original body are printed inside the closure: the
+// statements before the body are anchored to its opening brace, and the
+// statements after it to its closing brace.
+// The positions whose absence has a meaning, such as the ellipsis of a
+// variadic call, are left missing.
+func anchorPositions(root ast.Node, pos token.Pos) {
+	posType := reflect.TypeOf(token.NoPos)
+
+	ast.Inspect(root, func(node ast.Node) bool {
+		if node == nil {
+			return false
+		}
+
+		v := reflect.ValueOf(node).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if field.Type() != posType || field.Int() != 0 {
+				continue
+			}
+
+			switch node.(type) {
+			case *ast.CallExpr:
+				if v.Type().Field(i).Name == "Ellipsis" {
+					continue
+				}
+			case *ast.GenDecl:
+				continue
+			}
+			field.SetInt(int64(pos))
+		}
+
+		return true
+	})
+}
+
+// nameFields gives a fresh name to the fields of the list without a name,
+// or named `_`, and returns new identifiers for the names of all the
+// fields.
+func (t *TransformContext) nameFields(list *ast.FieldList, hint string) []*ast.Ident {
+	names := []*ast.Ident{}
+	if list == nil {
+		return names
+	}
+
+	for _, field := range list.List {
+		if len(field.Names) == 0 {
+			fieldHint := hint
+			if isErrorType(field.Type) {
+				fieldHint = "err"
+			}
+			field.Names = []*ast.Ident{t.FreshName(fieldHint)}
+		}
+
+		for i, name := range field.Names {
+			if name.Name == "_" {
+				field.Names[i] = t.FreshName(hint)
+			}
+			names = append(names, ast.NewIdent(field.Names[i].Name))
+		}
+	}
+
+	return names
+}
+
+// cloneFieldList returns a copy of a list of fields, without positions.
+func cloneFieldList(fset *token.FileSet, list *ast.FieldList) (*ast.FieldList, error) {
+	buf := bytes.NewBuffer([]byte{})
+	err := printer.Fprint(buf, fset, &ast.FuncType{Params: &ast.FieldList{}, Results: list})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to print fields: %v", err)
+	}
+
+	expr, err := parser.ParseExprFrom(token.NewFileSet(), "", buf.Bytes(), parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to copy fields: %v", err)
+	}
+	resetPositions(expr)
+
+	return expr.(*ast.FuncType).Results, nil
+}
+
+// isErrorType reports whether the type is the error type.
+func isErrorType(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == "error"
+}
@@ -0,0 +1,183 @@
+package transform
+
+import (
+	"go/ast"
+	"strings"
+	"testing"
+)
+
+func TestWrapFunc(t *testing.T) {
+	src := `package main
+
+import "fmt"
+
+type List[T any] struct{ items []T }
+
+// Get returns an item.
+// #[Log]
+func (_ *List[T]) Get(i int, _ string) (T, error) {
+	// check the index
+	if i < 0 {
+		return *new(T), fmt.Errorf("negative")
+	}
+	defer fmt.Println("done")
+	var zero T
+	return zero, nil
+}
+
+// #[Log]
+func Named(a int) (n int) {
+	n = a
+	return
+}
+`
+	expected := `package main
+
+import "fmt"
+
+type List[T any] struct{ items []T }
+
+// Get returns an item.
+func (recv *List[T]) Get(i int, arg string) (result T, err error) {
+	fmt.Println("Get", i, arg)
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			fmt.Println("panic", recovered)
+			panic(recovered)
+		}
+	}()
+	result, err = func() (T, error) {
+		// check the index
+		if i < 0 {
+			return *new(T), fmt.Errorf("negative")
+		}
+		defer fmt.Println("done")
+		var zero T
+		return zero, nil
+	}()
+	fmt.Println("Get", result, err)
+	return result, err
+}
+
+func Named(a int) (n int) {
+	fmt.Println("Named", a)
+	defer func() {
+		if recovered1 := recover(); recovered1 != nil {
+			fmt.Println("panic", recovered1)
+			panic(recovered1)
+		}
+	}()
+	n = func() (n int) {
+		n = a
+		return
+	}()
+	fmt.Println("Named", n)
+	return n
+}
+`
+
+	out := transformTestSource(t, map[string]ExtractedDecorator{"Log": func(c *TransformContext) error {
+		name := c.Node().(*ast.FuncDecl).Name.Name
+		return c.WrapFunc(WrapSpec{
+			Before: func(w *Wrap) ([]ast.Stmt, error) {
+				return c.Stmts(`fmt.Println(%q, $args...)`, name, w.Args)
+			},
+			After: func(w *Wrap) ([]ast.Stmt, error) {
+				return c.Stmts(`fmt.Println(%q, $results...)`, name, w.Results)
+			},
+			OnPanic: func(w *Wrap) ([]ast.Stmt, error) {
+				return c.Stmts(`fmt.Println("panic", $r)`, w.Recovered)
+			},
+		})
+	}}, src)
+
+	if !strings.HasSuffix(out, expected) {
+		t.Errorf("Expected %s, got %s", expected, out)
+	}
+}
+
+func TestWrapFuncRecover(t *testing.T) {
+	src := `package main
+
+import "fmt"
+
+// #[Safe]
+func Div(a, b int) (int, error) {
+	return a / b, nil
+}
+
+// #[Safe]
+func Run() {
+	fmt.Println("run")
+}
+
+// #[Quiet]
+func Stop() (n int) {
+	panic("stop")
+}
+`
+	expected := `func Div(a, b int) (result int, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	result, err = func() (int, error) {
+		return a / b, nil
+	}()
+	return result, err
+}
+
+func Run() {
+	defer func() {
+		if recovered1 := recover(); recovered1 != nil {
+			fmt.Println("panic", recovered1)
+		}
+	}()
+	func() {
+		fmt.Println("run")
+	}()
+}
+
+func Stop() (n int) {
+	defer func() { recover() }()
+	n = func() (n int) {
+		panic("stop")
+	}()
+	return n
+}
+`
+
+	out := transformTestSource(t, map[string]ExtractedDecorator{"Safe": func(c *TransformContext) error {
+		return c.WrapFunc(WrapSpec{
+			OnPanic: func(w *Wrap) ([]ast.Stmt, error) {
+				if w.Err == nil {
+					return c.Stmts(`fmt.Println("panic", $r)`, w.Recovered)
+				}
+				return c.Stmts(`$err = fmt.Errorf("panic: %%v", $r)`, w.Err, w.Recovered)
+			},
+			Recover: true,
+		})
+	}, "Quiet": func(c *TransformContext) error {
+		return c.WrapFunc(WrapSpec{Recover: true})
+	}}, src)
+
+	if !strings.HasSuffix(out, expected) {
+		t.Errorf("Expected %s, got %s", expected, out)
+	}
+}
+
+func TestWrapFuncErrors(t *testing.T) {
+	src := "package main\n\n// #[Wrap]\nvar x = 1\n"
+
+	var err error
+	transformTestSource(t, map[string]ExtractedDecorator{"Wrap": func(c *TransformContext) error {
+		err = c.WrapFunc(WrapSpec{})
+		return nil
+	}}, src)
+
+	expected := "Only functions can be wrapped, got a GenDecl"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+}